@@ -22,14 +22,16 @@ import (
 
 	"github.com/clearlinux/clr-installer/conf"
 	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/proxy"
 	flag "github.com/spf13/pflag"
 )
 
 const (
-	kernelCmdlineConf = "clri.descriptor"
-	kernelCmdlineDemo = "clri.demo"
-	kernelCmdlineLog  = "clri.loglevel"
-	logFileEnvironVar = "CLR_INSTALLER_LOG_FILE"
+	kernelCmdlineConf  = "clri.descriptor"
+	kernelCmdlineDemo  = "clri.demo"
+	kernelCmdlineLog   = "clri.loglevel"
+	kernelCmdlineProxy = "clri.proxy"
+	logFileEnvironVar  = "CLR_INSTALLER_LOG_FILE"
 )
 
 var (
@@ -64,6 +66,14 @@ type Args struct {
 	DemoMode                bool
 	BlockDevices            []string
 	StubImage               bool
+	Plan                    bool
+	ProgressJSON            string
+	RESTAddress             string
+	RESTTokenFile           string
+	RESTTLSCert             string
+	RESTTLSKey              string
+	Resume                  bool
+	Proxy                   string
 }
 
 func (args *Args) setKernelArgs() (err error) {
@@ -83,6 +93,8 @@ func (args *Args) setKernelArgs() (err error) {
 			url = strings.Split(curr, "=")[1]
 		} else if strings.HasPrefix(curr, kernelCmdlineDemo) {
 			args.DemoMode = true
+		} else if strings.HasPrefix(curr, kernelCmdlineProxy+"=") {
+			args.Proxy = strings.SplitN(curr, "=", 2)[1]
 		} else if strings.HasPrefix(curr, kernelCmdlineLog) {
 			logLevelString := strings.Split(curr, "=")[1]
 			if logLevel, _ := strconv.Atoi(logLevelString); err != nil {
@@ -96,6 +108,11 @@ func (args *Args) setKernelArgs() (err error) {
 	if url != "" {
 		var ffile string
 
+		// the descriptor may only be reachable through the proxy
+		if args.Proxy != "" {
+			proxy.Set(proxy.Config{HTTP: args.Proxy, HTTPS: args.Proxy})
+		}
+
 		if ffile, err = conf.FetchRemoteConfigFile(url); err != nil {
 			return err
 		}
@@ -147,6 +164,10 @@ func (args *Args) setCommandLineArgs() (err error) {
 		&args.SwupdMirror, "swupd-mirror", args.SwupdMirror, "Swupd Installation mirror URL",
 	)
 
+	flag.StringVar(
+		&args.Proxy, "proxy", args.Proxy, "HTTP and HTTPS proxy URL, overrides the configuration file's proxies",
+	)
+
 	flag.StringVar(
 		&args.SwupdStateDir, "swupd-state", args.SwupdMirror, "Swupd state-dir",
 	)
@@ -178,6 +199,39 @@ func (args *Args) setCommandLineArgs() (err error) {
 		&args.StubImage, "stub-image", "S", args.StubImage, "Creates the filesystems only - dont perform an actual install",
 	)
 
+	flag.BoolVar(
+		&args.Plan, "plan", args.Plan, "Prints the installation operations without performing any of them",
+	)
+
+	flag.BoolVar(
+		&args.Resume, "resume", args.Resume,
+		"Resumes an interrupted install, the phases completed by the previous attempt are skipped",
+	)
+
+	flag.StringVar(
+		&args.ProgressJSON, "progress-json", args.ProgressJSON,
+		"Writes the progress as JSON events to a file or FIFO, '-' for stdout",
+	)
+
+	flag.StringVar(
+		&args.RESTAddress, "rest-address", args.RESTAddress,
+		"Serves the REST API on the <host:port> address and waits for the remote install requests, "+
+			"an empty host is 127.0.0.1",
+	)
+
+	flag.StringVar(
+		&args.RESTTokenFile, "rest-token-file", args.RESTTokenFile,
+		"File with the bearer token the REST API clients must provide, a random token is printed if not set",
+	)
+
+	flag.StringVar(
+		&args.RESTTLSCert, "rest-tls-cert", args.RESTTLSCert, "Certificate file of the REST API HTTPS server",
+	)
+
+	flag.StringVar(
+		&args.RESTTLSKey, "rest-tls-key", args.RESTTLSKey, "Private key file of the REST API HTTPS server",
+	)
+
 	flag.StringVar(
 		&args.TelemetryURL, "telemetry-url", args.TelemetryURL, "Telemetry server URL",
 	)
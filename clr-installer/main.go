@@ -18,6 +18,7 @@ import (
 	"github.com/clearlinux/clr-installer/args"
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/conf"
+	"github.com/clearlinux/clr-installer/controller"
 	"github.com/clearlinux/clr-installer/crypt"
 	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/frontend"
@@ -26,6 +27,8 @@ import (
 	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/massinstall"
 	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/proxy"
+	"github.com/clearlinux/clr-installer/restapi"
 	"github.com/clearlinux/clr-installer/swupd"
 	"github.com/clearlinux/clr-installer/telemetry"
 	"github.com/clearlinux/clr-installer/timezone"
@@ -45,6 +48,7 @@ func fatal(err error) {
 
 func initFrontendList() {
 	frontEndImpls = []frontend.Frontend{
+		restapi.New(),
 		massinstall.New(),
 		tui.New(),
 	}
@@ -100,6 +104,20 @@ func validateTelemetry(options args.Args, md *model.SystemInstall) error {
 	return nil
 }
 
+// printPlan prints the operations the installation would perform
+func printPlan(md *model.SystemInstall, rootDir string, options args.Args) error {
+	lines, err := controller.Plan(rootDir, md, options)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
 func main() {
 	var options args.Args
 
@@ -203,9 +221,18 @@ func main() {
 		md.SwupdMirror = options.SwupdMirror
 	}
 
+	if options.Proxy != "" {
+		md.HTTPProxy = options.Proxy
+		md.HTTPSProxy = options.Proxy
+	}
+
+	// the proxy is used from the mirror validation on
+	proxy.Set(md.Proxy())
+
 	if !options.StubImage {
-		// Now validate the mirror from the config or command line
-		if md.SwupdMirror != "" {
+		// Now validate the mirror from the config or command line, the offline
+		// installs only set it to the target and the plans leave the host untouched
+		if md.SwupdMirror != "" && !md.IsOffline() && !options.Plan {
 			var url string
 			url, err = swupd.SetHostMirror(md.SwupdMirror)
 			if err != nil {
@@ -232,6 +259,13 @@ func main() {
 		fatal(fmt.Errorf("Invalid Language '%s'", md.Language.Code))
 	}
 
+	if options.Plan {
+		if err = printPlan(md, rootDir, options); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
 	installReboot := false
 
 	go func() {
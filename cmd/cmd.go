@@ -5,10 +5,7 @@
 package cmd
 
 import (
-	"fmt"
 	"io"
-	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/clearlinux/clr-installer/log"
@@ -16,15 +13,6 @@ import (
 
 type runLogger struct{}
 
-var (
-	httpsProxy string
-)
-
-// SetHTTPSProxy defines the HTTPS_PROXY env var value for all the cmd executions
-func SetHTTPSProxy(addr string) {
-	httpsProxy = addr
-}
-
 func (rl runLogger) Write(p []byte) (n int, err error) {
 	for _, curr := range strings.Split(string(p), "\n") {
 		if curr == "" {
@@ -45,74 +33,29 @@ func RunAndLog(args ...string) error {
 // RunAndLogWithEnv does the same as RunAndLog but it changes the execution's environment
 // variables adding the provided ones by the env argument
 func RunAndLogWithEnv(env map[string]string, args ...string) error {
-	return run(nil, runLogger{}, env, args...)
+	return run(runLogger{}, env, args...)
 }
 
 // PipeRunAndLog is similar to RunAndLog runs a command and writes the output
 // to default logger and also writes in to the process stdin
 func PipeRunAndLog(in string, args ...string) error {
-	return run(func(cmd *exec.Cmd) error {
-		stdin, err := cmd.StdinPipe()
-		if err != nil {
-			return err
-		}
-
-		go func() {
-			defer func() {
-				_ = stdin.Close()
-			}()
-
-			_, _ = io.WriteString(stdin, in)
-		}()
-
-		return nil
-	}, runLogger{}, nil, args...)
+	return Exec(nil, &Command{
+		Args:   args,
+		Stdin:  strings.NewReader(in),
+		Writer: runLogger{},
+	})
 }
 
-func run(sw func(cmd *exec.Cmd) error, writer io.Writer, env map[string]string, args ...string) error {
-	var exe string
-	var cmdArgs []string
-
-	log.Debug("%s", strings.Join(args, " "))
-
-	exe = args[0]
-	cmdArgs = args[1:]
-
-	cmd := exec.Command(exe, cmdArgs...)
-
-	if httpsProxy != "" {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("https_proxy=%s", httpsProxy))
-	}
-
-	if sw != nil {
-		if err := sw(cmd); err != nil {
-			return err
-		}
-	}
-
-	cmd.Stdout = writer
-	cmd.Stderr = writer
-
-	if cmd.Stdin == nil {
-		cmd.Stdin = os.Stdin
-	}
-
-	for k, v := range env {
-		curr := fmt.Sprintf("%s=%s", k, v)
-		cmd.Args = append(cmd.Args, curr)
-		cmd.Env = append(cmd.Env, curr)
-	}
-
-	err := cmd.Run()
-	if err != nil {
-		return err
-	}
-
-	return nil
+func run(writer io.Writer, env map[string]string, args ...string) error {
+	return Exec(nil, &Command{
+		Args:   args,
+		Env:    env,
+		Writer: writer,
+	})
 }
 
 // Run executes a command and uses writer to write both stdout and stderr
 // args are the actual command and its arguments
 func Run(writer io.Writer, args ...string) error {
-	return run(nil, writer, nil, args...)
+	return run(writer, nil, args...)
 }
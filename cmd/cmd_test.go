@@ -0,0 +1,86 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	w := bytes.NewBuffer(nil)
+
+	if err := Run(w, "echo", "-n", "hello"); err != nil {
+		t.Fatalf("Failed to run echo: %s", err)
+	}
+
+	if w.String() != "hello" {
+		t.Fatalf("Invalid output: %q", w.String())
+	}
+
+	if err := Run(w, "false"); err == nil {
+		t.Fatalf("Running false should fail")
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	rec.Script("sda\n", nil, "lsblk")
+	rec.Script("", fmt.Errorf("failed"), "parted", "-s")
+
+	prev := SetExecutor(rec)
+	defer SetExecutor(prev)
+
+	w := bytes.NewBuffer(nil)
+	if err := Run(w, "lsblk", "-J"); err != nil {
+		t.Fatalf("Scripted lsblk should not fail: %s", err)
+	}
+
+	if w.String() != "sda\n" {
+		t.Fatalf("Invalid scripted output: %q", w.String())
+	}
+
+	if err := RunAndLog("parted", "-s", "/dev/sda", "mklabel", "gpt"); err == nil {
+		t.Fatalf("Scripted parted should fail")
+	}
+
+	if err := PipeRunAndLog("secret", "chpasswd", "-e"); err != nil {
+		t.Fatalf("Unscripted command should not fail: %s", err)
+	}
+
+	expected := []string{
+		"lsblk -J",
+		"parted -s /dev/sda mklabel gpt",
+		"chpasswd -e",
+	}
+
+	lines := rec.Lines()
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Invalid recorded commands: %v, expected: %v", lines, expected)
+	}
+
+	if in := rec.Commands()[2].Stdin; in != "secret" {
+		t.Fatalf("Invalid recorded stdin: %q", in)
+	}
+
+	rec.Reset()
+	if len(rec.Commands()) != 0 {
+		t.Fatalf("Reset() should drop the recorded commands")
+	}
+}
+
+func TestExecWith(t *testing.T) {
+	rec := NewRecorder()
+
+	if err := Exec(rec, &Command{Args: []string{"reboot"}}); err != nil {
+		t.Fatalf("Recorded command should not fail: %s", err)
+	}
+
+	if lines := rec.Lines(); len(lines) != 1 || lines[0] != "reboot" {
+		t.Fatalf("Invalid recorded commands: %v", lines)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/proxy"
+)
+
+// A Command describes a single command execution
+type Command struct {
+	Args   []string          // the command and its arguments
+	Env    map[string]string // extra environment variables
+	Stdin  io.Reader         // the process stdin, os.Stdin if nil
+	Writer io.Writer         // receives both stdout and stderr
+}
+
+// An Executor runs the commands requested through the cmd package functions
+type Executor interface {
+	Exec(c *Command) error
+}
+
+// execExecutor is the default Executor, it runs the commands with os/exec
+type execExecutor struct{}
+
+var (
+	executor      Executor = execExecutor{}
+	executorMutex sync.RWMutex
+)
+
+// SetExecutor replaces the executor used by all the cmd package functions and returns
+// the previous one so it can be restored, a nil e restores the default executor
+func SetExecutor(e Executor) Executor {
+	executorMutex.Lock()
+	defer executorMutex.Unlock()
+
+	prev := executor
+
+	if e == nil {
+		e = execExecutor{}
+	}
+
+	executor = e
+
+	return prev
+}
+
+// Exec runs c with the e executor, if e is nil the executor set with SetExecutor()
+// is used
+func Exec(e Executor, c *Command) error {
+	if e == nil {
+		executorMutex.RLock()
+		e = executor
+		executorMutex.RUnlock()
+	}
+
+	log.Debug("%s", strings.Join(c.Args, " "))
+
+	return e.Exec(c)
+}
+
+// Exec is the Executor implementation
+func (ee execExecutor) Exec(c *Command) error {
+	exe := c.Args[0]
+	cmdArgs := c.Args[1:]
+
+	cmd := exec.Command(exe, cmdArgs...)
+
+	// the proxy configuration is added to the installer's environment
+	if env := proxy.Get().Env(); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	cmd.Stdout = c.Writer
+	cmd.Stderr = c.Writer
+
+	cmd.Stdin = c.Stdin
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+
+	for k, v := range c.Env {
+		curr := fmt.Sprintf("%s=%s", k, v)
+		cmd.Args = append(cmd.Args, curr)
+		cmd.Env = append(cmd.Env, curr)
+	}
+
+	return cmd.Run()
+}
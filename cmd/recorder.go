@@ -0,0 +1,131 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// A Recorder is a fake Executor, it records the requested commands instead of running
+// them and replies with the scripted outputs and errors
+type Recorder struct {
+	mutex    sync.Mutex
+	commands []*RecordedCommand
+	scripts  []*scriptedCommand
+}
+
+// RecordedCommand is a command execution as seen by a Recorder
+type RecordedCommand struct {
+	Args  []string          // the command and its arguments
+	Env   map[string]string // extra environment variables
+	Stdin string            // the content written to the process stdin
+}
+
+// scriptedCommand is the result replied to commands starting with prefix
+type scriptedCommand struct {
+	prefix []string
+	output string
+	err    error
+}
+
+// NewRecorder returns a new Recorder, without scripts all the commands succeed
+// with no output
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Script makes the commands starting with the args prefix write output and return err,
+// the most recent matching script wins
+func (r *Recorder) Script(output string, err error, args ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.scripts = append(r.scripts, &scriptedCommand{args, output, err})
+}
+
+func (sc *scriptedCommand) matches(args []string) bool {
+	if len(sc.prefix) > len(args) {
+		return false
+	}
+
+	for idx, curr := range sc.prefix {
+		if args[idx] != curr {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Exec is the Executor implementation
+func (r *Recorder) Exec(c *Command) error {
+	rc := &RecordedCommand{
+		Args: append([]string{}, c.Args...),
+		Env:  c.Env,
+	}
+
+	if c.Stdin != nil {
+		in, err := ioutil.ReadAll(c.Stdin)
+		if err != nil {
+			return err
+		}
+
+		rc.Stdin = string(in)
+	}
+
+	r.mutex.Lock()
+	r.commands = append(r.commands, rc)
+
+	var sc *scriptedCommand
+	for idx := len(r.scripts) - 1; idx >= 0; idx-- {
+		if r.scripts[idx].matches(c.Args) {
+			sc = r.scripts[idx]
+			break
+		}
+	}
+	r.mutex.Unlock()
+
+	if sc == nil {
+		return nil
+	}
+
+	if c.Writer != nil && sc.output != "" {
+		if _, err := io.WriteString(c.Writer, sc.output); err != nil {
+			return err
+		}
+	}
+
+	return sc.err
+}
+
+// Commands returns the recorded commands in the execution order
+func (r *Recorder) Commands() []*RecordedCommand {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]*RecordedCommand{}, r.commands...)
+}
+
+// Lines returns the recorded commands as space separated strings
+func (r *Recorder) Lines() []string {
+	res := []string{}
+
+	for _, curr := range r.Commands() {
+		res = append(res, strings.Join(curr.Args, " "))
+	}
+
+	return res
+}
+
+// Reset drops the recorded commands, the scripts are kept
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.commands = nil
+}
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/clearlinux/clr-installer/proxy"
 	"github.com/clearlinux/clr-installer/utils"
 )
 
@@ -25,6 +26,9 @@ const (
 	// ConfigFile is the install descriptor
 	ConfigFile = "clr-installer.yaml"
 
+	// CheckpointFile records the completed install phases of an interrupted install
+	CheckpointFile = "clr-installer-checkpoint.yaml"
+
 	// ChpasswdPAMFile is the chpasswd pam configuration file
 	ChpasswdPAMFile = "chpasswd"
 
@@ -100,8 +104,9 @@ func LookupDefaultConfig() (string, error) {
 	return lookupDefaultFile(ConfigFile)
 }
 
-// FetchRemoteConfigFile given an config url fetches it from the network. This function
-// currently supports only http/https protocol. After success return the local file path.
+// FetchRemoteConfigFile given an config url fetches it from the network through the
+// configured proxy. This function currently supports only http/https protocol. After
+// success return the local file path.
 func FetchRemoteConfigFile(url string) (string, error) {
 	out, err := ioutil.TempFile("", "clr-installer-yaml-")
 	if err != nil {
@@ -111,7 +116,9 @@ func FetchRemoteConfigFile(url string) (string, error) {
 		_ = out.Close()
 	}()
 
-	resp, err := http.Get(url)
+	client := &http.Client{Transport: proxy.NewTransport()}
+
+	resp, err := client.Get(url)
 	if err != nil {
 		defer func() { _ = os.Remove(out.Name()) }()
 		return "", err
@@ -0,0 +1,128 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/conf"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// checkpoint records the install phases completed so far, an interrupted install
+// can be resumed from the first phase not completed
+type checkpoint struct {
+	path    string
+	Version string   `yaml:"version"`
+	Digest  string   `yaml:"digest"`
+	Phases  []string `yaml:"phases"`
+}
+
+// getCheckpointFile returns the checkpoint file path, it's kept next to the log file
+// so it survives the removal of the install's temporary directories
+func getCheckpointFile(options args.Args) string {
+	return filepath.Join(filepath.Dir(options.LogFile), conf.CheckpointFile)
+}
+
+// descriptorDigest returns the digest of the install descriptor, an install can
+// only be resumed with the same descriptor it was started with
+func descriptorDigest(md *model.SystemInstall) (string, error) {
+	b, err := yaml.Marshal(md)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// loadCheckpoint returns the checkpoint of the md install, the previous checkpoint
+// is only loaded if resume is true; otherwise a new install is started
+func loadCheckpoint(path string, md *model.SystemInstall, resume bool) (*checkpoint, error) {
+	digest, err := descriptorDigest(md)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &checkpoint{path: path, Digest: digest, Phases: []string{}}
+
+	if !resume {
+		cp.remove()
+		return cp, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warning("No checkpoint found at %s, starting a new install", path)
+		return cp, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	prev := &checkpoint{}
+	if err = yaml.Unmarshal(content, prev); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if prev.Digest != digest {
+		return nil, errors.Errorf("The install descriptor has changed since %s was written, can not resume",
+			path)
+	}
+
+	cp.Version = prev.Version
+	cp.Phases = prev.Phases
+
+	log.Info("Resuming the install, completed phases: %v", cp.Phases)
+
+	return cp, nil
+}
+
+// isDone returns true if phase was completed
+func (cp *checkpoint) isDone(phase string) bool {
+	return utils.StringSliceContains(cp.Phases, phase)
+}
+
+// complete records phase as completed, failing to write the checkpoint only
+// prevents a later resume so it's not an install error. A checkpoint without a
+// path is kept in memory only
+func (cp *checkpoint) complete(phase string) {
+	if !cp.isDone(phase) {
+		cp.Phases = append(cp.Phases, phase)
+	}
+
+	if cp.path == "" {
+		return
+	}
+
+	b, err := yaml.Marshal(cp)
+	if err != nil {
+		log.Warning("Failed to generate the install checkpoint: %v", err)
+		return
+	}
+
+	if err = ioutil.WriteFile(cp.path, b, 0600); err != nil {
+		log.Warning("Failed to write the install checkpoint %q: %v", cp.path, err)
+	}
+}
+
+// remove removes the checkpoint file
+func (cp *checkpoint) remove() {
+	if cp.path == "" {
+		return
+	}
+
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		log.Warning("Failed to remove the install checkpoint %q: %v", cp.path, err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/model"
+)
+
+func TestResumePhases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-checkpoint-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "checkpoint.yaml")
+	md := &model.SystemInstall{Hostname: "clr-test"}
+
+	calls := []string{}
+	failAt := "bundles"
+
+	record := func(name string) func(st *installState) error {
+		return func(st *installState) error {
+			calls = append(calls, name)
+			if name == failAt {
+				return errors.Errorf("%s failed", name)
+			}
+			return nil
+		}
+	}
+
+	saved := installPhases
+	defer func() { installPhases = saved }()
+
+	installPhases = []*installPhase{
+		{name: "partition", run: record("partition")},
+		{name: "format", run: record("format"), resume: record("activate")},
+		{name: "bundles", run: record("bundles")},
+		{name: "finalize", run: record("finalize")},
+	}
+
+	cp, err := loadCheckpoint(path, md, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Version = "25000"
+
+	if err = runPhases(&installState{}, cp); err == nil {
+		t.Fatal("The failing phase should interrupt the install")
+	}
+
+	if !reflect.DeepEqual(calls, []string{"partition", "format", "bundles"}) {
+		t.Fatalf("Unexpected phases run: %v", calls)
+	}
+
+	calls = []string{}
+	failAt = ""
+
+	if cp, err = loadCheckpoint(path, md, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if cp.Version != "25000" {
+		t.Fatalf("The resumed install should keep the version, got %q", cp.Version)
+	}
+
+	if err = runPhases(&installState{}, cp); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(calls, []string{"activate", "bundles", "finalize"}) {
+		t.Fatalf("Unexpected phases run on resume: %v", calls)
+	}
+
+	md.Hostname = "changed"
+	if _, err = loadCheckpoint(path, md, true); err == nil {
+		t.Fatal("A changed descriptor should not be resumed")
+	}
+
+	// a new install drops the previous checkpoint
+	if cp, err = loadCheckpoint(path, md, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cp.Phases) != 0 {
+		t.Fatalf("A new install should not have completed phases: %v", cp.Phases)
+	}
+
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("The previous checkpoint should be removed")
+	}
+}
@@ -20,13 +20,13 @@ import (
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/conf"
 	"github.com/clearlinux/clr-installer/errors"
-	"github.com/clearlinux/clr-installer/hostname"
 	"github.com/clearlinux/clr-installer/keyboard"
 	"github.com/clearlinux/clr-installer/language"
 	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/model"
 	"github.com/clearlinux/clr-installer/network"
 	"github.com/clearlinux/clr-installer/progress"
+	"github.com/clearlinux/clr-installer/proxy"
 	"github.com/clearlinux/clr-installer/storage"
 	"github.com/clearlinux/clr-installer/swupd"
 	"github.com/clearlinux/clr-installer/telemetry"
@@ -35,6 +35,10 @@ import (
 	"github.com/clearlinux/clr-installer/utils"
 )
 
+// diskSpaceMargin is the percentage added to the bundles content size when checking
+// the target file systems are large enough
+const diskSpaceMargin = 20
+
 var (
 	// NetworkPassing is used to track if the latest network configuration
 	// is passing; changes in proxy, etc.
@@ -50,26 +54,14 @@ func sortMountPoint(bds []*storage.BlockDevice) []*storage.BlockDevice {
 }
 
 // Install is the main install controller, this is the entry point for a full
-// installation
+// installation; the install is split in phases which are recorded in a checkpoint
+// file so an interrupted install can be resumed with --resume
 func Install(rootDir string, model *model.SystemInstall, options args.Args) error {
 	var err error
-	var version string
-	var versionBuf []byte
-	var prg progress.Progress
-	var encryptedUsed bool
-
-	vars := map[string]string{
-		"chrootDir": rootDir,
-		"yamlDir":   filepath.Dir(options.ConfigFile),
-	}
-
-	for k, v := range model.Environment {
-		vars[k] = v
-	}
 
 	preConfFile := filepath.Join(filepath.Dir(options.LogFile), "pre-install-"+conf.ConfigFile)
 
-	if err = model.WriteFile(preConfFile); err != nil {
+	if err = writeRedactedFile(model, preConfFile); err != nil {
 		log.Error("Failed to write pre-install YAML file (%v) %q", err, preConfFile)
 	}
 
@@ -80,17 +72,52 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
+	cp, err := loadCheckpoint(getCheckpointFile(options), model, options.Resume)
+	if err != nil {
+		return err
+	}
+
+	st := &installState{
+		rootDir:  rootDir,
+		hostRoot: "/",
+		model:    model,
+		options:  options,
+	}
+
+	return st.install(cp)
+}
+
+// install runs the install steps not yet completed according to cp
+func (st *installState) install(cp *checkpoint) error {
+	var err error
+	var version string
+
+	model := st.model
+	options := st.options
+	rootDir := st.rootDir
+
+	st.vars = map[string]string{
+		"chrootDir": rootDir,
+		"yamlDir":   filepath.Dir(options.ConfigFile),
+	}
+
+	for k, v := range model.Environment {
+		st.vars[k] = v
+	}
+
 	if !options.StubImage {
-		if err = applyHooks("pre-install", vars, model.PreInstall); err != nil {
+		if len(cp.Phases) > 0 {
+			log.Info("Skipping the pre-install hooks, already run by the resumed install")
+		} else if err = applyHooks("pre-install", st.vars, model.PreInstall); err != nil {
 			return err
 		}
 
 		if model.Telemetry.Enabled {
-			if err = model.Telemetry.CreateLocalTelemetryConf(); err != nil {
+			if err = model.Telemetry.CreateLocalTelemetryConf(st.hostRoot); err != nil {
 				return err
 			}
 			if model.Telemetry.URL != "" {
-				if err = model.Telemetry.UpdateLocalTelemetryServer(); err != nil {
+				if err = model.Telemetry.UpdateLocalTelemetryServer(st.hostRoot); err != nil {
 					return err
 				}
 			}
@@ -100,26 +127,14 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
-	if model.Version == 0 {
-		log.Info("Querying Clear Linux version")
-
-		// in order to avoid issues raised by format bumps between installers image
-		// version and the latest released we assume the installers host version
-		// in other words we use the same version swupd is based on
-		if versionBuf, err = ioutil.ReadFile("/usr/lib/os-release"); err != nil {
-			return errors.Errorf("Read version file /usr/lib/os-release: %v", err)
-		}
-		versionExp := regexp.MustCompile(`VERSION_ID=([0-9][0-9]*)`)
-		match := versionExp.FindSubmatch(versionBuf)
-
-		if len(match) < 2 {
-			return errors.Errorf("Version not found in /usr/lib/os-release")
+	// a resumed install keeps installing the version it was started with
+	if version = cp.Version; version == "" {
+		if version, err = getInstallVersion(model); err != nil {
+			return err
 		}
-
-		version = string(match[1])
-	} else {
-		version = fmt.Sprintf("%d", model.Version)
 	}
+	cp.Version = version
+	st.version = version
 
 	log.Debug("Clear Linux version: %s", version)
 
@@ -128,13 +143,39 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		return err
 	}
 
-	// Using MassInstaller (non-UI) the network will not have been checked yet
-	if !NetworkPassing && !options.StubImage {
+	if model.IsOffline() {
+		log.Info("Installing from the offline content: %s", model.OfflineContent)
+
+		if err = swupd.CheckOfflineContent(model.OfflineContent, version); err != nil {
+			return err
+		}
+	} else if st.plan && !options.StubImage {
+		// the plans always show the installer's network configuration
+		if err = configureNetwork(st.hostRoot, model); err != nil {
+			return err
+		}
+	} else if !NetworkPassing && !options.StubImage {
+		// Using MassInstaller (non-UI) the network will not have been checked yet
 		if err = ConfigureNetwork(model); err != nil {
 			return err
 		}
 	}
 
+	// make sure the selected bundles are available before touching the target
+	if !options.StubImage {
+		if st.catalogue, err = loadBundleCatalogue(model, version, options); err != nil {
+			return err
+		}
+
+		if err = st.catalogue.Validate(getInstallBundles(model)); err != nil {
+			return err
+		}
+
+		if err = validateDiskSpace(model, st.catalogue, false); err != nil {
+			return err
+		}
+	}
+
 	expandMe := []*storage.BlockDevice{}
 	detachMe := []string{}
 	aliasMap := map[string]string{}
@@ -148,17 +189,25 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 			continue
 		}
 
-		// create the image and add the alias name to the variable expansion list
+		// create the image and add the alias name to the variable expansion list,
+		// a resumed install reuses the image it has already partitioned
 		for _, tm := range model.TargetMedias {
 			if tm.Name == fmt.Sprintf("${%s}", alias.Name) {
-				if err = storage.MakeImage(tm, alias.File); err != nil {
-					return err
+				if !cp.isDone(PhasePartition) {
+					if err = storage.MakeImage(tm, alias.File); err != nil {
+						return err
+					}
 				}
 
 				expandMe = append(expandMe, tm)
 			}
 		}
 
+		// the plans keep referring to the image by its alias name
+		if st.plan {
+			continue
+		}
+
 		file, err = storage.SetupLoopDevice(alias.File)
 		if err != nil {
 			return errors.Wrap(err)
@@ -202,85 +251,66 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		tm.ExpandName(aliasMap)
 	}
 
-	mountPoints := []*storage.BlockDevice{}
-
-	// prepare all the target block devices
-	for _, curr := range model.TargetMedias {
-		// based on the description given, write the partition table
-		if err = curr.WritePartitionTable(model.LegacyBios); err != nil {
-			return err
-		}
-
-		// prepare the blockdevice's partitions filesystem
-		for _, ch := range curr.Children {
-			if ch.Type == storage.BlockDeviceTypeCrypt {
-				encryptedUsed = true
-
-				if ch.FsTypeNotSwap() {
-					msg := fmt.Sprintf("Mapping %s partition to an encrypted partition", ch.Name)
-					prg = progress.NewLoop(msg)
-					log.Info(msg)
-					if err = ch.MapEncrypted(model.CryptPass); err != nil {
-						return err
-					}
-					prg.Success()
-				}
-			}
+	// match the partitions to be reused before touching any target media
+	if err = storage.ResolveExistingPartitions(model.TargetMedias); err != nil {
+		return err
+	}
 
-			msg := fmt.Sprintf("Writing %s file system to %s", ch.FsType, ch.Name)
-			if ch.MountPoint != "" {
-				msg = msg + fmt.Sprintf(" '%s'", ch.MountPoint)
-			}
-			prg = progress.NewLoop(msg)
-			log.Info(msg)
-			if err = ch.MakeFs(); err != nil {
-				return err
+	if !options.StubImage {
+		// release the mounts, mappings and volumes of this attempt even if it
+		// fails, a resumed install brings them up again
+		defer func() {
+			log.Info("Umounting rootDir: %s", rootDir)
+			if storage.UmountAll() != nil {
+				log.Warning("Failed to umount volumes")
+				return
 			}
-			prg.Success()
 
-			// if we have a mount point set it for future mounting
-			if ch.MountPoint != "" {
-				mountPoints = append(mountPoints, ch)
+			log.Info("Removing rootDir: %s", rootDir)
+			if err := os.RemoveAll(rootDir); err != nil {
+				log.Warning("Failed to remove rootDir: %s", rootDir)
 			}
-		}
+		}()
 	}
 
-	// Update the target devices current labels and UUIDs
-	if scanErr := storage.UpdateBlockDevices(model.TargetMedias); scanErr != nil {
-		return scanErr
+	if err = runPhases(st, cp); err != nil {
+		log.Info("Install interrupted, completed phases: %v", cp.Phases)
+		return err
 	}
 
-	if options.StubImage {
-		return nil
-	}
+	cp.remove()
 
-	// mount all the prepared partitions
-	for _, curr := range sortMountPoint(mountPoints) {
-		log.Info("Mounting: %s", curr.MountPoint)
+	return nil
+}
 
-		if err = curr.Mount(rootDir); err != nil {
-			return err
-		}
+// getInstallVersion returns the Clear Linux version to be installed
+func getInstallVersion(model *model.SystemInstall) (string, error) {
+	if model.Version != 0 {
+		return fmt.Sprintf("%d", model.Version), nil
 	}
 
-	defer func() {
-		log.Info("Umounting rootDir: %s", rootDir)
-		if storage.UmountAll() != nil {
-			log.Warning("Failed to umount volumes")
-			return
-		}
+	log.Info("Querying Clear Linux version")
 
-		log.Info("Removing rootDir: %s", rootDir)
-		if err = os.RemoveAll(rootDir); err != nil {
-			log.Warning("Failed to remove rootDir: %s", rootDir)
-		}
-	}()
-
-	err = storage.MountMetaFs(rootDir)
+	// in order to avoid issues raised by format bumps between installers image
+	// version and the latest released we assume the installers host version
+	// in other words we use the same version swupd is based on
+	versionBuf, err := ioutil.ReadFile("/usr/lib/os-release")
 	if err != nil {
-		return err
+		return "", errors.Errorf("Read version file /usr/lib/os-release: %v", err)
 	}
+	versionExp := regexp.MustCompile(`VERSION_ID=([0-9][0-9]*)`)
+	match := versionExp.FindSubmatch(versionBuf)
 
+	if len(match) < 2 {
+		return "", errors.Errorf("Version not found in /usr/lib/os-release")
+	}
+
+	return string(match[1]), nil
+}
+
+// addRequiredBundles adds to model the bundles required by the configured features, the
+// bundles the target can not boot without are added as required bundles
+func addRequiredBundles(model *model.SystemInstall, encryptedUsed bool, lvmUsed bool) {
 	if model.Telemetry.Enabled {
 		model.AddBundle(telemetry.RequiredBundle)
 	}
@@ -302,96 +332,61 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 	}
 
 	if encryptedUsed {
-		model.AddBundle(storage.RequiredBundle)
+		model.AddRequiredBundle(storage.RequiredBundle)
 		kernelArgs := []string{storage.KernelArgument}
 		model.AddExtraKernelArguments(kernelArgs)
 	}
 
-	msg := fmt.Sprintf("Writing mount files")
-	prg = progress.NewLoop(msg)
-	log.Info(msg)
-	if err = storage.GenerateTabFiles(rootDir, model.TargetMedias); err != nil {
-		return err
-	}
-	prg.Success()
-
-	if model.KernelArguments != nil && len(model.KernelArguments.Add) > 0 {
-		cmdlineDir := filepath.Join(rootDir, "etc", "kernel")
-		cmdlineFile := filepath.Join(cmdlineDir, "cmdline")
-		cmdline := strings.Join(model.KernelArguments.Add, " ")
-
-		if err = utils.MkdirAll(cmdlineDir, 0755); err != nil {
-			return err
-		}
-
-		if err = ioutil.WriteFile(cmdlineFile, []byte(cmdline), 0644); err != nil {
-			return err
-		}
-	}
-
-	if model.KernelArguments != nil && len(model.KernelArguments.Remove) > 0 {
-		cmdlineDir := filepath.Join(rootDir, "etc", "kernel", "cmdline-removal.d")
-		cmdlineFile := filepath.Join(cmdlineDir, "clr-installer.conf")
-		cmdline := strings.Join(model.KernelArguments.Remove, " ")
-
-		if err = utils.MkdirAll(cmdlineDir, 0755); err != nil {
-			return err
-		}
-
-		if err = ioutil.WriteFile(cmdlineFile, []byte(cmdline), 0644); err != nil {
-			return err
-		}
+	if lvmUsed {
+		model.AddRequiredBundle(storage.LVMRequiredBundle)
 	}
 
-	if prg, err = contentInstall(rootDir, version, model, options); err != nil {
-		prg.Failure()
-		return err
+	if len(model.RaidArrays) > 0 {
+		model.AddRequiredBundle(storage.RaidRequiredBundle)
 	}
 
-	if err = configureTimezone(rootDir, model); err != nil {
-		// Just log the error, not setting the timezone is not reason to fail the install
-		log.Error("Error setting timezone: %v", err)
+	if model.PersistWireless && network.HasWireless(model.NetworkInterfaces) {
+		model.AddRequiredBundle(network.WirelessRequiredBundle)
 	}
+}
 
-	if err = configureKeyboard(rootDir, model); err != nil {
-		// Just log the error, not setting the keyboard is not reason to fail the install
-		log.Error("Error setting keyboard: %v", err)
-	}
+// getInstallBundles returns the bundles to be installed on top of the base system
+func getInstallBundles(model *model.SystemInstall) []string {
+	bundles := append([]string{}, model.Bundles...)
 
-	if err = configureLanguage(rootDir, model); err != nil {
-		// Just log the error, not setting the language is not reason to fail the install
-		log.Error("Error setting language locale: %v", err)
+	for _, bundle := range model.RequiredBundles {
+		if !utils.StringSliceContains(bundles, bundle) {
+			bundles = append(bundles, bundle)
+		}
 	}
 
-	if err = cuser.Apply(rootDir, model.Users); err != nil {
-		return err
+	if model.Kernel.Bundle != "none" && !utils.StringSliceContains(bundles, model.Kernel.Bundle) {
+		bundles = append(bundles, model.Kernel.Bundle)
 	}
 
-	if model.Hostname != "" {
-		if err = hostname.SetTargetHostname(rootDir, model.Hostname); err != nil {
-			return err
-		}
-	}
+	return bundles
+}
 
-	if model.Telemetry.URL != "" {
-		if err = model.Telemetry.CreateTelemetryConf(rootDir); err != nil {
-			return err
-		}
-	}
+// isRequiredBundle returns true if the install can not succeed without bundle, the
+// kernel bundle is always required
+func isRequiredBundle(model *model.SystemInstall, bundle string) bool {
+	return model.IsRequiredBundle(bundle) || bundle == model.Kernel.Bundle
+}
 
-	if err = applyHooks("post-install", vars, model.PostInstall); err != nil {
-		return err
+// makeFs writes the bd's file system
+func makeFs(bd *storage.BlockDevice) (progress.Progress, error) {
+	msg := fmt.Sprintf("Writing %s file system to %s", bd.FsType, bd.Name)
+	if bd.MountPoint != "" {
+		msg = msg + fmt.Sprintf(" '%s'", bd.MountPoint)
 	}
-
-	msg = "Saving the installation results"
-	prg = progress.NewLoop(msg)
+	prg := progress.NewLoop(msg)
 	log.Info(msg)
-	if err = saveInstallResults(rootDir, model); err != nil {
-		log.ErrorError(err)
+	if err := bd.MakeFs(); err != nil {
+		return prg, err
 	}
 	prg.Success()
 
-	return nil
+	return nil, nil
 }
 
 func applyHooks(name string, vars map[string]string, hooks []*model.InstallHook) error {
@@ -411,7 +406,9 @@ func applyHooks(name string, vars map[string]string, hooks []*model.InstallHook)
 	return nil
 }
 
-func runInstallHook(vars map[string]string, hook *model.InstallHook) error {
+// getInstallHookCommand returns the command running hook, vars is updated with the
+// hook's chrooted variable
+func getInstallHookCommand(vars map[string]string, hook *model.InstallHook) []string {
 	args := []string{}
 	vars["chrooted"] = "0"
 
@@ -421,7 +418,11 @@ func runInstallHook(vars map[string]string, hook *model.InstallHook) error {
 	}
 
 	exec := utils.ExpandVariables(vars, hook.Cmd)
-	args = append(args, []string{"bash", "-l", "-c", exec}...)
+	return append(args, []string{"bash", "-l", "-c", exec}...)
+}
+
+func runInstallHook(vars map[string]string, hook *model.InstallHook) error {
+	args := getInstallHookCommand(vars, hook)
 
 	if err := cmd.RunAndLogWithEnv(vars, args...); err != nil {
 		return errors.Wrap(err)
@@ -430,13 +431,12 @@ func runInstallHook(vars map[string]string, hook *model.InstallHook) error {
 	return nil
 }
 
-// use the current host's version to bootstrap the sysroot, then update to the
-// latest one and start adding new bundles
+// baseContentInstall uses the current host's version to bootstrap the sysroot, then
+// updates to the latest one
 // for the bootstrap we use the hosts's swupd and the following operations are
 // executed using the target swupd
-func contentInstall(rootDir string, version string, model *model.SystemInstall, options args.Args) (progress.Progress, error) {
-
-	sw := swupd.New(rootDir, options)
+func baseContentInstall(rootDir string, version string, model *model.SystemInstall, options args.Args) (progress.Progress, error) {
+	sw := newSoftwareUpdater(rootDir, model, options)
 
 	msg := "Installing the base system"
 	prg := progress.NewLoop(msg)
@@ -459,13 +459,19 @@ func contentInstall(rootDir string, version string, model *model.SystemInstall,
 	}
 	prg.Success()
 
-	bundles := model.Bundles
+	return nil, nil
+}
 
-	if model.Kernel.Bundle != "none" {
-		bundles = append(bundles, model.Kernel.Bundle)
-	}
+// bundlesInstall adds the selected bundles on top of the base system, in a single
+// swupd transaction, and installs the boot loader
+func bundlesInstall(rootDir string, model *model.SystemInstall, options args.Args) (progress.Progress, error) {
+	var msg string
+	var prg progress.Progress
 
-	for _, bundle := range bundles {
+	sw := newSoftwareUpdater(rootDir, model, options)
+	bundles := []string{}
+
+	for _, bundle := range getInstallBundles(model) {
 		// swupd will fail (return exit code 18) if we try to "re-install" a bundle
 		// already installed - with that we need to prevent doing bundle-add for bundles
 		// previously installed by verify operation
@@ -474,31 +480,40 @@ func contentInstall(rootDir string, version string, model *model.SystemInstall,
 			continue
 		}
 
-		msg = fmt.Sprintf("Installing bundle: %s", bundle)
-		prg = progress.NewLoop(msg)
+		// the same goes for the bundles added by an interrupted install
+		if sw.IsBundleInstalled(bundle) {
+			log.Debug("Bundle %s is already installed, skipping", bundle)
+			continue
+		}
+
+		bundles = append(bundles, bundle)
+	}
+
+	if len(bundles) > 0 {
+		msg = fmt.Sprintf("Installing bundles: %s", strings.Join(bundles, ", "))
+		prg = progress.MultiStep(swupd.BundleAddSteps, msg)
 		log.Info(msg)
-		if err := sw.BundleAdd(bundle); err != nil {
-			// Attempt to continue the installation for non-core bundles
-			if errLog := model.Telemetry.LogRecord("swupd", 2, "Failed to install bundle: "+bundle); errLog != nil {
-				log.Error("Failed to log Telemetry record for failed bundled: " + bundle)
-			}
-			log.Error("Failed to install bundle: %s", bundle)
-			prg.Failure()
-		} else {
+
+		err := sw.AddBundles(bundles, prg)
+		failed := sw.MissingBundles(bundles)
+
+		if err != nil && len(failed) == 0 {
+			// all the bundles made it, i.e. a post-update helper script failed
+			log.Warning("swupd bundle-add failed after installing the bundles: %v", err)
+		}
+
+		if len(failed) == 0 {
 			prg.Success()
+		} else if err = handleBundleFailures(sw, model, failed, prg); err != nil {
+			// handleBundleFailures already reported the failure
+			return nil, err
 		}
 	}
 
 	msg = "Installing boot loader"
 	prg = progress.NewLoop(msg)
 	log.Info(msg)
-	args := []string{
-		fmt.Sprintf("%s/usr/bin/clr-boot-manager", rootDir),
-		"update",
-		fmt.Sprintf("--path=%s", rootDir),
-	}
-
-	err := cmd.RunAndLog(args...)
+	err := cmd.RunAndLog(getBootManagerCommand(rootDir)...)
 	if err != nil {
 		return prg, errors.Wrap(err)
 	}
@@ -507,11 +522,149 @@ func contentInstall(rootDir string, version string, model *model.SystemInstall,
 	return nil, nil
 }
 
+// handleBundleFailures applies the md's onBundleFailure policy to the failed bundles,
+// prg is the bundle-add transaction progress. The required bundles failing to install
+// always fail the install.
+func handleBundleFailures(sw *swupd.SoftwareUpdater, md *model.SystemInstall,
+	failed []string, prg progress.Progress) error {
+	prg.Failure()
+
+	if md.OnBundleFailure == model.BundleFailureRetry {
+		retried := []string{}
+
+		for _, bundle := range failed {
+			msg := fmt.Sprintf("Retrying bundle: %s", bundle)
+			prg = progress.NewLoop(msg)
+			log.Info(msg)
+			if err := sw.BundleAdd(bundle); err != nil {
+				prg.Failure()
+				retried = append(retried, bundle)
+				continue
+			}
+			prg.Success()
+		}
+
+		failed = retried
+	}
+
+	required := []string{}
+	optional := []string{}
+
+	for _, bundle := range failed {
+		if isRequiredBundle(md, bundle) {
+			required = append(required, bundle)
+		} else {
+			optional = append(optional, bundle)
+		}
+	}
+
+	if len(required) > 0 {
+		return errors.Errorf("Failed to install the required bundles: %s", strings.Join(required, ", "))
+	}
+
+	if len(optional) > 0 && md.OnBundleFailure == model.BundleFailureAbort {
+		return errors.Errorf("Failed to install bundles: %s", strings.Join(optional, ", "))
+	}
+
+	// Attempt to continue the installation for the optional bundles
+	for _, bundle := range optional {
+		if errLog := md.Telemetry.LogRecord("swupd", 2, "Failed to install bundle: "+bundle); errLog != nil {
+			log.Error("Failed to log Telemetry record for failed bundled: " + bundle)
+		}
+		log.Error("Failed to install optional bundle: %s", bundle)
+	}
+
+	return nil
+}
+
+// newSoftwareUpdater returns the swupd instance installing the target content, the
+// offline installs use the local content only
+func newSoftwareUpdater(rootDir string, model *model.SystemInstall, options args.Args) *swupd.SoftwareUpdater {
+	sw := swupd.New(rootDir, options)
+
+	if model.IsOffline() {
+		sw.SetOfflineContent(model.OfflineContent)
+	}
+
+	return sw
+}
+
+// LoadBundleCatalogue returns the catalogue of the bundles available for the version
+// to be installed, loaded from the configured content URL, mirror or offline content
+func LoadBundleCatalogue(model *model.SystemInstall, options args.Args) (*swupd.Catalogue, error) {
+	version, err := getInstallVersion(model)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadBundleCatalogue(model, version, options)
+}
+
+func loadBundleCatalogue(model *model.SystemInstall, version string, options args.Args) (*swupd.Catalogue, error) {
+	sw := newSoftwareUpdater("", model, options)
+	return swupd.LoadBundleCatalogue(sw.CatalogueURL(model.SwupdMirror), version)
+}
+
+// validateDiskSpace checks the file system receiving the bundles content is large
+// enough for the bundles to install, that is /usr if it's a separate file system or /.
+// A file system with a relative size is only known once resolved is true, i.e the
+// relative sizes were resolved against the actual disk sizes
+func validateDiskSpace(model *model.SystemInstall, cat *swupd.Catalogue, resolved bool) error {
+	required := cat.InstallSize(append(getInstallBundles(model), swupd.CoreBundles...))
+	if required == 0 {
+		log.Warning("The bundle sizes are not known, skipping the disk space check")
+		return nil
+	}
+
+	// room for the swupd state and the file system overhead
+	required = required + required*diskSpaceMargin/100
+
+	mnt := "/usr"
+	size, found := storage.MountPointSize(model.TargetMedias, model.RaidArrays, mnt)
+	if !found {
+		mnt = "/"
+		size, _ = storage.MountPointSize(model.TargetMedias, model.RaidArrays, mnt)
+	}
+
+	if size == 0 {
+		if resolved {
+			return errors.ValidationErrorf("The %s file system size is not known, can not check it fits the selected bundles", mnt)
+		}
+
+		log.Debug("The %s file system size is not known yet, checked once the relative sizes are resolved", mnt)
+		return nil
+	}
+	if size < required {
+		return errors.ValidationErrorf("The %s file system is too small for the selected bundles: %s, %s required",
+			mnt, humanReadableSize(size), humanReadableSize(required))
+	}
+
+	log.Debug("The %s file system size %d is enough for the bundles: %d", mnt, size, required)
+
+	return nil
+}
+
+func humanReadableSize(size uint64) string {
+	str, err := storage.HumanReadableSize(size)
+	if err != nil {
+		return fmt.Sprintf("%d bytes", size)
+	}
+
+	return str
+}
+
+// getBootManagerCommand returns the command installing the target's boot loader
+func getBootManagerCommand(rootDir string) []string {
+	return []string{
+		fmt.Sprintf("%s/usr/bin/clr-boot-manager", rootDir),
+		"update",
+		fmt.Sprintf("--path=%s", rootDir),
+	}
+}
+
 // ConfigureNetwork applies the model/configured network interfaces
 func ConfigureNetwork(model *model.SystemInstall) error {
-	prg, err := configureNetwork(model)
-	if err != nil {
-		prg.Success()
+	if err := configureNetwork("/", model); err != nil {
 		NetworkPassing = false
 		return err
 	}
@@ -521,18 +674,53 @@ func ConfigureNetwork(model *model.SystemInstall) error {
 	return nil
 }
 
-func configureNetwork(model *model.SystemInstall) (progress.Progress, error) {
-	cmd.SetHTTPSProxy(model.HTTPSProxy)
+// configureNetwork applies the network configuration to the installer system at
+// root, i.e "/" but for the plans
+func configureNetwork(root string, model *model.SystemInstall) error {
+	prg, err := applyNetwork(root, model)
+	if err != nil {
+		// the connectivity check reports its own progress
+		if prg != nil {
+			prg.Success()
+		}
+		return err
+	}
 
-	if len(model.NetworkInterfaces) > 0 {
+	return nil
+}
+
+func applyNetwork(root string, model *model.SystemInstall) (progress.Progress, error) {
+	proxy.Set(model.Proxy())
+
+	// the network devices are only created on the installer system if requested
+	netdevs := []*network.NetDev{}
+	if model.HostNetDevices {
+		netdevs = model.NetworkDevices
+	}
+
+	if len(model.NetworkInterfaces) > 0 || len(netdevs) > 0 {
 		msg := "Applying network settings"
 		prg := progress.NewLoop(msg)
 		log.Info(msg)
-		if err := network.Apply("/", model.NetworkInterfaces); err != nil {
+		if err := network.ApplyConfig(root, model.NetworkInterfaces, netdevs); err != nil {
 			return prg, err
 		}
 		prg.Success()
 
+		if network.HasWireless(model.NetworkInterfaces) {
+			msg = "Connecting to the wireless networks"
+			prg = progress.NewLoop(msg)
+			log.Info(msg)
+			if err := network.ApplyWireless(root, model.NetworkInterfaces); err != nil {
+				return prg, err
+			}
+
+			if err := network.StartWireless(model.NetworkInterfaces); err != nil {
+				return prg, err
+			}
+			prg.Success()
+		}
+
 		msg = "Restarting network interfaces"
 		prg = progress.NewLoop(msg)
 		log.Info(msg)
@@ -542,28 +730,63 @@ func configureNetwork(model *model.SystemInstall) (progress.Progress, error) {
 		prg.Success()
 	}
 
-	msg := "Testing connectivity"
-	prg := progress.NewLoop(msg)
-	ok := false
+	return nil, verifyConnectivity(model)
+}
 
-	// 3 attempts to test connectivity
-	for i := 0; i < 3; i++ {
-		time.Sleep(2 * time.Second)
+// probeSleep waits between the connectivity check attempts
+var probeSleep = time.Sleep
 
-		log.Info(msg)
-		if err := network.VerifyConnectivity(); err == nil {
-			ok = true
-			break
-		}
+// verifyConnectivity probes the connectivity check URLs, the failed probes are retried
+// until they all succeed or the attempts are exhausted
+func verifyConnectivity(model *model.SystemInstall) error {
+	check := model.ConnectivityCheck
+	if check == nil {
+		check = &network.ConnectivityCheck{}
 	}
 
-	if !ok {
-		return prg, errors.Errorf("Failed, network is not working.")
+	if check.Skip {
+		log.Info("Skipping the connectivity check")
+		return nil
 	}
 
-	prg.Success()
+	pending, err := check.Targets(model.SwupdMirror)
+	if err != nil {
+		return err
+	}
 
-	return nil, nil
+	attempts := check.GetAttempts()
+	reasons := []string{}
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		probeSleep(check.RetryDelay(attempt))
+
+		failed := []string{}
+		reasons = []string{}
+
+		for _, url := range pending {
+			prg := progress.NewLoop("Testing connectivity to %s (attempt %d of %d)", url, attempt, attempts)
+			log.Info("Testing connectivity to %s (attempt %d of %d)", url, attempt, attempts)
+
+			reason := check.Probe(url)
+			if reason == "" {
+				prg.Success()
+				continue
+			}
+
+			prg.Failure()
+			log.Warning("%s is not reachable (attempt %d of %d): %s", url, attempt, attempts, reason)
+			failed = append(failed, url)
+			reasons = append(reasons, fmt.Sprintf("%s (%s)", url, reason))
+		}
+
+		if len(failed) == 0 {
+			return nil
+		}
+
+		pending = failed
+	}
+
+	return errors.Errorf("Failed, network is not working: %s not reachable", strings.Join(reasons, ", "))
 }
 
 // configureTimezone applies the model/configured Timezone to the target
@@ -629,6 +852,16 @@ func configureLanguage(rootDir string, model *model.SystemInstall) error {
 	return nil
 }
 
+// writeRedactedFile writes md, without its passwords and keys, to path
+func writeRedactedFile(md *model.SystemInstall, path string) error {
+	res, err := md.Redacted()
+	if err != nil {
+		return err
+	}
+
+	return res.WriteFile(path)
+}
+
 // saveInstallResults saves the results of the installation process
 // onto the target media
 func saveInstallResults(rootDir string, md *model.SystemInstall) error {
@@ -650,8 +883,13 @@ func saveInstallResults(rootDir string, md *model.SystemInstall) error {
 	// Sanitize the config data to remove any potential
 	// Personal Information from the data set
 	cleanModel.Users = nil             // Remove User Info
+	cleanModel.Root = nil              // Remove Root Account Info
 	cleanModel.Hostname = ""           // Remove user defined hostname
+	cleanModel.HTTPProxy = ""          // Remove user defined Proxy
 	cleanModel.HTTPSProxy = ""         // Remove user defined Proxy
+	cleanModel.NoProxy = nil           // Remove user defined Proxy
+	cleanModel.ProxyUser = ""          // Remove the Proxy credentials
+	cleanModel.ProxyPassword = ""      // Remove the Proxy credentials
 	cleanModel.SwupdMirror = ""        // Remove user defined Swupd Mirror
 	cleanModel.NetworkInterfaces = nil // Remove Network information
 
@@ -685,7 +923,7 @@ func saveInstallResults(rootDir string, md *model.SystemInstall) error {
 
 		confFile := filepath.Join(saveDir, conf.ConfigFile)
 
-		if err := md.WriteFile(confFile); err != nil {
+		if err := writeRedactedFile(md, confFile); err != nil {
 			log.Error("Failed to write YAML file (%v) %q", err, confFile)
 			errMsgs = append(errMsgs, "Failed to write YAML file")
 		}
@@ -0,0 +1,500 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/conf"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/kernel"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/progress"
+	"github.com/clearlinux/clr-installer/proxy"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/swupd"
+	"github.com/clearlinux/clr-installer/telemetry"
+)
+
+func TestHandleBundleFailures(t *testing.T) {
+	rec := cmd.NewRecorder()
+	rec.Script("", errors.Errorf("bundle-add failed"), "swupd", "bundle-add")
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	progress.Set(progress.NewJSON(ioutil.Discard))
+
+	md := &model.SystemInstall{
+		Bundles:         []string{"editors", "containers-basic"},
+		RequiredBundles: []string{"containers-basic"},
+		Kernel:          &kernel.Kernel{Bundle: "kernel-native"},
+		Telemetry:       &telemetry.Telemetry{},
+	}
+
+	bundles := getInstallBundles(md)
+	if strings.Join(bundles, " ") != "editors containers-basic kernel-native" {
+		t.Fatalf("Unexpected install bundles: %v", bundles)
+	}
+
+	sw := swupd.New("/tmp/clr-installer-test", args.Args{})
+
+	tests := []struct {
+		mode   string
+		failed []string
+		valid  bool
+	}{
+		{"", []string{"editors"}, true},
+		{model.BundleFailureContinue, []string{"editors"}, true},
+		{model.BundleFailureAbort, []string{"editors"}, false},
+		{model.BundleFailureRetry, []string{"editors"}, true},
+		{model.BundleFailureContinue, []string{"editors", "containers-basic"}, false},
+		{model.BundleFailureRetry, []string{"kernel-native"}, false},
+	}
+
+	for _, curr := range tests {
+		md.OnBundleFailure = curr.mode
+
+		prg := progress.MultiStep(swupd.BundleAddSteps, "Installing bundles")
+		err := handleBundleFailures(sw, md, curr.failed, prg)
+
+		if curr.valid && err != nil {
+			t.Fatalf("Mode %q should not fail for %v: %v", curr.mode, curr.failed, err)
+		} else if !curr.valid && err == nil {
+			t.Fatalf("Mode %q should fail for %v", curr.mode, curr.failed)
+		}
+	}
+}
+
+func TestBundlesPhaseFailure(t *testing.T) {
+	rec := cmd.NewRecorder()
+	rec.Script("", errors.Errorf("bundle-add failed"), "swupd", "bundle-add")
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	progress.Set(progress.NewJSON(ioutil.Discard))
+
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	md := &model.SystemInstall{
+		Bundles:         []string{"editors"},
+		RequiredBundles: []string{"containers-basic"},
+		Kernel:          &kernel.Kernel{Bundle: "kernel-native"},
+		Telemetry:       &telemetry.Telemetry{},
+	}
+
+	st := &installState{rootDir: dir, model: md}
+
+	for _, mode := range []string{model.BundleFailureAbort, model.BundleFailureRetry} {
+		md.OnBundleFailure = mode
+		rec.Reset()
+
+		if err = bundlesPhase(st); err == nil {
+			t.Fatalf("Mode %q should fail the bundles phase", mode)
+		}
+
+		for _, curr := range rec.Lines() {
+			if strings.Contains(curr, "clr-boot-manager") {
+				t.Fatalf("Mode %q should not install the boot loader: %v", mode, rec.Lines())
+			}
+		}
+	}
+
+	// the optional bundles failing don't fail the install, the kernel is required
+	md.OnBundleFailure = model.BundleFailureContinue
+	md.RequiredBundles = nil
+
+	if err = os.MkdirAll(filepath.Join(dir, "usr", "share", "clear", "bundles", "kernel-native"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = bundlesPhase(st); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateDiskSpace(t *testing.T) {
+	cat := &swupd.Catalogue{
+		Manifests: true,
+		Bundles: []*swupd.Bundle{
+			{Name: "os-core", Size: 400},
+			{Name: "os-core-update", Size: 100, Includes: []string{"os-core"}},
+			{Name: "openssh-server", Size: 100, Includes: []string{"os-core"}},
+			{Name: "editors", Size: 200, Includes: []string{"os-core"}},
+			{Name: "kernel-native", Size: 200},
+		},
+	}
+
+	root := &storage.BlockDevice{Name: "sda2", MountPoint: "/", Size: 1000}
+	md := &model.SystemInstall{
+		TargetMedias: []*storage.BlockDevice{{Name: "sda", Children: []*storage.BlockDevice{root}}},
+		Bundles:      []string{"os-core", "editors"},
+		Kernel:       &kernel.Kernel{Bundle: "kernel-native"},
+	}
+
+	// 1000 bytes of content plus the margin
+	if err := validateDiskSpace(md, cat, false); err == nil {
+		t.Fatal("An undersized root file system should be rejected")
+	}
+
+	root.Size = 1200
+	if err := validateDiskSpace(md, cat, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// a separate /usr receives the content
+	root.Size = 100
+	usr := &storage.BlockDevice{Name: "sda3", MountPoint: "/usr", Size: 1000}
+	md.TargetMedias[0].Children = append(md.TargetMedias[0].Children, usr)
+
+	if err := validateDiskSpace(md, cat, false); err == nil {
+		t.Fatal("An undersized /usr file system should be rejected")
+	}
+
+	usr.Size = 2000
+	if err := validateDiskSpace(md, cat, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// relative sizes are not known before partitioning
+	usr.Size = 0
+	usr.RelativeSize = storage.RestSize
+	if err := validateDiskSpace(md, cat, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateDiskSpace(md, cat, true); err == nil {
+		t.Fatal("An unknown /usr size should be rejected once the sizes are resolved")
+	}
+
+	// the partition phase resolves the relative sizes before writing the partition table
+	rec := cmd.NewRecorder()
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	md.TargetMedias[0].Type = storage.BlockDeviceTypeDisk
+	md.TargetMedias[0].Size = 4 << 20
+	cat.Bundles[3].Size = 4 << 20
+
+	st := &installState{model: md, catalogue: cat}
+	if err := partitionPhase(st); err == nil {
+		t.Fatal("An undersized /usr file system should be rejected before partitioning")
+	}
+
+	if usr.Size == 0 {
+		t.Fatal("The /usr relative size should have been resolved")
+	}
+
+	if lines := rec.Lines(); len(lines) > 0 {
+		t.Fatalf("No partition table should be written: %v", lines)
+	}
+
+	// a root logical volume without size fills its volume group
+	cat.Bundles[3].Size = 200
+	pv := &storage.BlockDevice{Name: "sda2", Type: storage.BlockDeviceTypeLVM2Group, VolumeGroup: "vg0",
+		RelativeSize: storage.RestSize}
+	pv.AddChild(&storage.BlockDevice{Name: "swap", FsType: "swap", Size: 64 << 20,
+		Type: storage.BlockDeviceTypeLVM2Volume})
+	pv.AddChild(&storage.BlockDevice{Name: "root", FsType: "ext4", MountPoint: "/",
+		Type: storage.BlockDeviceTypeLVM2Volume})
+
+	md.TargetMedias[0].Size = 1 << 30
+	md.TargetMedias[0].Children = nil
+	md.TargetMedias[0].AddChild(&storage.BlockDevice{Name: "sda1", FsType: "vfat", MountPoint: "/boot",
+		Size: 64 << 20, Type: storage.BlockDeviceTypePart})
+	md.TargetMedias[0].AddChild(pv)
+
+	if err := validateDiskSpace(md, cat, true); err == nil {
+		t.Fatal("The unresolved volume group size should be rejected")
+	}
+
+	defer progress.Set(progress.Set(progress.NewJSON(ioutil.Discard)))
+	defer storage.SetPlanMode(storage.SetPlanMode(true))
+
+	if err := partitionPhase(st); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyConnectivity(t *testing.T) {
+	probe := []string{"curl", "--no-sessionid", "--max-time", "5", "-o", "/dev/null", "-s", "-f"}
+
+	rec := cmd.NewRecorder()
+	rec.Script("", errors.Errorf("exit status 7"), append(probe, "http://mirror.example.com/update")...)
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	out := bytes.NewBuffer(nil)
+	progress.Set(progress.NewJSON(out))
+
+	delays := []time.Duration{}
+	saved := probeSleep
+	defer func() { probeSleep = saved }()
+	probeSleep = func(d time.Duration) { delays = append(delays, d) }
+
+	md := &model.SystemInstall{
+		SwupdMirror: "http://mirror.example.com/update",
+		ConnectivityCheck: &network.ConnectivityCheck{
+			URLs:     []string{"https://cdn.example.com/update"},
+			Attempts: 3,
+			Delay:    1,
+			Backoff:  2,
+			Timeout:  5,
+		},
+	}
+
+	err := verifyConnectivity(md)
+	if err == nil {
+		t.Fatal("The unreachable mirror should fail the connectivity check")
+	}
+
+	if !strings.Contains(err.Error(), "http://mirror.example.com/update (") {
+		t.Fatalf("The probe failure reason should be reported: %s", err)
+	}
+
+	// the reachable URL is not probed again
+	expected := []string{strings.Join(append(probe, "https://cdn.example.com/update"), " ")}
+	for i := 0; i < 3; i++ {
+		expected = append(expected, strings.Join(append(probe, "http://mirror.example.com/update"), " "))
+	}
+
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Invalid probes: %v, expected: %v", lines, expected)
+	}
+
+	if !reflect.DeepEqual(delays, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}) {
+		t.Fatalf("Invalid retry delays: %v", delays)
+	}
+
+	// a single failure is reported per failed probe, on the probe's own progress
+	failures := 0
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		ev := progress.Event{}
+		if err = json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatal(err)
+		}
+
+		if ev.Outcome != progress.OutcomeFailure {
+			continue
+		}
+
+		failures++
+		if !strings.HasPrefix(ev.Description, "Testing connectivity to http://mirror.example.com/update (attempt") {
+			t.Fatalf("Invalid probe failure: %s", line)
+		}
+	}
+
+	if failures != 3 {
+		t.Fatalf("Expected 3 probe failures, got %d: %s", failures, out.String())
+	}
+
+	rec.Reset()
+	md.ConnectivityCheck.Skip = true
+
+	if err = verifyConnectivity(md); err != nil || len(rec.Lines()) != 0 {
+		t.Fatalf("The skipped check should not probe anything: %v %v", err, rec.Lines())
+	}
+}
+
+func TestSaveInstallResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	descriptor := `
+networkInterfaces:
+- name: wlp2s0
+  dhcp: "true"
+  wireless:
+    ssid: home
+    psk: correct horse battery
+httpProxy: http://proxy.example.com:3128
+proxyUser: jdoe
+proxyPassword: secret-proxy-password
+postArchive: true
+`
+
+	md, err := model.Load([]byte(descriptor), args.Args{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md.Telemetry = &telemetry.Telemetry{}
+
+	rec := cmd.NewRecorder()
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	if err = saveInstallResults(dir, md); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "root", conf.ConfigFile)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"correct horse battery", "secret-proxy-password"} {
+		if strings.Contains(string(content), secret) {
+			t.Fatalf("The archived descriptor should not contain %q:\n%s", secret, content)
+		}
+	}
+
+	if !strings.Contains(string(content), "proxy.example.com") {
+		t.Fatalf("The archived descriptor should keep the proxy:\n%s", content)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("The archived descriptor should only be readable by root: %v", fi.Mode())
+	}
+
+	if md.ProxyPassword != "secret-proxy-password" {
+		t.Fatal("The install descriptor should be left untouched")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bundles := []string{"os-core", "os-core-update", "openssh-server", "editors", "kernel-native"}
+
+		if r.URL.Path == "/update/25000/Manifest.MoM" {
+			mom := "MANIFEST\t25\nversion:\t25000\n\n"
+			for _, curr := range bundles {
+				mom = mom + "M...\t0000\t25000\t" + curr + "\n"
+			}
+
+			_, _ = w.Write([]byte(mom))
+			return
+		}
+
+		for _, curr := range bundles {
+			if r.URL.Path == "/update/25000/Manifest."+curr {
+				_, _ = w.Write([]byte("MANIFEST\t25\nversion:\t25000\ncontentsize:\t1000\n\n"))
+				return
+			}
+		}
+
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	descriptor := `
+targetMedia:
+- name: sda
+  type: disk
+  size: 8G
+  children:
+  - name: sda1
+    size: 150M
+    type: part
+    fstype: vfat
+    mountpoint: "/boot"
+  - name: sda2
+    size: 4G
+    type: part
+    fstype: ext4
+    mountpoint: "/"
+networkInterfaces:
+- name: enp0s3
+  addrs:
+  - ip: 10.7.200.163
+    netmask: 255.255.255.0
+    version: 0
+  dhcp: "false"
+  gateway: 10.7.200.251
+  dns: 10.248.2.1
+- name: wlp2s0
+  dhcp: "true"
+  wireless:
+    ssid: home
+    psk: correct horse battery
+persistNetwork: true
+persistWireless: true
+httpProxy: http://proxy.example.com:3128
+noProxy: [127.0.0.1]
+persistProxy: true
+bundles: [editors]
+keyboard: us
+language: en_US.UTF-8
+telemetry: false
+kernel: kernel-native
+version: 25000
+postArchive: false
+swupdMirror: ` + srv.URL + "/update\n"
+
+	md, err := model.Load([]byte(descriptor), args.Args{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := cmd.NewRecorder()
+	rec.Script(`{"blockdevices": []}`, nil, "lsblk")
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+	defer proxy.Set(proxy.Config{})
+
+	saved := probeSleep
+	defer func() { probeSleep = saved }()
+	probeSleep = func(d time.Duration) {}
+
+	lines, err := Plan("/tmp/install-root", md, args.Args{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := strings.Join(lines, "\n")
+
+	expected := []string{
+		"# Applying network settings",
+		"# write /etc/systemd/network/",
+		"curl --no-sessionid --max-time 10 -o /dev/null -s -f " + srv.URL + "/update",
+		"parted -s /dev/sda mklabel gpt",
+		"mount -t ext4 -o relatime /dev/sda2 /tmp/install-root\n",
+		"mount --bind /proc /tmp/install-root/proc",
+		"swupd verify",
+		"swupd bundle-add --path=/tmp/install-root --statedir=/tmp/install-root/var/lib/swupd editors",
+		"# Writing the target network configuration",
+		"# write /tmp/install-root/etc/systemd/network/",
+		"# write /tmp/install-root/etc/environment",
+		"# Writing the target wireless configuration",
+		"# write /tmp/install-root/etc/wpa_supplicant/wpa_supplicant-wlp2s0.conf",
+		"--root=/tmp/install-root enable wpa_supplicant@wlp2s0.service",
+		"umount --force --lazy /tmp/install-root\n",
+	}
+
+	for _, curr := range expected {
+		if !strings.Contains(plan+"\n", curr) {
+			t.Fatalf("The plan should contain %q:\n%s", curr, plan)
+		}
+	}
+
+	if strings.Contains(plan, "clr-installer-plan-") {
+		t.Fatalf("The plan should not refer to its temporary roots:\n%s", plan)
+	}
+
+	for _, curr := range rec.Lines() {
+		if !strings.HasPrefix(curr, "lsblk") {
+			t.Fatalf("The plan should not execute %q", curr)
+		}
+	}
+}
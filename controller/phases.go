@@ -0,0 +1,495 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/hostname"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/progress"
+	"github.com/clearlinux/clr-installer/proxy"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/swupd"
+	cuser "github.com/clearlinux/clr-installer/user"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// PhasePartition writes the partition tables
+	PhasePartition = "partition"
+
+	// PhaseFormat creates the encrypted mappings, logical volumes, raid arrays
+	// and file systems
+	PhaseFormat = "format"
+
+	// PhaseMount mounts the target file systems
+	PhaseMount = "mount"
+
+	// PhaseBaseContent writes the mount files and installs the base system
+	PhaseBaseContent = "base-content"
+
+	// PhaseBundles installs the bundles and the boot loader
+	PhaseBundles = "bundles"
+
+	// PhaseConfigure applies the timezone, keyboard, language, hostname, telemetry
+	// and network configuration
+	PhaseConfigure = "configure"
+
+	// PhaseUsers creates the users
+	PhaseUsers = "users"
+
+	// PhaseHooks runs the post-install hooks
+	PhaseHooks = "hooks"
+
+	// PhaseFinalize saves the install results
+	PhaseFinalize = "finalize"
+)
+
+// installState is the state shared by the install phases
+type installState struct {
+	rootDir       string
+	hostRoot      string // the installer system's root, i.e "/" but for the plans
+	plan          bool   // true if the install is run against a recording executor
+	model         *model.SystemInstall
+	options       args.Args
+	vars          map[string]string
+	version       string
+	mountPoints   []*storage.BlockDevice
+	encryptedUsed bool
+	lvmUsed       bool
+
+	// catalogue is the bundle catalogue validated before the install, nil if none
+	catalogue *swupd.Catalogue
+}
+
+// installPhase is a named step of the install, once completed it's recorded in
+// the checkpoint and skipped by a resumed install
+type installPhase struct {
+	name string
+	run  func(st *installState) error
+
+	// resume is run instead of run when the phase was completed by a previous
+	// attempt, it restores what the following phases depend on
+	resume func(st *installState) error
+}
+
+var installPhases = []*installPhase{
+	{name: PhasePartition, run: partitionPhase},
+	{name: PhaseFormat, run: formatPhase, resume: activatePhase},
+	{name: PhaseMount, run: mountPhase, resume: mountPhase},
+	{name: PhaseBaseContent, run: baseContentPhase},
+	{name: PhaseBundles, run: bundlesPhase},
+	{name: PhaseConfigure, run: configurePhase},
+	{name: PhaseUsers, run: usersPhase},
+	{name: PhaseHooks, run: hooksPhase},
+	{name: PhaseFinalize, run: finalizePhase},
+}
+
+// runPhases runs the install phases not yet completed according to cp, the stub
+// images are done once the file systems are created
+func runPhases(st *installState, cp *checkpoint) error {
+	for _, phase := range installPhases {
+		if st.options.StubImage && phase.name == PhaseMount {
+			break
+		}
+
+		if cp.isDone(phase.name) {
+			if phase.resume == nil {
+				log.Info("Skipping the completed install phase: %s", phase.name)
+				continue
+			}
+
+			log.Info("Restoring the completed install phase: %s", phase.name)
+			if err := phase.resume(st); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		log.Info("Running the install phase: %s", phase.name)
+		if err := phase.run(st); err != nil {
+			return err
+		}
+
+		cp.complete(phase.name)
+	}
+
+	return nil
+}
+
+func partitionPhase(st *installState) error {
+	// the relative sizes are only known against the actual disks, check the bundles
+	// still fit before writing any partition table
+	if st.catalogue != nil {
+		for _, curr := range st.model.TargetMedias {
+			if !curr.HasRelativeSizes() || curr.HasExistingPartitions() {
+				continue
+			}
+
+			if err := curr.ResolveDiskRelativeSizes(); err != nil {
+				return err
+			}
+		}
+
+		if err := validateDiskSpace(st.model, st.catalogue, true); err != nil {
+			return err
+		}
+	}
+
+	// based on the description given, write the partition table
+	for _, curr := range st.model.TargetMedias {
+		if err := curr.WritePartitionTable(st.model.LegacyBios); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatPhase(st *installState) error {
+	return st.prepareTargetMedias(true)
+}
+
+// activatePhase maps, activates and assembles the block devices created by a
+// previous format phase without touching their content
+func activatePhase(st *installState) error {
+	return st.prepareTargetMedias(false)
+}
+
+// prepareTargetMedias prepares the block devices of all the target medias and
+// collects their mount points, if create is false the devices created by a previous
+// attempt are only brought up
+func (st *installState) prepareTargetMedias(create bool) error {
+	var prg progress.Progress
+	var err error
+
+	md := st.model
+	st.mountPoints = []*storage.BlockDevice{}
+
+	for _, curr := range md.TargetMedias {
+		// prepare the blockdevice's partitions filesystem
+		for _, ch := range curr.Children {
+			// raid members are formatted as part of their array
+			if ch.Type == storage.BlockDeviceTypeRaidMember {
+				continue
+			}
+
+			if ch.Type == storage.BlockDeviceTypeLVM2Group {
+				st.lvmUsed = true
+
+				if create {
+					msg := fmt.Sprintf("Creating %s logical volumes on %s", ch.VolumeGroup, ch.Name)
+					prg = progress.NewLoop(msg)
+					log.Info(msg)
+					if err = ch.MakeLogicalVolumes(); err != nil {
+						return err
+					}
+					prg.Success()
+				} else {
+					msg := fmt.Sprintf("Activating %s logical volumes on %s", ch.VolumeGroup, ch.Name)
+					prg = progress.NewLoop(msg)
+					log.Info(msg)
+					if err = ch.ActivateLogicalVolumes(); err != nil {
+						return err
+					}
+					prg.Success()
+				}
+
+				for _, lv := range ch.Children {
+					if create {
+						if prg, err = makeFs(lv); err != nil {
+							prg.Failure()
+							return err
+						}
+					}
+
+					if lv.MountPoint != "" {
+						st.mountPoints = append(st.mountPoints, lv)
+					}
+				}
+
+				continue
+			}
+
+			if ch.Type == storage.BlockDeviceTypeCrypt {
+				st.encryptedUsed = true
+
+				if ch.FsTypeNotSwap() && create {
+					msg := fmt.Sprintf("Mapping %s partition to an encrypted partition", ch.Name)
+					prg = progress.NewLoop(msg)
+					log.Info(msg)
+					if err = ch.MapEncrypted(md.CryptPass); err != nil {
+						return err
+					}
+					prg.Success()
+				} else if ch.FsTypeNotSwap() {
+					msg := fmt.Sprintf("Opening %s encrypted partition", ch.Name)
+					prg = progress.NewLoop(msg)
+					log.Info(msg)
+					if err = ch.OpenEncrypted(md.CryptPass); err != nil {
+						return err
+					}
+					prg.Success()
+				}
+			}
+
+			if !create {
+				log.Debug("Keeping the %s file system of %s", ch.FsType, ch.Name)
+			} else if !ch.IsFormatRequired() {
+				log.Info("Keeping the existing %s file system of %s", ch.FsType, ch.Name)
+			} else if prg, err = makeFs(ch); err != nil {
+				prg.Failure()
+				return err
+			}
+
+			if len(ch.Subvolumes) > 0 {
+				if create {
+					msg := fmt.Sprintf("Creating btrfs subvolumes on %s", ch.Name)
+					prg = progress.NewLoop(msg)
+					log.Info(msg)
+					if err = ch.MakeSubvolumes(); err != nil {
+						return err
+					}
+					prg.Success()
+				}
+
+				st.mountPoints = append(st.mountPoints, ch.SubvolumeDevices()...)
+			}
+
+			// if we have a mount point set it for future mounting, the btrfs subvolume
+			// devices already include it
+			if ch.MountPoint != "" && len(ch.Subvolumes) == 0 {
+				st.mountPoints = append(st.mountPoints, ch)
+			}
+		}
+	}
+
+	// assemble the raid arrays once all the target medias are partitioned
+	for _, ra := range md.RaidArrays {
+		if create {
+			msg := fmt.Sprintf("Creating %s raid array %s", ra.Level, ra.Name)
+			prg = progress.NewLoop(msg)
+			log.Info(msg)
+			if err = ra.Create(md.TargetMedias); err != nil {
+				return err
+			}
+			prg.Success()
+
+			if prg, err = makeFs(ra.Device()); err != nil {
+				prg.Failure()
+				return err
+			}
+		} else {
+			msg := fmt.Sprintf("Assembling %s raid array %s", ra.Level, ra.Name)
+			prg = progress.NewLoop(msg)
+			log.Info(msg)
+			if err = ra.Assemble(md.TargetMedias); err != nil {
+				return err
+			}
+			prg.Success()
+		}
+
+		if ra.MountPoint != "" {
+			st.mountPoints = append(st.mountPoints, ra.Device())
+		}
+	}
+
+	// Update the target devices current labels and UUIDs
+	return storage.UpdateBlockDevices(md.TargetMedias)
+}
+
+// mountPhase mounts the target file systems, mounts don't outlive an attempt so
+// this phase is run by resumed installs too
+func mountPhase(st *installState) error {
+	// mount all the prepared partitions
+	for _, curr := range sortMountPoint(st.mountPoints) {
+		log.Info("Mounting: %s", curr.MountPoint)
+
+		if err := curr.Mount(st.rootDir); err != nil {
+			return err
+		}
+	}
+
+	if err := storage.MountMetaFs(st.rootDir); err != nil {
+		return err
+	}
+
+	addRequiredBundles(st.model, st.encryptedUsed, st.lvmUsed)
+
+	return nil
+}
+
+func baseContentPhase(st *installState) error {
+	md := st.model
+	rootDir := st.rootDir
+
+	tabMedias := append([]*storage.BlockDevice{}, md.TargetMedias...)
+	for _, ra := range md.RaidArrays {
+		tabMedias = append(tabMedias, ra.Device())
+	}
+
+	msg := fmt.Sprintf("Writing mount files")
+	prg := progress.NewLoop(msg)
+	log.Info(msg)
+	if err := storage.GenerateTabFiles(rootDir, tabMedias); err != nil {
+		return err
+	}
+
+	if err := storage.GenerateMdadmConf(rootDir, md.RaidArrays); err != nil {
+		return err
+	}
+	prg.Success()
+
+	if md.KernelArguments != nil && len(md.KernelArguments.Add) > 0 {
+		cmdlineDir := filepath.Join(rootDir, "etc", "kernel")
+		cmdlineFile := filepath.Join(cmdlineDir, "cmdline")
+		cmdline := strings.Join(md.KernelArguments.Add, " ")
+
+		if err := utils.MkdirAll(cmdlineDir, 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(cmdlineFile, []byte(cmdline), 0644); err != nil {
+			return err
+		}
+	}
+
+	if md.KernelArguments != nil && len(md.KernelArguments.Remove) > 0 {
+		cmdlineDir := filepath.Join(rootDir, "etc", "kernel", "cmdline-removal.d")
+		cmdlineFile := filepath.Join(cmdlineDir, "clr-installer.conf")
+		cmdline := strings.Join(md.KernelArguments.Remove, " ")
+
+		if err := utils.MkdirAll(cmdlineDir, 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(cmdlineFile, []byte(cmdline), 0644); err != nil {
+			return err
+		}
+	}
+
+	if prg, err := baseContentInstall(rootDir, st.version, md, st.options); err != nil {
+		prg.Failure()
+		return err
+	}
+
+	return nil
+}
+
+func bundlesPhase(st *installState) error {
+	if prg, err := bundlesInstall(st.rootDir, st.model, st.options); err != nil {
+		// the failed bundles are reported by bundlesInstall
+		if prg != nil {
+			prg.Failure()
+		}
+		return err
+	}
+
+	return nil
+}
+
+func configurePhase(st *installState) error {
+	md := st.model
+
+	if err := configureTimezone(st.rootDir, md); err != nil {
+		// Just log the error, not setting the timezone is not reason to fail the install
+		log.Error("Error setting timezone: %v", err)
+	}
+
+	if err := configureKeyboard(st.rootDir, md); err != nil {
+		// Just log the error, not setting the keyboard is not reason to fail the install
+		log.Error("Error setting keyboard: %v", err)
+	}
+
+	if err := configureLanguage(st.rootDir, md); err != nil {
+		// Just log the error, not setting the language is not reason to fail the install
+		log.Error("Error setting language locale: %v", err)
+	}
+
+	if md.Hostname != "" {
+		if err := hostname.SetTargetHostname(st.rootDir, md.Hostname); err != nil {
+			return err
+		}
+	}
+
+	if md.Telemetry.URL != "" {
+		if err := md.Telemetry.CreateTelemetryConf(st.rootDir); err != nil {
+			return err
+		}
+	}
+
+	if md.PersistProxy && md.Proxy().IsSet() {
+		if err := proxy.WriteTarget(st.rootDir, md.Proxy()); err != nil {
+			return err
+		}
+	}
+
+	if md.PersistNetwork && (len(md.NetworkInterfaces) > 0 || len(md.NetworkDevices) > 0) {
+		msg := "Writing the target network configuration"
+		prg := progress.NewLoop(msg)
+		log.Info(msg)
+		if err := network.ApplyConfig(st.rootDir, md.NetworkInterfaces, md.NetworkDevices); err != nil {
+			prg.Failure()
+			return err
+		}
+
+		if err := network.ApplyTimesync(st.rootDir, md.NetworkInterfaces); err != nil {
+			prg.Failure()
+			return err
+		}
+		prg.Success()
+	}
+
+	// the wireless credentials are only kept in the target if requested
+	if md.PersistWireless && network.HasWireless(md.NetworkInterfaces) {
+		msg := "Writing the target wireless configuration"
+		prg := progress.NewLoop(msg)
+		log.Info(msg)
+		if err := network.ApplyWireless(st.rootDir, md.NetworkInterfaces); err != nil {
+			prg.Failure()
+			return err
+		}
+
+		if err := network.EnableWireless(st.rootDir, md.NetworkInterfaces); err != nil {
+			prg.Failure()
+			return err
+		}
+		prg.Success()
+	}
+
+	return nil
+}
+
+func usersPhase(st *installState) error {
+	if err := cuser.Apply(st.rootDir, st.model.Users); err != nil {
+		return err
+	}
+
+	return cuser.ApplyRoot(st.rootDir, st.model.Root)
+}
+
+func hooksPhase(st *installState) error {
+	return applyHooks("post-install", st.vars, st.model.PostInstall)
+}
+
+func finalizePhase(st *installState) error {
+	msg := "Saving the installation results"
+	prg := progress.NewLoop(msg)
+	log.Info(msg)
+	if err := saveInstallResults(st.rootDir, st.model); err != nil {
+		log.ErrorError(err)
+	}
+	prg.Success()
+
+	return nil
+}
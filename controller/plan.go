@@ -0,0 +1,280 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/progress"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+var (
+	// planQueries are the commands only reading the installer system's state, the
+	// plans run them as the install depends on their output
+	planQueries = [][]string{
+		{"blockdev", "--getsize64"},
+		{"dmsetup", "ls"},
+		{"lsblk"},
+	}
+
+	// planBaseContent are the base system files the install reads from the target,
+	// the plans take them from the installer system
+	planBaseContent = []string{
+		"/usr/share/defaults/telemetrics/telemetrics.conf",
+	}
+
+	shellSafeExp = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./${}-]+$`)
+)
+
+// planExecutor records the commands run by an install instead of executing them,
+// it's also the install's progress client so the progress messages are part of
+// the plan. The files written by the install are reported as they show up in the
+// target and host roots.
+type planExecutor struct {
+	prev     cmd.Executor
+	rootDir  string
+	hostRoot string
+	files    map[string]time.Time
+	lines    []string
+	mutex    sync.Mutex
+}
+
+// Plan runs the installation described by model against a recording executor and
+// temporary target and host roots, and returns the ordered list of operations it
+// would perform; none of them is executed. The progress messages are written as
+// shell comments so the resulting list reads as a script.
+func Plan(rootDir string, model *model.SystemInstall, options args.Args) ([]string, error) {
+	tmpDir, err := ioutil.TempDir("", "clr-installer-plan-")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	st := &installState{
+		rootDir:  filepath.Join(tmpDir, "target"),
+		hostRoot: filepath.Join(tmpDir, "host"),
+		plan:     true,
+		model:    model,
+		options:  options,
+	}
+
+	for _, dir := range []string{st.rootDir, st.hostRoot} {
+		if err = utils.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	pe := &planExecutor{
+		rootDir:  st.rootDir,
+		hostRoot: st.hostRoot,
+		files:    map[string]time.Time{},
+	}
+
+	pe.prev = cmd.SetExecutor(pe)
+	defer cmd.SetExecutor(pe.prev)
+	defer progress.Set(progress.Set(pe))
+	defer storage.SetPlanMode(storage.SetPlanMode(true))
+
+	if err = st.install(&checkpoint{Phases: []string{}}); err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+	for _, line := range pe.result() {
+		line = strings.Replace(line, st.rootDir, rootDir, -1)
+		res = append(res, strings.Replace(line, st.hostRoot, "", -1))
+	}
+
+	return res, nil
+}
+
+// Exec is the cmd.Executor implementation
+func (pe *planExecutor) Exec(c *cmd.Command) error {
+	for _, query := range planQueries {
+		if hasArgsPrefix(c.Args, query) {
+			return pe.prev.Exec(c)
+		}
+	}
+
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+
+	pe.flush()
+	pe.lines = append(pe.lines, quoteArgs(c.Args))
+
+	return pe.simulate(c.Args)
+}
+
+// simulate makes the target look as the command had run, so far that's the swupd
+// bundles the install checks for
+func (pe *planExecutor) simulate(args []string) error {
+	if len(args) < 2 || args[0] != "swupd" || (args[1] != "verify" && args[1] != "bundle-add") {
+		return nil
+	}
+
+	root := ""
+	bundles := []string{}
+
+	for _, curr := range args[2:] {
+		if strings.HasPrefix(curr, "--path=") {
+			root = strings.TrimPrefix(curr, "--path=")
+		} else if !strings.HasPrefix(curr, "-") && args[1] == "bundle-add" {
+			bundles = append(bundles, curr)
+		}
+	}
+
+	if root == "" || (args[1] == "verify" && !utils.StringSliceContains(args, "--install")) {
+		return nil
+	}
+
+	files := []string{}
+
+	if args[1] == "verify" {
+		bundles = append(bundles, "os-core")
+		files = append(files, planBaseContent...)
+	}
+
+	for _, bundle := range bundles {
+		files = append(files, filepath.Join("/usr/share/clear/bundles", bundle))
+	}
+
+	for _, curr := range files {
+		target := filepath.Join(root, curr)
+
+		if err := utils.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(curr)
+		if err != nil || strings.HasPrefix(curr, "/usr/share/clear/bundles") {
+			content = []byte{}
+		}
+
+		if err = ioutil.WriteFile(target, content, 0644); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	// the simulated content is not written by the install
+	pe.scan(false)
+
+	return nil
+}
+
+// flush adds the files written since the last flush to the plan
+func (pe *planExecutor) flush() {
+	pe.scan(true)
+}
+
+// scan walks the roots looking for the new or modified files, reported if report
+// is true
+func (pe *planExecutor) scan(report bool) {
+	for _, root := range []string{pe.rootDir, pe.hostRoot} {
+		_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+
+			if mtime, ok := pe.files[path]; ok && mtime.Equal(info.ModTime()) {
+				return nil
+			}
+
+			pe.files[path] = info.ModTime()
+
+			if report {
+				pe.lines = append(pe.lines, "# write "+quoteArgs([]string{path}))
+			}
+
+			return nil
+		})
+	}
+}
+
+func (pe *planExecutor) result() []string {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+
+	pe.flush()
+
+	return append([]string{}, pe.lines...)
+}
+
+// Desc is the progress.Client implementation, the progress messages are written as
+// shell comments
+func (pe *planExecutor) Desc(desc string) {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+
+	pe.flush()
+	pe.lines = append(pe.lines, "# "+desc)
+}
+
+// Partial is the progress.Client implementation
+func (pe *planExecutor) Partial(total int, step int) {}
+
+// Step is the progress.Client implementation
+func (pe *planExecutor) Step() {}
+
+// Success is the progress.Client implementation
+func (pe *planExecutor) Success() {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+
+	pe.flush()
+}
+
+// Failure is the progress.Client implementation
+func (pe *planExecutor) Failure() {
+	pe.Success()
+}
+
+// LoopWaitDuration is the progress.Client implementation
+func (pe *planExecutor) LoopWaitDuration() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func hasArgsPrefix(args []string, prefix []string) bool {
+	if len(args) < len(prefix) {
+		return false
+	}
+
+	for idx, curr := range prefix {
+		if args[idx] != curr {
+			return false
+		}
+	}
+
+	return true
+}
+
+// quoteArgs returns the command args as a shell command line
+func quoteArgs(args []string) string {
+	res := []string{}
+
+	for _, curr := range args {
+		if !shellSafeExp.MatchString(curr) {
+			curr = "'" + strings.Replace(curr, "'", `'\''`, -1) + "'"
+		}
+
+		res = append(res, curr)
+	}
+
+	return strings.Join(res, " ")
+}
@@ -6,6 +6,8 @@ package massinstall
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
 
@@ -115,7 +117,7 @@ func (mi *MassInstall) MustRun(args *args.Args) bool {
 	return args.ConfigFile != "" && !args.ForceTUI
 }
 
-func shouldReboot() (bool, bool, error) {
+func shouldReboot(out io.Writer) (bool, bool, error) {
 	var answer string
 	va := map[string]bool{
 		"y":   true,
@@ -124,7 +126,7 @@ func shouldReboot() (bool, bool, error) {
 		"no":  false,
 	}
 
-	fmt.Printf("reboot?[Y|n]: ")
+	fmt.Fprintf(out, "reboot?[Y|n]: ")
 	_, err := fmt.Scanf("%s", &answer)
 	if err != nil {
 		return false, false, err
@@ -150,18 +152,39 @@ func shouldReboot() (bool, bool, error) {
 func (mi *MassInstall) Run(md *model.SystemInstall, rootDir string, options args.Args) (bool, error) {
 	var instError error
 
-	progress.Set(mi)
+	// the human readable messages would corrupt a JSON stream written to stdout
+	var out io.Writer = os.Stdout
+	if options.ProgressJSON == progress.JSONStdout {
+		out = os.Stderr
+	}
+
+	if options.ProgressJSON != "" {
+		jc, err := progress.OpenJSON(options.ProgressJSON)
+		if err != nil {
+			return false, errors.Wrap(err)
+		}
+
+		defer func() {
+			if err := jc.Close(); err != nil {
+				log.Warning("Failed to write the progress events: %s", err)
+			}
+		}()
+
+		progress.Set(jc)
+	} else {
+		progress.Set(mi)
+	}
 
 	log.Debug("Starting install")
 
 	if md.Version > 0 {
-		fmt.Println("Config file specifies a target \"version\", forcing auto-update off.")
+		fmt.Fprintln(out, "Config file specifies a target \"version\", forcing auto-update off.")
 	}
 
 	instError = controller.Install(rootDir, md, options)
 	if instError != nil {
 		if !errors.IsValidationError(instError) {
-			fmt.Printf("ERROR: Installation has failed!\n")
+			fmt.Fprintf(out, "ERROR: Installation has failed!\n")
 		}
 		return false, instError
 	}
@@ -175,12 +198,12 @@ func (mi *MassInstall) Run(md *model.SystemInstall, rootDir string, options args
 			var valid bool
 			var err error
 
-			if valid, reboot, err = shouldReboot(); err != nil {
+			if valid, reboot, err = shouldReboot(out); err != nil {
 				panic(err)
 			}
 
 			if !valid {
-				fmt.Printf("Invalid answer...\n")
+				fmt.Fprintf(out, "Invalid answer...\n")
 				continue
 			}
 
@@ -20,6 +20,7 @@ import (
 	"github.com/clearlinux/clr-installer/keyboard"
 	"github.com/clearlinux/clr-installer/language"
 	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/proxy"
 	"github.com/clearlinux/clr-installer/storage"
 	"github.com/clearlinux/clr-installer/telemetry"
 	"github.com/clearlinux/clr-installer/timezone"
@@ -27,6 +28,19 @@ import (
 	"github.com/clearlinux/clr-installer/utils"
 )
 
+const (
+	// BundleFailureAbort fails the install if any bundle fails to install
+	BundleFailureAbort = "abort"
+
+	// BundleFailureContinue reports the optional bundles failing to install and
+	// carries on, the required bundles failing to install always fail the install
+	BundleFailureContinue = "continue"
+
+	// BundleFailureRetry retries the bundles failing to install one by one, then
+	// carries on as BundleFailureContinue
+	BundleFailureRetry = "retry"
+)
+
 // Version of Clear Installer.
 // Also used by the Makefile for releases.
 // Default to the version of the program
@@ -41,32 +55,47 @@ var testAlias = []string{}
 // SystemInstall represents the system install "configuration", the target
 // medias, bundles to install and whatever state a install may require
 type SystemInstall struct {
-	TargetMedias      []*storage.BlockDevice `yaml:"targetMedia"`
-	NetworkInterfaces []*network.Interface   `yaml:"networkInterfaces"`
-	Keyboard          *keyboard.Keymap       `yaml:"keyboard,omitempty,flow"`
-	Language          *language.Language     `yaml:"language,omitempty,flow"`
-	Bundles           []string               `yaml:"bundles,omitempty,flow"`
-	HTTPSProxy        string                 `yaml:"httpsProxy,omitempty,flow"`
-	Telemetry         *telemetry.Telemetry   `yaml:"telemetry,omitempty,flow"`
-	Timezone          *timezone.TimeZone     `yaml:"timezone,omitempty,flow"`
-	Users             []*user.User           `yaml:"users,omitempty,flow"`
-	KernelArguments   *kernel.Arguments      `yaml:"kernel-arguments,omitempty,flow"`
-	Kernel            *kernel.Kernel         `yaml:"kernel,omitempty,flow"`
-	PostReboot        bool                   `yaml:"postReboot,omitempty,flow"`
-	SwupdMirror       string                 `yaml:"swupdMirror,omitempty,flow"`
-	PostArchive       bool                   `yaml:"postArchive,omitempty,flow"`
-	Hostname          string                 `yaml:"hostname,omitempty,flow"`
-	AutoUpdate        bool                   `yaml:"autoUpdate,omitempty,flow"`
-	TelemetryURL      string                 `yaml:"telemetryURL,omitempty,flow"`
-	TelemetryTID      string                 `yaml:"telemetryTID,omitempty,flow"`
-	TelemetryPolicy   string                 `yaml:"telemetryPolicy,omitempty,flow"`
-	PreInstall        []*InstallHook         `yaml:"pre-install,omitempty,flow"`
-	PostInstall       []*InstallHook         `yaml:"post-install,omitempty,flow"`
-	Version           uint                   `yaml:"version,omitempty,flow"`
-	StorageAlias      []*StorageAlias        `yaml:"block-devices,omitempty,flow"`
-	LegacyBios        bool                   `yaml:"legacyBios,omitempty,flow"`
-	Environment       map[string]string      `yaml:"env,omitempty,flow"`
-	CryptPass         string                 `yaml:"-"`
+	TargetMedias      []*storage.BlockDevice     `yaml:"targetMedia"`
+	RaidArrays        []*storage.RaidArray       `yaml:"raidArrays,omitempty"`
+	NetworkInterfaces []*network.Interface       `yaml:"networkInterfaces"`
+	NetworkDevices    []*network.NetDev          `yaml:"networkDevices,omitempty"`
+	HostNetDevices    bool                       `yaml:"hostNetworkDevices,omitempty,flow"`
+	PersistNetwork    bool                       `yaml:"persistNetwork"`
+	PersistWireless   bool                       `yaml:"persistWireless,omitempty,flow"`
+	ConnectivityCheck *network.ConnectivityCheck `yaml:"connectivityCheck,omitempty"`
+	Keyboard          *keyboard.Keymap           `yaml:"keyboard,omitempty,flow"`
+	Language          *language.Language         `yaml:"language,omitempty,flow"`
+	Bundles           []string                   `yaml:"bundles,omitempty,flow"`
+	RequiredBundles   []string                   `yaml:"requiredBundles,omitempty,flow"`
+	OnBundleFailure   string                     `yaml:"onBundleFailure,omitempty,flow"`
+	HTTPProxy         string                     `yaml:"httpProxy,omitempty,flow"`
+	HTTPSProxy        string                     `yaml:"httpsProxy,omitempty,flow"`
+	NoProxy           []string                   `yaml:"noProxy,omitempty,flow"`
+	ProxyUser         string                     `yaml:"proxyUser,omitempty,flow"`
+	ProxyPassword     string                     `yaml:"proxyPassword,omitempty,flow"`
+	PersistProxy      bool                       `yaml:"persistProxy,omitempty,flow"`
+	Telemetry         *telemetry.Telemetry       `yaml:"telemetry,omitempty,flow"`
+	Timezone          *timezone.TimeZone         `yaml:"timezone,omitempty,flow"`
+	Users             []*user.User               `yaml:"users,omitempty,flow"`
+	Root              *user.Root                 `yaml:"root,omitempty"`
+	KernelArguments   *kernel.Arguments          `yaml:"kernel-arguments,omitempty,flow"`
+	Kernel            *kernel.Kernel             `yaml:"kernel,omitempty,flow"`
+	PostReboot        bool                       `yaml:"postReboot,omitempty,flow"`
+	SwupdMirror       string                     `yaml:"swupdMirror,omitempty,flow"`
+	OfflineContent    string                     `yaml:"offlineContent,omitempty,flow"`
+	PostArchive       bool                       `yaml:"postArchive,omitempty,flow"`
+	Hostname          string                     `yaml:"hostname,omitempty,flow"`
+	AutoUpdate        bool                       `yaml:"autoUpdate,omitempty,flow"`
+	TelemetryURL      string                     `yaml:"telemetryURL,omitempty,flow"`
+	TelemetryTID      string                     `yaml:"telemetryTID,omitempty,flow"`
+	TelemetryPolicy   string                     `yaml:"telemetryPolicy,omitempty,flow"`
+	PreInstall        []*InstallHook             `yaml:"pre-install,omitempty,flow"`
+	PostInstall       []*InstallHook             `yaml:"post-install,omitempty,flow"`
+	Version           uint                       `yaml:"version,omitempty,flow"`
+	StorageAlias      []*StorageAlias            `yaml:"block-devices,omitempty,flow"`
+	LegacyBios        bool                       `yaml:"legacyBios,omitempty,flow"`
+	Environment       map[string]string          `yaml:"env,omitempty,flow"`
+	CryptPass         string                     `yaml:"-"`
 }
 
 // InstallHook is a commands to be executed in a given point of the install process
@@ -156,6 +185,21 @@ func (si *SystemInstall) AddBundle(bundle string) {
 	si.Bundles = append(si.Bundles, bundle)
 }
 
+// AddRequiredBundle adds a new required bundle to the data model, the install fails
+// if a required bundle can not be installed
+func (si *SystemInstall) AddRequiredBundle(bundle string) {
+	if si.IsRequiredBundle(bundle) {
+		return
+	}
+
+	si.RequiredBundles = append(si.RequiredBundles, bundle)
+}
+
+// IsRequiredBundle returns true if bundle is a required bundle
+func (si *SystemInstall) IsRequiredBundle(bundle string) bool {
+	return utils.StringSliceContains(si.RequiredBundles, bundle)
+}
+
 // RemoveAllUsers remove from the data model all previously added user
 func (si *SystemInstall) RemoveAllUsers() {
 	si.Users = []*user.User{}
@@ -195,10 +239,48 @@ func (si *SystemInstall) Validate() error {
 		return errors.ValidationErrorf("System Installation must provide a target media")
 	}
 
-	for _, curr := range si.TargetMedias {
-		if err := curr.Validate(si.LegacyBios, si.CryptPass); err != nil {
+	// raid arrays span multiple target medias, validate them as a whole
+	if len(si.RaidArrays) > 0 {
+		if err := storage.ValidateRaidArrays(si.TargetMedias, si.RaidArrays,
+			si.LegacyBios, si.CryptPass); err != nil {
 			return err
 		}
+	} else {
+		for _, curr := range si.TargetMedias {
+			if err := curr.Validate(si.LegacyBios, si.CryptPass); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := si.Proxy().Validate(); err != nil {
+		return err
+	}
+
+	if si.ConnectivityCheck != nil {
+		if err := si.ConnectivityCheck.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := user.ValidateUsers(si.Users); err != nil {
+		return err
+	}
+
+	if si.Root != nil {
+		if err := si.Root.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, curr := range si.NetworkInterfaces {
+		if err := curr.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := network.ValidateNetDevs(si.NetworkDevices, si.NetworkInterfaces); err != nil {
+		return err
 	}
 
 	if si.Timezone == nil {
@@ -221,6 +303,17 @@ func (si *SystemInstall) Validate() error {
 		return errors.ValidationErrorf("A kernel must be provided")
 	}
 
+	switch si.OnBundleFailure {
+	case "", BundleFailureAbort, BundleFailureContinue, BundleFailureRetry:
+	default:
+		return errors.ValidationErrorf("Invalid onBundleFailure value: %q", si.OnBundleFailure)
+	}
+
+	if si.OfflineContent != "" && !filepath.IsAbs(si.OfflineContent) {
+		return errors.ValidationErrorf("The offline content must be an absolute path: %s",
+			si.OfflineContent)
+	}
+
 	return nil
 }
 
@@ -242,6 +335,17 @@ func (si *SystemInstall) AddTargetMedia(bd *storage.BlockDevice) {
 	si.TargetMedias = nList
 }
 
+// Proxy returns the proxy configuration
+func (si *SystemInstall) Proxy() proxy.Config {
+	return proxy.Config{
+		HTTP:     si.HTTPProxy,
+		HTTPS:    si.HTTPSProxy,
+		NoProxy:  si.NoProxy,
+		User:     si.ProxyUser,
+		Password: si.ProxyPassword,
+	}
+}
+
 // AddNetworkInterface adds an Interface instance to the list of NetworkInterfaces
 func (si *SystemInstall) AddNetworkInterface(iface *network.Interface) {
 	if si.NetworkInterfaces == nil {
@@ -253,6 +357,21 @@ func (si *SystemInstall) AddNetworkInterface(iface *network.Interface) {
 
 // LoadFile loads a model from a yaml file pointed by path
 func LoadFile(path string, options args.Args) (*SystemInstall, error) {
+	var configStr []byte
+
+	if _, err := os.Stat(path); err == nil {
+		configStr, err = ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	return Load(configStr, options)
+}
+
+// Load loads a model from the yaml formatted content, an empty content results in
+// the default model
+func Load(content []byte, options args.Args) (*SystemInstall, error) {
 	var result SystemInstall
 
 	// Default to archiving by default
@@ -261,16 +380,11 @@ func LoadFile(path string, options args.Args) (*SystemInstall, error) {
 	// Default to Auto Updating enabled by default
 	result.AutoUpdate = true
 
-	if _, err := os.Stat(path); err == nil {
-		configStr, err := ioutil.ReadFile(path)
-		if err != nil {
-			return nil, errors.Wrap(err)
-		}
+	// Default to the target using the installer's network configuration
+	result.PersistNetwork = true
 
-		err = yaml.Unmarshal(configStr, &result)
-		if err != nil {
-			return nil, errors.Wrap(err)
-		}
+	if err := yaml.Unmarshal(content, &result); err != nil {
+		return nil, errors.Wrap(err)
 	}
 
 	// Set default Timezone if not defined
@@ -381,6 +495,12 @@ func isTestAlias(file string) bool {
 	return utils.StringSliceContains(testAlias, file)
 }
 
+// IsOffline returns true if the install uses the local offline content, in that case
+// the network is not required
+func (si *SystemInstall) IsOffline() bool {
+	return si.OfflineContent != ""
+}
+
 // EnableTelemetry operates on the telemetry flag and enables or disables the target
 // systems telemetry support based in enable argument
 func (si *SystemInstall) EnableTelemetry(enable bool) {
@@ -400,9 +520,64 @@ func (si *SystemInstall) IsTelemetryEnabled() bool {
 	return si.Telemetry.Enabled
 }
 
+// Clone returns a deep copy of si, as written to and read back from a yaml file
+// plus the encryption passphrase
+func (si *SystemInstall) Clone() (*SystemInstall, error) {
+	b, err := yaml.Marshal(si)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	res := &SystemInstall{}
+	if err = yaml.Unmarshal(b, res); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	res.CryptPass = si.CryptPass
+
+	return res, nil
+}
+
+// Redacted returns a copy of si without the passwords and keys: the users and root
+// passwords, the proxy password, the wireless keys and the encryption passphrase
+func (si *SystemInstall) Redacted() (*SystemInstall, error) {
+	res, err := si.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	res.CryptPass = ""
+
+	for _, curr := range res.Users {
+		curr.Password = ""
+	}
+
+	if res.Root != nil {
+		res.Root.Password = ""
+	}
+
+	res.ProxyPassword = ""
+
+	for _, curr := range res.NetworkInterfaces {
+		if curr.Wireless == nil {
+			continue
+		}
+
+		curr.Wireless.PSK = ""
+
+		if curr.Wireless.EAP != nil {
+			curr.Wireless.EAP.Password = ""
+			curr.Wireless.EAP.PrivateKeyPassword = ""
+		}
+	}
+
+	return res, nil
+}
+
 // WriteFile writes a yaml formatted representation of si into the provided file path
 func (si *SystemInstall) WriteFile(path string) error {
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	// the descriptor may hold the passwords and keys, it's only readable by root
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -44,8 +44,24 @@ func TestLoadFile(t *testing.T) {
 		{"mixed-block-device.yaml", true},
 		{"real-example.yaml", true},
 		{"user-sshkeys.yaml", true},
+		{"valid-users.yaml", true},
+		{"valid-root.yaml", true},
 		{"valid-minimal.yaml", true},
+		{"valid-btrfs-subvolumes.yaml", true},
+		{"valid-existing-partitions.yaml", true},
+		{"valid-lvm.yaml", true},
+		{"valid-raid.yaml", true},
+		{"valid-relative-sizes.yaml", true},
+		{"valid-required-bundles.yaml", true},
 		{"valid-network.yaml", true},
+		{"valid-network-ipv6.yaml", true},
+		{"valid-network-devices.yaml", true},
+		{"valid-network-match.yaml", true},
+		{"valid-network-nameservers.yaml", true},
+		{"valid-network-wireless.yaml", true},
+		{"valid-proxy.yaml", true},
+		{"valid-connectivity-check.yaml", true},
+		{"valid-offline.yaml", true},
 		{"valid-with-pre-post-hooks.yaml", true},
 		{"valid-with-version.yaml", true},
 	}
@@ -188,6 +204,54 @@ func TestEnableTelemetry(t *testing.T) {
 	}
 }
 
+func TestOfflineContent(t *testing.T) {
+	path := filepath.Join(testsDir, "valid-offline.yaml")
+
+	si, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("Failed to load %s: %v", path, err)
+	}
+
+	if !si.IsOffline() {
+		t.Fatal("The offline content should be set")
+	}
+
+	si.OfflineContent = "swupd"
+	if err = si.Validate(); err == nil {
+		t.Fatal("A relative offline content path should be rejected")
+	}
+}
+
+func TestOnBundleFailure(t *testing.T) {
+	path := filepath.Join(testsDir, "valid-required-bundles.yaml")
+
+	si, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("Failed to load %s: %v", path, err)
+	}
+
+	if !si.IsRequiredBundle("containers-basic") || si.IsRequiredBundle("editors") {
+		t.Fatalf("Invalid required bundles: %v", si.RequiredBundles)
+	}
+
+	si.AddRequiredBundle("containers-basic")
+	if len(si.RequiredBundles) != 1 {
+		t.Fatalf("Required bundles should not be duplicated: %v", si.RequiredBundles)
+	}
+
+	for _, curr := range []string{"", BundleFailureAbort, BundleFailureContinue, BundleFailureRetry} {
+		si.OnBundleFailure = curr
+		if err = si.Validate(); err != nil {
+			t.Fatalf("onBundleFailure %q should be valid: %v", curr, err)
+		}
+	}
+
+	si.OnBundleFailure = "ignore"
+	if err = si.Validate(); err == nil {
+		t.Fatal("An unknown onBundleFailure should be rejected")
+	}
+}
+
 func TestUnreadable(t *testing.T) {
 	file, err := ioutil.TempFile("", "test-")
 	if err != nil {
@@ -289,6 +353,10 @@ func TestAddNetworkInterface(t *testing.T) {
 		t.Fatal("Failed to load a valid descriptor")
 	}
 
+	if !loaded.PersistNetwork {
+		t.Fatal("The network configuration should be persisted by default")
+	}
+
 	nm := &SystemInstall{}
 	nm.AddNetworkInterface(loaded.NetworkInterfaces[0])
 	if len(nm.NetworkInterfaces) != 1 {
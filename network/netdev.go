@@ -0,0 +1,348 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+// A NetDev describes a virtual network device: a bond of member interfaces, a
+// 802.1Q VLAN on a parent interface or a bridge. The device's own addresses are
+// configured by a networkInterfaces entry with the device's name.
+type NetDev struct {
+	Name    string    `yaml:"name"`
+	Kind    string    `yaml:"kind"`
+	Mode    string    `yaml:"mode,omitempty"`
+	Members []*Member `yaml:"members,omitempty,flow"`
+	Parent  string    `yaml:"parent,omitempty"`
+	ID      int       `yaml:"id,omitempty"`
+}
+
+// A Member is an interface enslaved to a bond or bridge, it's matched by its name
+// unless a MAC address, driver or path is given; the name then only names its
+// .network file
+type Member struct {
+	Name       string `yaml:"name"`
+	MACAddress string `yaml:"macAddress,omitempty"`
+	Driver     string `yaml:"driver,omitempty"`
+	Path       string `yaml:"path,omitempty"`
+}
+
+// memberYAMLMarshal is the YAML representation of a member with match criteria
+type memberYAMLMarshal Member
+
+const (
+	// NetDevBond identifies a NetDev as a bond
+	NetDevBond = "bond"
+
+	// NetDevVLAN identifies a NetDev as a 802.1Q VLAN
+	NetDevVLAN = "vlan"
+
+	// NetDevBridge identifies a NetDev as a bridge
+	NetDevBridge = "bridge"
+)
+
+var (
+	// the bonding modes supported by systemd-networkd
+	bondModes = []string{
+		"balance-rr",
+		"active-backup",
+		"balance-xor",
+		"broadcast",
+		"802.3ad",
+		"balance-tlb",
+		"balance-alb",
+	}
+
+	netDevTemplate = template.Must(template.New("").Parse(`[NetDev]
+Name={{.Name}}
+Kind={{.Kind}}
+{{- if and (eq .Kind "bond") .Mode}}
+
+[Bond]
+Mode={{.Mode}}
+{{- end}}
+{{- if eq .Kind "vlan"}}
+
+[VLAN]
+Id={{.ID}}
+{{- end}}
+`))
+
+	memberTemplate = template.Must(template.New("").Parse(`[Match]
+{{- if .MACAddress}}
+MACAddress={{.MACAddress}}
+{{- end}}
+{{- if .Driver}}
+Driver={{.Driver}}
+{{- end}}
+{{- if .Path}}
+Path={{.Path}}
+{{- end}}
+{{- if not .HasMatch}}
+Name={{.Name}}
+{{- end}}
+
+[Network]
+{{.Option}}={{.NetDev}}
+`))
+)
+
+// HasMatch returns true if the member is matched by its MAC address, driver or path
+// rather than by its name
+func (m *Member) HasMatch() bool {
+	return m.MACAddress != "" || m.Driver != "" || m.Path != ""
+}
+
+// MarshalYAML marshals Member into YAML format, a member only matched by its name
+// is written as the name
+func (m *Member) MarshalYAML() (interface{}, error) {
+	if !m.HasMatch() {
+		return m.Name, nil
+	}
+
+	return (*memberYAMLMarshal)(m), nil
+}
+
+// UnmarshalYAML unmarshals Member from YAML format, either an interface name or a
+// mapping with the match criteria
+func (m *Member) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+
+	if err := unmarshal(&name); err == nil {
+		*m = Member{Name: name}
+		return nil
+	}
+
+	return unmarshal((*memberYAMLMarshal)(m))
+}
+
+// validate checks the member's name and match criteria
+func (m *Member) validate(netdev string) error {
+	if m.Name == "" {
+		return errors.Errorf("%s: a member must have a name", netdev)
+	}
+
+	if m.MACAddress != "" {
+		if _, err := net.ParseMAC(m.MACAddress); err != nil {
+			return errors.Errorf("%s: invalid MAC address of member %s: %s", netdev, m.Name, m.MACAddress)
+		}
+	}
+
+	return nil
+}
+
+func isBondMode(mode string) bool {
+	for _, curr := range bondModes {
+		if curr == mode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks the nd's definition
+func (nd *NetDev) Validate() error {
+	if nd.Name == "" {
+		return errors.Errorf("A network device must have a name")
+	}
+
+	switch nd.Kind {
+	case NetDevBond:
+		if len(nd.Members) == 0 {
+			return errors.Errorf("%s: a bond must have at least one member", nd.Name)
+		}
+
+		if nd.Mode != "" && !isBondMode(nd.Mode) {
+			return errors.Errorf("%s: invalid bond mode: %s", nd.Name, nd.Mode)
+		}
+	case NetDevVLAN:
+		if nd.Parent == "" {
+			return errors.Errorf("%s: a vlan must have a parent interface", nd.Name)
+		}
+
+		if nd.ID < 1 || nd.ID > 4094 {
+			return errors.Errorf("%s: invalid vlan id: %d", nd.Name, nd.ID)
+		}
+
+		if len(nd.Members) > 0 {
+			return errors.Errorf("%s: a vlan has no members", nd.Name)
+		}
+	case NetDevBridge:
+	default:
+		return errors.Errorf("%s: invalid network device kind: %q", nd.Name, nd.Kind)
+	}
+
+	if nd.Kind != NetDevVLAN && nd.Parent != "" {
+		return errors.Errorf("%s: only a vlan has a parent interface", nd.Name)
+	}
+
+	for _, member := range nd.Members {
+		if err := member.validate(nd.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateNetDevs checks the netdevs definitions, a bond or bridge member is managed
+// by its device so it can't be configured as an interface, be a VLAN parent nor be a
+// member twice
+func ValidateNetDevs(netdevs []*NetDev, ifaces []*Interface) error {
+	names := map[string]bool{}
+	members := map[string]string{}
+
+	for _, nd := range netdevs {
+		if err := nd.Validate(); err != nil {
+			return err
+		}
+
+		if names[nd.Name] {
+			return errors.Errorf("Duplicated network device: %s", nd.Name)
+		}
+		names[nd.Name] = true
+
+		for _, member := range nd.Members {
+			if prev, ok := members[member.Name]; ok {
+				return errors.Errorf("Interface %s is a member of both %s and %s", member.Name, prev, nd.Name)
+			}
+			members[member.Name] = nd.Name
+		}
+	}
+
+	for _, iface := range ifaces {
+		if nd, ok := members[iface.Name]; ok {
+			return errors.Errorf("Interface %s is a member of %s and can not be configured", iface.Name, nd)
+		}
+	}
+
+	for _, nd := range netdevs {
+		if prev, ok := members[nd.Parent]; ok && nd.Kind == NetDevVLAN {
+			return errors.Errorf("%s: the parent interface %s is a member of %s", nd.Name, nd.Parent, prev)
+		}
+	}
+
+	return nil
+}
+
+// writeConfigFile renders tmpl with data to the fileName networkd file of root
+func writeConfigFile(root string, fileName string, tmpl *template.Template, data interface{}) error {
+	return writeFile(filepath.Join(root, configDir, fileName), 0644, tmpl, data)
+}
+
+// writeFile renders tmpl with data to path, created with perm
+func writeFile(path string, perm os.FileMode, tmpl *template.Template, data interface{}) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err = tmpl.Execute(f, data); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// apply writes the nd's .netdev file and the .network files of its members
+func (nd *NetDev) apply(root string) error {
+	log.Info("Applying the %s %s network device to %s", nd.Name, nd.Kind, root)
+
+	if err := writeConfigFile(root, fmt.Sprintf("05-%s.netdev", nd.Name), netDevTemplate, nd); err != nil {
+		return err
+	}
+
+	option := "Bond"
+	if nd.Kind == NetDevBridge {
+		option = "Bridge"
+	}
+
+	for _, member := range nd.Members {
+		data := struct {
+			*Member
+			Option string
+			NetDev string
+		}{member, option, nd.Name}
+
+		if err := writeConfigFile(root, fmt.Sprintf("10-%s.network", member.Name), memberTemplate, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyConfig applies the network devices and the interfaces configurations to the
+// system at root. The VLANs are declared in their parent's .network file, a parent
+// not configured in ifaces only carries its VLANs.
+func ApplyConfig(root string, ifaces []*Interface, netdevs []*NetDev) error {
+	if root == "" {
+		return errors.Errorf("Could not apply network settings, Invalid root directory: %s", root)
+	}
+
+	dir := filepath.Join(root, configDir)
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if err := ValidateNetDevs(netdevs, ifaces); err != nil {
+		return err
+	}
+
+	vlans := map[string][]string{}
+
+	for _, nd := range netdevs {
+		if err := nd.apply(root); err != nil {
+			return err
+		}
+
+		if nd.Kind == NetDevVLAN {
+			vlans[nd.Parent] = append(vlans[nd.Parent], nd.Name)
+		}
+	}
+
+	all := []*Interface{}
+
+	for _, curr := range ifaces {
+		curr.vlans = vlans[curr.Name]
+		delete(vlans, curr.Name)
+
+		all = append(all, curr)
+	}
+
+	parents := []string{}
+	for parent := range vlans {
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+
+	for _, parent := range parents {
+		all = append(all, &Interface{Name: parent, vlans: vlans[parent]})
+	}
+
+	for _, curr := range all {
+		log.Info("Applying the %s network configuration to %s", curr.Name, root)
+
+		if err := curr.Apply(root); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
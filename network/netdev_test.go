@@ -0,0 +1,126 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestApplyNetDevs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	netdevs := []*NetDev{
+		{Name: "bond0", Kind: NetDevBond, Mode: "802.3ad", Members: []*Member{{Name: "eno1"},
+			{Name: "eno2", MACAddress: "00:11:22:33:44:55"}}},
+		{Name: "vlan10", Kind: NetDevVLAN, Parent: "bond0", ID: 10},
+		{Name: "vlan20", Kind: NetDevVLAN, Parent: "eno3", ID: 20},
+		{Name: "br0", Kind: NetDevBridge, Members: []*Member{{Name: "eno4"}}},
+	}
+
+	ifaces := []*Interface{
+		{Name: "bond0", Addrs: []*Addr{{"10.0.0.5", "255.255.255.0", IPv4}}, Gateway: "10.0.0.1"},
+		{Name: "br0", DHCP: true},
+	}
+
+	if err = ApplyConfig(dir, ifaces, netdevs); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"05-bond0.netdev":  "[NetDev]\nName=bond0\nKind=bond\n\n[Bond]\nMode=802.3ad\n",
+		"05-vlan10.netdev": "[NetDev]\nName=vlan10\nKind=vlan\n\n[VLAN]\nId=10\n",
+		"05-br0.netdev":    "[NetDev]\nName=br0\nKind=bridge\n",
+		"10-eno1.network":  "[Match]\nName=eno1\n\n[Network]\nBond=bond0\n",
+		"10-eno2.network":  "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nBond=bond0\n",
+		"10-eno4.network":  "[Match]\nName=eno4\n\n[Network]\nBridge=br0\n",
+		"10-bond0.network": "[Match]\nName=bond0\n\n[Network]\nAddress=10.0.0.5/24\nGateway=10.0.0.1\nVLAN=vlan10\n",
+		"10-eno3.network":  "[Match]\nName=eno3\n\n[Network]\nVLAN=vlan20\n",
+	}
+
+	for file, content := range expected {
+		b, err := ioutil.ReadFile(filepath.Join(dir, configDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != content {
+			t.Fatalf("Unexpected %s, expected:\n%s\ngot:\n%s", file, content, b)
+		}
+	}
+
+	// the dhcp only bridge is left to the default configuration
+	if _, err = os.Stat(filepath.Join(dir, configDir, "10-br0.network")); !os.IsNotExist(err) {
+		t.Fatal("The dhcp only bridge should not have a network file")
+	}
+}
+
+func TestValidateNetDevs(t *testing.T) {
+	tests := []struct {
+		netdevs []*NetDev
+		ifaces  []*Interface
+	}{
+		{[]*NetDev{{Name: "bond0", Kind: NetDevBond}}, nil},
+		{[]*NetDev{{Name: "bond0", Kind: NetDevBond, Mode: "lacp", Members: []*Member{{Name: "eno1"}}}}, nil},
+		{[]*NetDev{{Name: "bond0", Kind: NetDevBond, Members: []*Member{{MACAddress: "00:11:22:33:44:55"}}}}, nil},
+		{[]*NetDev{{Name: "bond0", Kind: NetDevBond, Members: []*Member{{Name: "eno1", MACAddress: "00:11"}}}}, nil},
+		{[]*NetDev{{Name: "vlan10", Kind: NetDevVLAN, ID: 10}}, nil},
+		{[]*NetDev{{Name: "vlan10", Kind: NetDevVLAN, Parent: "eno1", ID: 5000}}, nil},
+		{[]*NetDev{{Name: "tun0", Kind: "tun"}}, nil},
+		{[]*NetDev{{Name: "br0", Kind: NetDevBridge}, {Name: "br0", Kind: NetDevBridge}}, nil},
+		{[]*NetDev{{Name: "br0", Kind: NetDevBridge, Members: []*Member{{Name: "eno1"}}},
+			{Name: "bond0", Kind: NetDevBond, Members: []*Member{{Name: "eno1"}}}}, nil},
+		{[]*NetDev{{Name: "br0", Kind: NetDevBridge, Members: []*Member{{Name: "eno1"}}}},
+			[]*Interface{{Name: "eno1", DHCP: true}}},
+		{[]*NetDev{{Name: "br0", Kind: NetDevBridge, Members: []*Member{{Name: "eno1"}}},
+			{Name: "vlan10", Kind: NetDevVLAN, Parent: "eno1", ID: 10}}, nil},
+	}
+
+	for idx, curr := range tests {
+		if err := ValidateNetDevs(curr.netdevs, curr.ifaces); err == nil {
+			t.Fatalf("Test %d: the network devices should be rejected", idx)
+		}
+	}
+
+	valid := []*NetDev{
+		{Name: "bond0", Kind: NetDevBond, Members: []*Member{{Name: "eno1"}, {Name: "eno2"}}},
+		{Name: "vlan10", Kind: NetDevVLAN, Parent: "bond0", ID: 10},
+	}
+
+	if err := ValidateNetDevs(valid, []*Interface{{Name: "bond0", DHCP: true}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemberYAML(t *testing.T) {
+	nd := &NetDev{}
+
+	content := "name: bond0\nkind: bond\nmembers: [eno1, {name: eno2, driver: e1000e}]\n"
+	if err := yaml.Unmarshal([]byte(content), nd); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nd.Members) != 2 || nd.Members[0].Name != "eno1" || nd.Members[0].HasMatch() ||
+		nd.Members[1].Name != "eno2" || nd.Members[1].Driver != "e1000e" {
+		t.Fatalf("Unexpected members: %+v %+v", nd.Members[0], nd.Members[1])
+	}
+
+	b, err := yaml.Marshal(nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != content {
+		t.Fatalf("Unexpected YAML, expected:\n%s\ngot:\n%s", content, b)
+	}
+}
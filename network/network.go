@@ -6,6 +6,7 @@ package network
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -15,32 +16,59 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"unicode"
 
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
 )
 
 // Interface is a network interface representation and wraps the net' package Interface struct
 type Interface struct {
-	Name        string
-	Addrs       []*Addr
-	DHCP        bool
-	Gateway     string
-	DNS         string
-	userDefined bool
+	Name           string
+	Addrs          []*Addr
+	DHCP           bool
+	Gateway        string
+	DNS            []string  // DNS are the ipv4 DNS servers
+	Domains        []string  // Domains are the DNS search domains
+	NTP            []string  // NTP are the NTP servers
+	Gateway6       string    // Gateway6 is the ipv6 default gateway
+	DNS6           []string  // DNS6 are the ipv6 DNS servers
+	IgnoreRA       bool      // IgnoreRA disables the ipv6 router advertisement, accepted by default
+	DHCP6          bool      // DHCP6 enables DHCPv6
+	MACAddress     string    // MACAddress matches the interface by its hardware address
+	Driver         string    // Driver matches the interface by its kernel driver, a glob
+	Path           string    // Path matches the interface by its persistent path, a glob
+	HardwareAddr   string    // HardwareAddr is the hardware address found by Interfaces()
+	Wireless       *Wireless // Wireless is the wireless network the interface connects to
+	userDefined    bool
+	vlans          []string // the VLAN network devices on top of this interface
+	wirelessDevice bool     // the kernel reports the interface as a wireless device
+	dynamicAddrs   []string // the ipv6 addresses assigned by the router advertisement or DHCPv6
 }
 
 // Version used for reading and writing YAML
 type interfaceYAMLMarshal struct {
-	Name    string  `yaml:"name,omitempty"`
-	Addrs   []*Addr `yaml:"addrs,omitempty"`
-	DHCP    string  `yaml:"dhcp,omitempty"`
-	Gateway string  `yaml:"gateway,omitempty"`
-	DNS     string  `yaml:"dns,omitempty"`
+	Name     string    `yaml:"name,omitempty"`
+	Addrs    []*Addr   `yaml:"addrs,omitempty"`
+	DHCP     string    `yaml:"dhcp,omitempty"`
+	Gateway  string    `yaml:"gateway,omitempty"`
+	DNS      dnsList   `yaml:"dns,omitempty,flow"`
+	Domains  []string  `yaml:"domains,omitempty,flow"`
+	NTP      []string  `yaml:"ntp,omitempty,flow"`
+	Gateway6 string    `yaml:"gateway6,omitempty"`
+	DNS6     []string  `yaml:"dns6,omitempty,flow"`
+	AcceptRA string    `yaml:"acceptRA,omitempty"`
+	DHCP6    string    `yaml:"dhcp6,omitempty"`
+	MAC      string    `yaml:"macAddress,omitempty"`
+	Driver   string    `yaml:"driver,omitempty"`
+	Path     string    `yaml:"path,omitempty"`
+	Wireless *Wireless `yaml:"wireless,omitempty"`
 }
 
-// Addr wraps the net' package Addr struct
+// Addr wraps the net' package Addr struct, the ipv6 addresses NetMask is either
+// a prefix length or a mask
 type Addr struct {
 	IP      string
 	NetMask string
@@ -57,12 +85,22 @@ const (
 	configDir = "/etc/systemd/network/"
 
 	versionURLPath = "/usr/share/defaults/swupd/contenturl"
+
+	// ifaPermanent is the flag of the ipv6 addresses not assigned by the router
+	// advertisement or DHCPv6
+	ifaPermanent = 0x80
 )
 
 var (
+	// procNetIfInet6 is where the kernel describes the ipv6 addresses
+	procNetIfInet6 = "/proc/net/if_inet6"
+
 	validIPExp = regexp.MustCompile(`^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(\.{1})){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?){1}$`)
 	gwExp      = regexp.MustCompile(`(default via )(.*)( dev.*)`)
 	dnsExp     = regexp.MustCompile("(nameserver) (.*)")
+	searchExp  = regexp.MustCompile("(search|domain) (.*)")
+	raExp      = regexp.MustCompile(`\sproto ra(\s|$)`)
+	domainExp  = regexp.MustCompile(`^([0-9A-Za-z]([0-9A-Za-z-]{0,61}[0-9A-Za-z])?\.)*[0-9A-Za-z]([0-9A-Za-z-]{0,61}[0-9A-Za-z])?\.?$`)
 )
 
 // IsUserDefined returns true if the configuration was interactively
@@ -80,10 +118,61 @@ func (i *Interface) MarshalYAML() (interface{}, error) {
 	im.DHCP = strconv.FormatBool(i.DHCP)
 	im.Gateway = i.Gateway
 	im.DNS = i.DNS
+	im.Domains = i.Domains
+	im.NTP = i.NTP
+	im.Gateway6 = i.Gateway6
+	im.DNS6 = i.DNS6
+	im.MAC = i.MACAddress
+	im.Driver = i.Driver
+	im.Path = i.Path
+	im.Wireless = i.Wireless
+
+	if i.IgnoreRA {
+		im.AcceptRA = "false"
+	}
+
+	if i.DHCP6 {
+		im.DHCP6 = strconv.FormatBool(i.DHCP6)
+	}
 
 	return im, nil
 }
 
+// parseBool parses the YAML representation of a boolean option, an empty str is false
+func parseBool(str string) (bool, error) {
+	if str == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(str)
+}
+
+// dnsList is the YAML representation of the DNS servers, a list or a scalar of
+// comma separated servers as the previous descriptors have a single server
+type dnsList []string
+
+// UnmarshalYAML unmarshals dnsList from YAML format
+func (dl *dnsList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+
+	if err := unmarshal(&list); err == nil {
+		*dl = list
+		return nil
+	}
+
+	var str string
+
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+
+	*dl = strings.FieldsFunc(str, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	return nil
+}
+
 // UnmarshalYAML unmarshals Interface from YAML format
 func (i *Interface) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var im interfaceYAMLMarshal
@@ -96,15 +185,35 @@ func (i *Interface) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	i.Addrs = im.Addrs
 	i.Gateway = im.Gateway
 	i.DNS = im.DNS
+	i.Domains = im.Domains
+	i.NTP = im.NTP
+	i.Gateway6 = im.Gateway6
+	i.DNS6 = im.DNS6
+	i.MACAddress = im.MAC
+	i.Driver = im.Driver
+	i.Path = im.Path
+	i.Wireless = im.Wireless
 	i.userDefined = false
 
+	var err error
+
 	if im.DHCP != "" {
-		dhcp, err := strconv.ParseBool(im.DHCP)
-		if err != nil {
+		if i.DHCP, err = strconv.ParseBool(im.DHCP); err != nil {
 			return err
 		}
+	}
 
-		i.DHCP = dhcp
+	// the router advertisement is accepted unless explicitly disabled
+	acceptRA := true
+	if im.AcceptRA != "" {
+		if acceptRA, err = strconv.ParseBool(im.AcceptRA); err != nil {
+			return err
+		}
+	}
+	i.IgnoreRA = !acceptRA
+
+	if i.DHCP6, err = parseBool(im.DHCP6); err != nil {
+		return err
 	}
 
 	return nil
@@ -126,6 +235,130 @@ func (i *Interface) HasIPv4Addr() bool {
 	return false
 }
 
+// HasMatch returns true if the interface is matched by its MAC address, driver or
+// path rather than by its name
+func (i *Interface) HasMatch() bool {
+	return i.MACAddress != "" || i.Driver != "" || i.Path != ""
+}
+
+// Validate checks the interface match criteria, its ipv6 settings and its name
+// servers settings
+func (i *Interface) Validate() error {
+	if i.MACAddress != "" {
+		if _, err := net.ParseMAC(i.MACAddress); err != nil {
+			return errors.Errorf("%s: invalid MAC address: %s", i.Name, i.MACAddress)
+		}
+	}
+
+	for _, curr := range i.Addrs {
+		if curr.Version != IPv6 {
+			continue
+		}
+
+		if IsValidIPv6(curr.IP) != "" {
+			return errors.Errorf("%s: invalid ipv6 address: %s", i.Name, curr.IP)
+		}
+
+		if IsValidIPv6Prefix(curr.NetMask) != "" {
+			return errors.Errorf("%s: invalid ipv6 prefix: %s", i.Name, curr.NetMask)
+		}
+	}
+
+	if i.Gateway6 != "" && IsValidIPv6(i.Gateway6) != "" {
+		return errors.Errorf("%s: invalid ipv6 gateway: %s", i.Name, i.Gateway6)
+	}
+
+	for _, curr := range i.DNS6 {
+		if IsValidIPv6(curr) != "" {
+			return errors.Errorf("%s: invalid ipv6 DNS server: %s", i.Name, curr)
+		}
+	}
+
+	for _, curr := range i.DNS {
+		if IsValidIP(curr) != "" {
+			return errors.Errorf("%s: invalid DNS server: %s", i.Name, curr)
+		}
+	}
+
+	for _, curr := range i.Domains {
+		if IsValidDomain(curr) != "" {
+			return errors.Errorf("%s: invalid search domain: %s", i.Name, curr)
+		}
+	}
+
+	for _, curr := range i.NTP {
+		if IsValidNTPServer(curr) != "" {
+			return errors.Errorf("%s: invalid NTP server: %s", i.Name, curr)
+		}
+	}
+
+	if i.Wireless != nil {
+		if err := i.Wireless.Validate(); err != nil {
+			return errors.Errorf("%s: %v", i.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// HasIPv6Config returns true if any ipv6 setting is configured: a static address,
+// gateway or DNS server, the router advertisement or DHCPv6
+func (i *Interface) HasIPv6Config() bool {
+	if i.Gateway6 != "" || len(i.DNS6) > 0 || i.IgnoreRA || i.DHCP6 {
+		return true
+	}
+
+	return len(i.StaticIPv6Addrs()) > 0
+}
+
+// StaticIPv6Addrs returns the ipv6 addresses to be statically configured, the link
+// local addresses are managed by the kernel and skipped as well as the addresses
+// assigned by the router advertisement or DHCPv6
+func (i *Interface) StaticIPv6Addrs() []*Addr {
+	res := []*Addr{}
+
+	for _, curr := range i.Addrs {
+		if curr.Version != IPv6 || utils.StringSliceContains(i.dynamicAddrs, curr.IP) {
+			continue
+		}
+
+		if ip := net.ParseIP(curr.IP); ip != nil && ip.IsLinkLocalUnicast() {
+			continue
+		}
+
+		res = append(res, curr)
+	}
+
+	return res
+}
+
+// PrefixLength returns the addr's network prefix length
+func (a *Addr) PrefixLength() (int, error) {
+	if a.Version == IPv4 {
+		return netMaskToCIDR(a.NetMask)
+	}
+
+	if plen, err := strconv.Atoi(a.NetMask); err == nil {
+		if plen < 0 || plen > 128 {
+			return 0, errors.Errorf("Invalid ipv6 prefix length: %s", a.NetMask)
+		}
+
+		return plen, nil
+	}
+
+	mask := net.ParseIP(a.NetMask)
+	if mask == nil || mask.To4() != nil {
+		return 0, errors.Errorf("Invalid ipv6 mask: %s", a.NetMask)
+	}
+
+	plen, bits := net.IPMask(mask.To16()).Size()
+	if bits == 0 {
+		return 0, errors.Errorf("Invalid ipv6 mask: %s", a.NetMask)
+	}
+
+	return plen, nil
+}
+
 // VersionString returns a string representation for a given addr version (ipv4/ipv6)
 func (a *Addr) VersionString() string {
 	if a.Version == IPv4 {
@@ -155,24 +388,105 @@ func Gateway() (string, error) {
 	return strings.TrimSpace(gwExp.ReplaceAllString(result, `$2`)), nil
 }
 
-// DNSServer returns the current configured resolver address
-func DNSServer() (string, error) {
+// Gateway6 return the current ipv6 default gateway addr
+func Gateway6() (string, error) {
+	w := bytes.NewBuffer(nil)
+	err := cmd.Run(w, "ip", "-6", "route", "show", "default")
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	for _, result := range strings.Split(w.String(), "\n") {
+		// the routes learned from the router advertisement are not configured
+		if result == "" || raExp.MatchString(result) {
+			continue
+		}
+
+		if !gwExp.MatchString(result) {
+			return "", errors.Errorf("Could not parse ipv6 gateway configuration")
+		}
+
+		return strings.TrimSpace(gwExp.ReplaceAllString(result, `$2`)), nil
+	}
+
+	return "", nil
+}
+
+// dynamicIPv6Addrs returns the ipv6 addresses assigned by the router advertisement or
+// DHCPv6, as reported by the kernel in procNetIfInet6
+func dynamicIPv6Addrs() map[string]bool {
+	res := map[string]bool{}
+
+	content, err := ioutil.ReadFile(procNetIfInet6)
+	if err != nil {
+		log.Debug("Could not read %s: %v", procNetIfInet6, err)
+		return res
+	}
+
+	// i.e: 20010db8000000000000000000000010 02 40 00 00 eth0
+	for _, line := range strings.Split(string(content), "\n") {
+		tks := strings.Fields(line)
+		if len(tks) != 6 {
+			continue
+		}
+
+		ip, err := hex.DecodeString(tks[0])
+		if err != nil || len(ip) != net.IPv6len {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(tks[4], 16, 32)
+		if err != nil || flags&ifaPermanent != 0 {
+			continue
+		}
+
+		res[net.IP(ip).String()] = true
+	}
+
+	return res
+}
+
+// resolvConf returns the values of the /etc/resolv.conf lines matching exp
+func resolvConf(exp *regexp.Regexp) ([]string, error) {
 	var buff []byte
 	var err error
 
 	if buff, err = ioutil.ReadFile("/etc/resolv.conf"); err != nil {
-		return "", errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
+	res := []string{}
 	for _, line := range strings.Split(string(buff), "\n") {
-		if !dnsExp.MatchString(line) {
+		if !exp.MatchString(line) {
 			continue
 		}
 
-		return strings.TrimSpace(dnsExp.ReplaceAllString(line, `$2`)), nil
+		res = append(res, strings.Fields(exp.ReplaceAllString(line, `$2`))...)
 	}
 
-	return "", nil
+	return res, nil
+}
+
+// DNSServers returns the current configured ipv4 resolvers addresses
+func DNSServers() ([]string, error) {
+	servers, err := resolvConf(dnsExp)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+	for _, curr := range servers {
+		if IsValidIP(curr) == "" {
+			res = append(res, curr)
+		}
+	}
+
+	return res, nil
+}
+
+// SearchDomains returns the current configured DNS search domains
+func SearchDomains() ([]string, error) {
+	return resolvConf(searchExp)
 }
 
 func isDHCP(iface string) (bool, error) {
@@ -201,12 +515,15 @@ func Interfaces() ([]*Interface, error) {
 		return nil, errors.Wrap(err)
 	}
 
+	dynamic := dynamicIPv6Addrs()
+
 	for _, curr := range ifaces {
 		if curr.Flags&net.FlagLoopback == net.FlagLoopback {
 			continue
 		}
 
-		iface := &Interface{Name: curr.Name, Addrs: []*Addr{}}
+		iface := &Interface{Name: curr.Name, Addrs: []*Addr{}, HardwareAddr: curr.HardwareAddr.String(),
+			wirelessDevice: isWirelessDevice(curr.Name)}
 		result = append(result, iface)
 
 		addrs, err := curr.Addrs()
@@ -227,6 +544,10 @@ func Interfaces() ([]*Interface, error) {
 
 			if ip.To4() == nil {
 				addr.Version = IPv6
+
+				if dynamic[addr.IP] {
+					iface.dynamicAddrs = append(iface.dynamicAddrs, addr.IP)
+				}
 			}
 
 			iface.Addrs = append(iface.Addrs, addr)
@@ -242,7 +563,17 @@ func Interfaces() ([]*Interface, error) {
 			return nil, err
 		}
 
-		iface.DNS, err = DNSServer()
+		// ipv6 may be disabled, not finding its gateway is not an error
+		if iface.Gateway6, err = Gateway6(); err != nil {
+			log.Debug("Could not query the ipv6 gateway: %v", err)
+		}
+
+		iface.DNS, err = DNSServers()
+		if err != nil {
+			return nil, err
+		}
+
+		iface.Domains, err = SearchDomains()
 		if err != nil {
 			return nil, err
 		}
@@ -279,42 +610,134 @@ func netMaskToCIDR(mask string) (num int, err error) {
 	return bits, nil
 }
 
+// dhcpMode returns the networkd DHCP= value for the enabled DHCP versions
+func (i *Interface) dhcpMode() string {
+	if i.DHCP && i.DHCP6 {
+		return "yes"
+	} else if i.DHCP {
+		return "ipv4"
+	} else if i.DHCP6 {
+		return "ipv6"
+	}
+
+	return ""
+}
+
 func (i *Interface) applyStatic(root string, file *os.File) error {
 	config := `[Match]
+{{- if .MACAddress}}
+MACAddress={{.MACAddress}}
+{{- end}}
+{{- if .Driver}}
+Driver={{.Driver}}
+{{- end}}
+{{- if .Path}}
+Path={{.Path}}
+{{- end}}
+{{- if not .HasMatch}}
 Name={{.Name}}
+{{- end}}
 
 [Network]
-DNS={{.DNS}}
-Address={{.Address}}
-Gateway={{.Gateway}}
+{{- if .DHCP}}
+DHCP={{.DHCP}}
+{{- end}}
+{{- range .DNS}}
+DNS={{.}}
+{{- end}}
+{{- if .Domains}}
+Domains={{join .Domains " "}}
+{{- end}}
+{{- range .NTP}}
+NTP={{.}}
+{{- end}}
+{{- range .Addresses}}
+Address={{.}}
+{{- end}}
+{{- range .Gateways}}
+Gateway={{.}}
+{{- end}}
+{{- range .VLANs}}
+VLAN={{.}}
+{{- end}}
+{{- if .IPv6}}
+IPv6AcceptRA={{if .IgnoreRA}}no{{else}}yes{{end}}
+{{- end}}
 `
 
-	var address string
+	addresses := []string{}
+	gateways := []string{}
+	dns := []string{}
 
-	for _, curr := range i.Addrs {
-		if curr.Version != IPv4 {
-			continue
+	// the ipv4 settings are only static if not set by dhcp
+	if !i.DHCP {
+		for _, curr := range i.Addrs {
+			if curr.Version != IPv4 {
+				continue
+			}
+
+			cidrd, err := curr.PrefixLength()
+			if err != nil {
+				return err
+			}
+
+			addresses = append(addresses, fmt.Sprintf("%s/%d", curr.IP, cidrd))
 		}
 
-		cidrd, err := netMaskToCIDR(curr.NetMask)
+		dns = append(dns, i.DNS...)
+
+		if i.Gateway != "" {
+			gateways = append(gateways, i.Gateway)
+		}
+	}
+
+	for _, curr := range i.StaticIPv6Addrs() {
+		plen, err := curr.PrefixLength()
 		if err != nil {
 			return err
 		}
 
-		address = fmt.Sprintf("%s/%d", curr.IP, cidrd)
+		addresses = append(addresses, fmt.Sprintf("%s/%d", curr.IP, plen))
+	}
+
+	dns = append(dns, i.DNS6...)
+
+	if i.Gateway6 != "" {
+		gateways = append(gateways, i.Gateway6)
 	}
 
-	template := template.Must(template.New("").Parse(config))
+	funcs := template.FuncMap{"join": strings.Join}
+	template := template.Must(template.New("").Funcs(funcs).Parse(config))
 	err := template.Execute(file, struct {
-		Name    string
-		DNS     string
-		Address string
-		Gateway string
+		Name       string
+		HasMatch   bool
+		MACAddress string
+		Driver     string
+		Path       string
+		DHCP       string
+		DNS        []string
+		Domains    []string
+		NTP        []string
+		Addresses  []string
+		Gateways   []string
+		VLANs      []string
+		IPv6       bool
+		IgnoreRA   bool
 	}{
-		Name:    i.Name,
-		DNS:     i.DNS,
-		Gateway: i.Gateway,
-		Address: address,
+		Name:       i.Name,
+		HasMatch:   i.HasMatch(),
+		MACAddress: i.MACAddress,
+		Driver:     i.Driver,
+		Path:       i.Path,
+		DHCP:       i.dhcpMode(),
+		DNS:        dns,
+		Domains:    i.Domains,
+		NTP:        i.NTP,
+		Addresses:  addresses,
+		Gateways:   gateways,
+		VLANs:      i.vlans,
+		IPv6:       i.HasIPv6Config(),
+		IgnoreRA:   i.IgnoreRA,
 	})
 
 	if err != nil {
@@ -324,12 +747,20 @@ Gateway={{.Gateway}}
 	return nil
 }
 
+// usesDefaultConfig returns true if the interface only needs the default dhcp config
+func (i *Interface) usesDefaultConfig() bool {
+	return i.DHCP && !i.HasIPv6Config() && len(i.vlans) == 0 && len(i.Domains) == 0 &&
+		len(i.NTP) == 0 && !i.IsWireless()
+}
+
 // Apply does apply the interface configuration to the running system
 func (i *Interface) Apply(root string) error {
 	fileName := fmt.Sprintf("10-%s.network", i.Name)
 	filePath := filepath.Join(root, configDir, fileName)
 
-	if i.DHCP {
+	// the dhcp only interfaces are handled by the default networkd configuration, it
+	// doesn't match the wireless interfaces
+	if i.usesDefaultConfig() {
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
 			return nil
 		}
@@ -345,37 +776,49 @@ func (i *Interface) Apply(root string) error {
 	if err != nil {
 		return errors.Wrap(err)
 	}
+	defer func() { _ = f.Close() }()
 
 	return i.applyStatic(root, f)
 }
 
-// Apply does apply the configurations of a set of interfaces to the running system
-func Apply(root string, ifaces []*Interface) error {
-	if root == "" {
-		return errors.Errorf("Could not apply network settings, Invalid root directory: %s", root)
-	}
+// ApplyTimesync writes the NTP servers of all the ifaces to the timesyncd.conf of the
+// system at root, they're the fallback of the servers networkd hands to timesyncd
+// when the interfaces come up. Nothing is written if no NTP server is configured.
+func ApplyTimesync(root string, ifaces []*Interface) error {
+	servers := []string{}
 
-	if _, err := os.Stat(configDir); os.IsNotExist(err) {
-		if err = os.MkdirAll(configDir, 0777); err != nil {
-			return errors.Wrap(err)
+	for _, iface := range ifaces {
+		for _, curr := range iface.NTP {
+			if !utils.StringSliceContains(servers, curr) {
+				servers = append(servers, curr)
+			}
 		}
 	}
 
-	for _, curr := range ifaces {
-		if !curr.IsUserDefined() {
-			log.Info("Interface %s was not changed, skipping config apply.")
-			continue
-		}
+	if len(servers) == 0 {
+		return nil
+	}
 
-		err := curr.Apply(root)
-		if err != nil {
-			return err
-		}
+	dir := filepath.Join(root, "etc", "systemd")
+	if err := utils.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("[Time]\nNTP=%s\n", strings.Join(servers, " "))
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "timesyncd.conf"), []byte(content), 0644); err != nil {
+		return errors.Wrap(err)
 	}
 
 	return nil
 }
 
+// Apply does apply the configurations of a set of interfaces to the system at root,
+// i.e "/" for the running system or the target's root directory
+func Apply(root string, ifaces []*Interface) error {
+	return ApplyConfig(root, ifaces, nil)
+}
+
 // Restart restarts the network services
 func Restart() error {
 	err := cmd.RunAndLog("systemctl", "restart", "systemd-networkd", "systemd-resolved")
@@ -386,18 +829,6 @@ func Restart() error {
 	return nil
 }
 
-// VerifyConnectivity tests if the network configuration is working
-func VerifyConnectivity() error {
-	var versionURL []byte
-	var err error
-
-	if versionURL, err = ioutil.ReadFile(versionURLPath); err != nil {
-		return errors.Errorf("Read version file %s: %v", versionURLPath, err)
-	}
-
-	return CheckURL(string(versionURL))
-}
-
 // CheckURL tests if the given URL is accessible
 func CheckURL(url string) error {
 	args := []string{
@@ -429,3 +860,43 @@ func IsValidIP(str string) string {
 
 	return ""
 }
+
+// IsValidDomain returns empty string if str is a valid domain name
+func IsValidDomain(str string) string {
+	if len(str) > 253 || !domainExp.MatchString(str) {
+		return "Invalid"
+	}
+
+	return ""
+}
+
+// IsValidNTPServer returns empty string if str is a valid NTP server, either an
+// address or a host name
+func IsValidNTPServer(str string) string {
+	if IsValidIP(str) == "" || IsValidIPv6(str) == "" {
+		return ""
+	}
+
+	return IsValidDomain(str)
+}
+
+// IsValidIPv6 returns empty string if str is a valid ipv6 address
+func IsValidIPv6(str string) string {
+	ip := net.ParseIP(str)
+	if ip == nil || ip.To4() != nil || !strings.Contains(str, ":") {
+		return "Invalid"
+	}
+
+	return ""
+}
+
+// IsValidIPv6Prefix returns empty string if str is a valid ipv6 prefix length
+func IsValidIPv6Prefix(str string) string {
+	addr := &Addr{NetMask: str, Version: IPv6}
+
+	if _, err := addr.PrefixLength(); err != nil {
+		return "Invalid"
+	}
+
+	return ""
+}
@@ -8,8 +8,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/utils"
 )
 
@@ -210,7 +215,7 @@ func TestApply(t *testing.T) {
 		},
 		DHCP:        false,
 		Gateway:     "10.0.0.101",
-		DNS:         "10.0.0.101",
+		DNS:         []string{"10.0.0.101"},
 		userDefined: false,
 	}
 
@@ -245,3 +250,407 @@ func TestHasIPv4Addr(t *testing.T) {
 		t.Fatalf("Interface has no ipv4 but HasIPv4Addr() returned true")
 	}
 }
+
+func TestIPv6Address(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{"2001:db8::1", ""},
+		{"fe80::1", ""},
+		{"::", ""},
+		{"10.0.0.1", "Invalid"},
+		{"::ffff:10.0.0.1", "Invalid"},
+		{"2001:db8::g", "Invalid"},
+	}
+
+	for _, curr := range tests {
+		if msg := IsValidIPv6(curr.addr); msg != curr.expected {
+			t.Fatalf("IsValidIPv6(%q) expected to return %q but returned %q", curr.addr, curr.expected, msg)
+		}
+	}
+
+	prefixes := []struct {
+		mask string
+		plen int
+		err  bool
+	}{
+		{"64", 64, false},
+		{"128", 128, false},
+		{"ffff:ffff:ffff:ffff::", 64, false},
+		{"129", 0, true},
+		{"255.255.255.0", 0, true},
+		{"ffff:0:ffff::", 0, true},
+	}
+
+	for _, curr := range prefixes {
+		addr := &Addr{IP: "2001:db8::1", NetMask: curr.mask, Version: IPv6}
+
+		plen, err := addr.PrefixLength()
+		if curr.err != (err != nil) || plen != curr.plen {
+			t.Fatalf("PrefixLength(%q) returned %d, %v", curr.mask, plen, err)
+		}
+	}
+}
+
+func TestApplyStaticIPv6(t *testing.T) {
+	file, err := ioutil.TempFile("", "clr-installer-network-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	iface := &Interface{
+		Name: "eth0",
+		Addrs: []*Addr{
+			{"10.0.0.5", "255.255.255.0", IPv4},
+			{"fe80::5", "64", IPv6},
+			{"2001:db8::5", "64", IPv6},
+		},
+		DHCP:     true,
+		Gateway:  "10.0.0.1",
+		DNS:      []string{"10.0.0.1"},
+		Gateway6: "2001:db8::1",
+		DNS6:     []string{"2001:db8::53"},
+		DHCP6:    false,
+	}
+
+	if !iface.HasIPv6Config() {
+		t.Fatal("The interface has a static ipv6 config")
+	}
+
+	if err = iface.applyStatic("", file); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[Match]
+Name=eth0
+
+[Network]
+DHCP=ipv4
+DNS=2001:db8::53
+Address=2001:db8::5/64
+Gateway=2001:db8::1
+IPv6AcceptRA=yes
+`
+
+	if string(content) != expected {
+		t.Fatalf("Unexpected network file, expected:\n%s\ngot:\n%s", expected, content)
+	}
+}
+
+func TestIPv6Yaml(t *testing.T) {
+	iface := &Interface{Name: "eth0", DHCP: true, IgnoreRA: true, DHCP6: true,
+		DNS6: []string{"2001:db8::53"}}
+
+	b, err := yaml.Marshal(iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Interface{}
+	if err = yaml.Unmarshal(b, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !loaded.IgnoreRA || !loaded.DHCP6 || len(loaded.DNS6) != 1 || loaded.dhcpMode() != "yes" {
+		t.Fatalf("The ipv6 settings were not loaded: %+v", loaded)
+	}
+
+	// the router advertisement is accepted unless disabled
+	if err = yaml.Unmarshal([]byte("name: eth0\ndhcp: \"true\"\n"), loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.IgnoreRA || loaded.HasIPv6Config() {
+		t.Fatalf("The router advertisement should be accepted by default: %+v", loaded)
+	}
+}
+
+func TestValidateIPv6(t *testing.T) {
+	tests := []struct {
+		iface *Interface
+		valid bool
+	}{
+		{&Interface{Name: "eth0", Addrs: []*Addr{{IP: "2001:db8::5", NetMask: "64", Version: IPv6}},
+			Gateway6: "2001:db8::1", DNS6: []string{"2001:db8::53"}}, true},
+		{&Interface{Name: "eth0", Addrs: []*Addr{{IP: "2001:db8::5", NetMask: "ffff:ffff:ffff:ffff::",
+			Version: IPv6}}}, true},
+		{&Interface{Name: "eth0", Addrs: []*Addr{{IP: "10.0.0.5", NetMask: "64", Version: IPv6}}}, false},
+		{&Interface{Name: "eth0", Addrs: []*Addr{{IP: "2001:db8::5", NetMask: "129", Version: IPv6}}}, false},
+		{&Interface{Name: "eth0", Gateway6: "2001:db8::zz"}, false},
+		{&Interface{Name: "eth0", DNS6: []string{"10.0.0.53"}}, false},
+	}
+
+	for _, curr := range tests {
+		err := curr.iface.Validate()
+
+		if curr.valid && err != nil {
+			t.Fatalf("%+v should be valid: %v", curr.iface, err)
+		}
+
+		if !curr.valid && err == nil {
+			t.Fatalf("%+v should be invalid", curr.iface)
+		}
+	}
+}
+
+func TestDynamicIPv6Addrs(t *testing.T) {
+	file, err := ioutil.TempFile("", "if_inet6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	content := "20010db8000000000000000000000005 02 40 00 80     eth0\n" +
+		"20010db80000000002163efffe000001 02 40 00 00     eth0\n" +
+		"fe800000000000000216 02 40 20 80     eth0\n"
+	if _, err = file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	saved := procNetIfInet6
+	defer func() { procNetIfInet6 = saved }()
+	procNetIfInet6 = file.Name()
+
+	dynamic := dynamicIPv6Addrs()
+	if len(dynamic) != 1 || !dynamic["2001:db8::216:3eff:fe00:1"] {
+		t.Fatalf("Only the autoconfigured address is dynamic: %v", dynamic)
+	}
+
+	iface := &Interface{
+		Name: "eth0",
+		Addrs: []*Addr{
+			{IP: "2001:db8::5", NetMask: "64", Version: IPv6},
+			{IP: "2001:db8::216:3eff:fe00:1", NetMask: "64", Version: IPv6},
+		},
+		dynamicAddrs: []string{"2001:db8::216:3eff:fe00:1"},
+	}
+
+	if addrs := iface.StaticIPv6Addrs(); len(addrs) != 1 || addrs[0].IP != "2001:db8::5" {
+		t.Fatalf("The autoconfigured address should not be static: %+v", addrs)
+	}
+
+	rec := cmd.NewRecorder()
+	rec.Script("default via fe80::1 dev eth0 proto ra metric 1024 expires 1798sec pref medium\n",
+		nil, "ip", "-6", "route")
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	if gw, err := Gateway6(); err != nil || gw != "" {
+		t.Fatalf("The router advertisement gateway is not configured: %q, %v", gw, err)
+	}
+
+	rec.Script("default via fe80::1 dev eth0 proto ra metric 1024\ndefault via 2001:db8::1 dev eth0 metric 1024\n",
+		nil, "ip", "-6", "route")
+
+	if gw, err := Gateway6(); err != nil || gw != "2001:db8::1" {
+		t.Fatalf("Unexpected static gateway: %q, %v", gw, err)
+	}
+}
+
+func TestApplyTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ifaces := []*Interface{
+		{
+			Name:    "eth0",
+			Addrs:   []*Addr{{"10.0.0.5", "255.255.255.0", IPv4}},
+			Gateway: "10.0.0.1",
+			DNS:     []string{"10.0.0.1"},
+		},
+		{Name: "eth1", DHCP: true},
+	}
+
+	// the target's network directory is created
+	if err = Apply(dir, ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, configDir, "10-eth0.network"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), "Address=10.0.0.5/24") {
+		t.Fatalf("Unexpected eth0 network file: %s", content)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, configDir, "10-eth1.network")); !os.IsNotExist(err) {
+		t.Fatal("The dhcp interface should be left to the default configuration")
+	}
+}
+
+func TestApplyMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	iface := &Interface{
+		Name:       "lan",
+		Addrs:      []*Addr{{"10.0.0.5", "255.255.255.0", IPv4}},
+		MACAddress: "52:54:00:12:34:56",
+		Driver:     "e1000*",
+	}
+
+	if err = iface.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Apply(dir, []*Interface{iface}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, configDir, "10-lan.network"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[Match]\nMACAddress=52:54:00:12:34:56\nDriver=e1000*\n\n[Network]\n"
+	if !strings.HasPrefix(string(content), expected) {
+		t.Fatalf("Unexpected network file, expected prefix:\n%s\ngot:\n%s", expected, content)
+	}
+
+	iface.MACAddress = "52:54:00:12"
+	if err = iface.Validate(); err == nil {
+		t.Fatal("Should fail to validate an invalid MAC address")
+	}
+}
+
+func TestMatchYaml(t *testing.T) {
+	iface := &Interface{Name: "lan", MACAddress: "52:54:00:12:34:56", Path: "pci-0000:00:03.0"}
+
+	b, err := yaml.Marshal(iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Interface{}
+	if err = yaml.Unmarshal(b, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.MACAddress != iface.MACAddress || loaded.Path != iface.Path || loaded.Driver != "" {
+		t.Fatalf("The match criteria were not loaded: %+v", loaded)
+	}
+}
+
+func TestNameServersYaml(t *testing.T) {
+	content := `name: eth0
+dns: 10.0.0.1, 10.0.0.2
+domains: [corp.example.com, example.com]
+ntp: [ntp.example.com, 10.0.0.123]
+`
+	iface := &Interface{}
+	if err := yaml.Unmarshal([]byte(content), iface); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(iface.DNS, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("The scalar dns list was not loaded: %v", iface.DNS)
+	}
+
+	if err := iface.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := yaml.Marshal(iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Interface{}
+	if err = yaml.Unmarshal(b, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded.DNS, iface.DNS) || !reflect.DeepEqual(loaded.Domains, iface.Domains) ||
+		!reflect.DeepEqual(loaded.NTP, iface.NTP) {
+		t.Fatalf("The name servers were not loaded: %+v", loaded)
+	}
+
+	iface.Domains = []string{"-corp.example.com"}
+	if err = iface.Validate(); err == nil {
+		t.Fatal("Should fail to validate an invalid search domain")
+	}
+}
+
+func TestApplyNameServers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ifaces := []*Interface{
+		{
+			Name:    "eth0",
+			Addrs:   []*Addr{{"10.0.0.5", "255.255.255.0", IPv4}},
+			Gateway: "10.0.0.1",
+			DNS:     []string{"10.0.0.1", "10.0.0.2"},
+			Domains: []string{"corp.example.com", "example.com"},
+			NTP:     []string{"ntp.example.com"},
+		},
+		{Name: "eth1", DHCP: true, NTP: []string{"ntp.example.com", "10.0.0.123"}},
+	}
+
+	if err = Apply(dir, ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ApplyTimesync(dir, ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, configDir, "10-eth0.network"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[Match]
+Name=eth0
+
+[Network]
+DNS=10.0.0.1
+DNS=10.0.0.2
+Domains=corp.example.com example.com
+NTP=ntp.example.com
+Address=10.0.0.5/24
+Gateway=10.0.0.1
+`
+
+	if string(content) != expected {
+		t.Fatalf("Unexpected network file, expected:\n%s\ngot:\n%s", expected, content)
+	}
+
+	// the dhcp interface has a file for its NTP servers
+	content, err = ioutil.ReadFile(filepath.Join(dir, configDir, "10-eth1.network"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), "DHCP=ipv4\nNTP=ntp.example.com\nNTP=10.0.0.123\n") {
+		t.Fatalf("Unexpected eth1 network file: %s", content)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(dir, "etc", "systemd", "timesyncd.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "[Time]\nNTP=ntp.example.com 10.0.0.123\n" {
+		t.Fatalf("Unexpected timesyncd.conf: %s", content)
+	}
+}
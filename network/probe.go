@@ -0,0 +1,166 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// ConnectivityCheck describes how the installer verifies the network is working: the
+// URLs probed, how many times a failed probe is retried and how long to wait between
+// the attempts, the delay is multiplied by the backoff after every failed attempt
+type ConnectivityCheck struct {
+	Skip     bool     `yaml:"skip,omitempty"`
+	URLs     []string `yaml:"urls,omitempty,flow"`
+	Attempts int      `yaml:"attempts,omitempty"`
+	Delay    int      `yaml:"delay,omitempty"`
+	Backoff  float64  `yaml:"backoff,omitempty"`
+	Timeout  int      `yaml:"timeout,omitempty"`
+}
+
+const (
+	// the defaults match the installer's historical check: 3 attempts, 2 seconds apart
+	defaultProbeAttempts = 3
+	defaultProbeDelay    = 2
+	defaultProbeBackoff  = 1
+	defaultProbeTimeout  = 10
+
+	// maxProbeDelay caps the delay between the attempts
+	maxProbeDelay = 60 * time.Second
+)
+
+var (
+	// the curl exit codes of the common connectivity failures
+	curlErrors = map[int]string{
+		5:  "could not resolve the proxy",
+		6:  "could not resolve the host",
+		7:  "could not connect to the host",
+		22: "the server returned an error",
+		28: "timed out",
+		35: "the SSL handshake failed",
+		47: "too many redirects",
+		51: "the server certificate is invalid",
+		56: "the connection was reset",
+		60: "the server certificate could not be verified",
+	}
+)
+
+// Validate checks the connectivity check settings
+func (cc *ConnectivityCheck) Validate() error {
+	if cc.Attempts < 0 || cc.Delay < 0 || cc.Timeout < 0 {
+		return errors.ValidationErrorf("The connectivity check attempts, delay and timeout can't be negative")
+	}
+
+	if cc.Backoff != 0 && cc.Backoff < 1 {
+		return errors.ValidationErrorf("The connectivity check backoff must be at least 1: %v", cc.Backoff)
+	}
+
+	for _, curr := range cc.URLs {
+		if !strings.HasPrefix(curr, "http://") && !strings.HasPrefix(curr, "https://") {
+			return errors.ValidationErrorf("Invalid connectivity check URL: %s", curr)
+		}
+	}
+
+	return nil
+}
+
+// GetAttempts returns the number of times a probe is tried
+func (cc *ConnectivityCheck) GetAttempts() int {
+	if cc.Attempts == 0 {
+		return defaultProbeAttempts
+	}
+
+	return cc.Attempts
+}
+
+// RetryDelay returns how long to wait before the attempt, starting at 1
+func (cc *ConnectivityCheck) RetryDelay(attempt int) time.Duration {
+	delay := float64(cc.Delay)
+	if cc.Delay == 0 {
+		delay = defaultProbeDelay
+	}
+
+	backoff := cc.Backoff
+	if backoff == 0 {
+		backoff = defaultProbeBackoff
+	}
+
+	res := time.Duration(delay * math.Pow(backoff, float64(attempt-1)) * float64(time.Second))
+	if res > maxProbeDelay {
+		return maxProbeDelay
+	}
+
+	return res
+}
+
+// Targets returns the URLs to probe: the configured URLs and the swupd mirror. If
+// none is configured the swupd content URL of the installer system is probed.
+func (cc *ConnectivityCheck) Targets(mirror string) ([]string, error) {
+	res := append([]string{}, cc.URLs...)
+
+	if mirror != "" && !utils.StringSliceContains(res, mirror) {
+		res = append(res, mirror)
+	}
+
+	if len(res) > 0 {
+		return res, nil
+	}
+
+	content, err := ioutil.ReadFile(versionURLPath)
+	if err != nil {
+		return nil, errors.Errorf("Read version file %s: %v", versionURLPath, err)
+	}
+
+	return []string{strings.TrimSpace(string(content))}, nil
+}
+
+// Probe returns empty string if url is accessible, otherwise the reason it's not
+func (cc *ConnectivityCheck) Probe(url string) string {
+	timeout := cc.Timeout
+	if timeout == 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	args := []string{
+		"curl",
+		"--no-sessionid",
+		"--max-time",
+		fmt.Sprintf("%d", timeout),
+		"-o",
+		"/dev/null",
+		"-s",
+		"-f",
+		url,
+	}
+
+	if err := cmd.Run(nil, args...); err != nil {
+		return probeFailure(err)
+	}
+
+	return ""
+}
+
+// probeFailure returns the reason of a curl failure
+func probeFailure(err error) string {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if reason, ok := curlErrors[status.ExitStatus()]; ok {
+				return reason
+			}
+		}
+	}
+
+	return err.Error()
+}
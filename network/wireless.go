@@ -0,0 +1,352 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// A Wireless describes the network a wireless interface connects to, the network is
+// either open, protected by a pre-shared key or by 802.1X
+type Wireless struct {
+	SSID   string `yaml:"ssid"`
+	PSK    string `yaml:"psk,omitempty"`
+	Hidden bool   `yaml:"hidden,omitempty"`
+	EAP    *EAP   `yaml:"eap,omitempty"`
+}
+
+// An EAP is the 802.1X authentication of a wireless network
+type EAP struct {
+	Method             string `yaml:"method"`
+	Identity           string `yaml:"identity"`
+	Password           string `yaml:"password,omitempty"`
+	Phase2             string `yaml:"phase2,omitempty"`
+	CACert             string `yaml:"caCert,omitempty"`
+	ClientCert         string `yaml:"clientCert,omitempty"`
+	PrivateKey         string `yaml:"privateKey,omitempty"`
+	PrivateKeyPassword string `yaml:"privateKeyPassword,omitempty"`
+}
+
+const (
+	// WirelessRequiredBundle is the bundle providing wpa_supplicant to the target
+	WirelessRequiredBundle = "network-basic"
+
+	// wpaSupplicantDir is the wpa_supplicant configuration directory
+	wpaSupplicantDir = "/etc/wpa_supplicant"
+)
+
+var (
+	// sysClassNet is where the kernel describes the network interfaces
+	sysClassNet = "/sys/class/net"
+
+	// the EAP methods and the phase 2 authentications supported by wpa_supplicant
+	eapMethods    = []string{"peap", "ttls", "tls"}
+	eapPhase2Auth = []string{"mschapv2", "md5", "gtc", "pap", "chap", "mschap"}
+
+	hexPSKExp = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+
+	wpaSupplicantTemplate = template.Must(template.New("").Funcs(template.FuncMap{
+		"upper": strings.ToUpper,
+	}).Parse(`ctrl_interface=/run/wpa_supplicant
+
+network={
+	ssid={{printf "%x" .SSID}}
+{{- if .Hidden}}
+	scan_ssid=1
+{{- end}}
+{{- if .EAP}}
+	key_mgmt=WPA-EAP
+	eap={{upper .EAP.Method}}
+	identity="{{.EAP.Identity}}"
+{{- if .EAP.Password}}
+	password="{{.EAP.Password}}"
+{{- end}}
+{{- if .EAP.Phase2}}
+	phase2="auth={{upper .EAP.Phase2}}"
+{{- end}}
+{{- if .EAP.CACert}}
+	ca_cert="{{.EAP.CACert}}"
+{{- end}}
+{{- if .EAP.ClientCert}}
+	client_cert="{{.EAP.ClientCert}}"
+{{- end}}
+{{- if .EAP.PrivateKey}}
+	private_key="{{.EAP.PrivateKey}}"
+{{- end}}
+{{- if .EAP.PrivateKeyPassword}}
+	private_key_passwd="{{.EAP.PrivateKeyPassword}}"
+{{- end}}
+{{- else if .HexPSK}}
+	key_mgmt=WPA-PSK
+	psk={{.PSK}}
+{{- else if .PSK}}
+	key_mgmt=WPA-PSK
+	psk="{{.PSK}}"
+{{- else}}
+	key_mgmt=NONE
+{{- end}}
+}
+`))
+
+	linkTemplate = template.Must(template.New("").Parse(`[Match]
+{{- if .MACAddress}}
+MACAddress={{.MACAddress}}
+{{- end}}
+{{- if .Driver}}
+Driver={{.Driver}}
+{{- end}}
+{{- if .Path}}
+Path={{.Path}}
+{{- end}}
+
+[Link]
+Name={{.Name}}
+`))
+)
+
+// isWirelessDevice returns true if the kernel reports name as a wireless device
+func isWirelessDevice(name string) bool {
+	_, err := os.Stat(filepath.Join(sysClassNet, name, "wireless"))
+	return err == nil
+}
+
+// IsWireless returns true if the interface is a wireless device or is configured to
+// connect to a wireless network
+func (i *Interface) IsWireless() bool {
+	return i.wirelessDevice || i.Wireless != nil
+}
+
+// HasWireless returns true if any of ifaces connects to a wireless network
+func HasWireless(ifaces []*Interface) bool {
+	for _, curr := range ifaces {
+		if curr.Wireless != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks the wireless network settings
+func (w *Wireless) Validate() error {
+	if msg := IsValidSSID(w.SSID); msg != "" {
+		return errors.Errorf("Invalid SSID %q: %s", w.SSID, msg)
+	}
+
+	if w.PSK != "" {
+		if w.EAP != nil {
+			return errors.Errorf("%s: a network uses either a psk or 802.1X", w.SSID)
+		}
+
+		if msg := IsValidPSK(w.PSK); msg != "" {
+			return errors.Errorf("%s: %s", w.SSID, msg)
+		}
+	}
+
+	if w.EAP != nil {
+		if err := w.EAP.validate(); err != nil {
+			return errors.Errorf("%s: %v", w.SSID, err)
+		}
+	}
+
+	// the wpa_supplicant's quoted strings end with the line
+	for _, curr := range w.values() {
+		if strings.ContainsAny(curr, "\r\n") {
+			return errors.Errorf("%s: the wireless settings can not have line breaks", w.SSID)
+		}
+	}
+
+	return nil
+}
+
+// IsValidSSID returns empty string if str is a valid SSID
+func IsValidSSID(str string) string {
+	if len(str) == 0 || len(str) > 32 {
+		return "The SSID must have 1 to 32 characters"
+	}
+
+	return ""
+}
+
+// IsValidPSK returns empty string if str is a valid pre-shared key, either a
+// passphrase or the 64 hex digits key
+func IsValidPSK(str string) string {
+	if !hexPSKExp.MatchString(str) && (len(str) < 8 || len(str) > 63) {
+		return "The passphrase must have 8 to 63 characters"
+	}
+
+	return ""
+}
+
+func (eap *EAP) validate() error {
+	if !utils.StringSliceContains(eapMethods, eap.Method) {
+		return errors.Errorf("invalid EAP method: %q", eap.Method)
+	}
+
+	if eap.Identity == "" {
+		return errors.Errorf("the EAP identity is required")
+	}
+
+	if eap.Method == "tls" {
+		if eap.ClientCert == "" || eap.PrivateKey == "" {
+			return errors.Errorf("EAP-TLS requires a client certificate and private key")
+		}
+
+		return nil
+	}
+
+	if eap.Password == "" {
+		return errors.Errorf("EAP-%s requires a password", strings.ToUpper(eap.Method))
+	}
+
+	if eap.Phase2 != "" && !utils.StringSliceContains(eapPhase2Auth, eap.Phase2) {
+		return errors.Errorf("invalid EAP phase 2 authentication: %q", eap.Phase2)
+	}
+
+	return nil
+}
+
+// values returns the settings written as wpa_supplicant quoted strings
+func (w *Wireless) values() []string {
+	res := []string{w.PSK}
+
+	if w.EAP != nil {
+		res = append(res, w.EAP.Identity, w.EAP.Password, w.EAP.CACert, w.EAP.ClientCert,
+			w.EAP.PrivateKey, w.EAP.PrivateKeyPassword)
+	}
+
+	return res
+}
+
+// wpaSupplicantConf returns the wpa_supplicant configuration path of iface
+func wpaSupplicantConf(root string, iface string) string {
+	return filepath.Join(root, wpaSupplicantDir, fmt.Sprintf("wpa_supplicant-%s.conf", iface))
+}
+
+// wpaSupplicantUnit returns the wpa_supplicant service of iface
+func wpaSupplicantUnit(iface string) string {
+	return fmt.Sprintf("wpa_supplicant@%s.service", iface)
+}
+
+// applyWireless writes the wpa_supplicant configuration of the interface, only
+// readable by root as it carries the network's credentials
+func (i *Interface) applyWireless(root string) error {
+	if err := i.Wireless.Validate(); err != nil {
+		return err
+	}
+
+	if err := utils.MkdirAll(filepath.Join(root, wpaSupplicantDir), 0755); err != nil {
+		return err
+	}
+
+	data := struct {
+		*Wireless
+		HexPSK bool
+	}{i.Wireless, hexPSKExp.MatchString(i.Wireless.PSK)}
+
+	return writeFile(wpaSupplicantConf(root, i.Name), 0600, wpaSupplicantTemplate, data)
+}
+
+// ApplyWireless writes the wpa_supplicant configuration of the wireless ifaces to the
+// system at root
+func ApplyWireless(root string, ifaces []*Interface) error {
+	if root == "" {
+		return errors.Errorf("Could not apply wireless settings, Invalid root directory: %s", root)
+	}
+
+	for _, curr := range ifaces {
+		if curr.Wireless == nil {
+			continue
+		}
+
+		log.Info("Applying the %s wireless configuration to %s", curr.Name, root)
+
+		if err := curr.applyWireless(root); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartWireless (re)starts the wpa_supplicant service of the wireless ifaces
+func StartWireless(ifaces []*Interface) error {
+	for _, curr := range ifaces {
+		if curr.Wireless == nil {
+			continue
+		}
+
+		if err := cmd.RunAndLog("systemctl", "restart", wpaSupplicantUnit(curr.Name)); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// EnableWireless enables the wpa_supplicant service of the wireless ifaces on the
+// target system at root, the service is keyed on the interface name so the name is
+// pinned on the target
+func EnableWireless(root string, ifaces []*Interface) error {
+	for _, curr := range ifaces {
+		if curr.Wireless == nil {
+			continue
+		}
+
+		if err := curr.pinName(root); err != nil {
+			return err
+		}
+
+		args := []string{
+			filepath.Join(root, "/usr/bin/systemctl"),
+			fmt.Sprintf("--root=%s", root),
+			"enable",
+			wpaSupplicantUnit(curr.Name),
+		}
+
+		if err := cmd.RunAndLog(args...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// pinName writes the .link file naming the interface after i.Name on the target at
+// root, the interface is matched by its match criteria or by the hardware address it
+// has on the installer system. Nothing is written for the interfaces only known by
+// name, the target names them the same way.
+func (i *Interface) pinName(root string) error {
+	data := struct {
+		Name       string
+		MACAddress string
+		Driver     string
+		Path       string
+	}{i.Name, i.MACAddress, i.Driver, i.Path}
+
+	if !i.HasMatch() {
+		if i.HardwareAddr == "" {
+			return nil
+		}
+
+		data.MACAddress = i.HardwareAddr
+	}
+
+	if err := utils.MkdirAll(filepath.Join(root, configDir), 0755); err != nil {
+		return err
+	}
+
+	return writeConfigFile(root, fmt.Sprintf("10-%s.link", i.Name), linkTemplate, data)
+}
@@ -0,0 +1,219 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/cmd"
+)
+
+func TestValidateWireless(t *testing.T) {
+	tests := []struct {
+		wireless *Wireless
+		valid    bool
+	}{
+		{&Wireless{SSID: "home"}, true},
+		{&Wireless{SSID: "home", PSK: "passphrase"}, true},
+		{&Wireless{SSID: "home", PSK: strings.Repeat("0a", 32)}, true},
+		{&Wireless{SSID: "corp", EAP: &EAP{Method: "peap", Identity: "jdoe", Password: "secret",
+			Phase2: "mschapv2"}}, true},
+		{&Wireless{SSID: "corp", EAP: &EAP{Method: "tls", Identity: "jdoe",
+			ClientCert: "/etc/certs/jdoe.pem", PrivateKey: "/etc/certs/jdoe.key"}}, true},
+		{&Wireless{SSID: ""}, false},
+		{&Wireless{SSID: strings.Repeat("s", 33)}, false},
+		{&Wireless{SSID: "home", PSK: "short"}, false},
+		{&Wireless{SSID: "home", PSK: "pass\nphrase"}, false},
+		{&Wireless{SSID: "corp", PSK: "passphrase", EAP: &EAP{Method: "peap", Identity: "jdoe",
+			Password: "secret"}}, false},
+		{&Wireless{SSID: "corp", EAP: &EAP{Method: "leap", Identity: "jdoe", Password: "secret"}}, false},
+		{&Wireless{SSID: "corp", EAP: &EAP{Method: "ttls", Identity: "jdoe"}}, false},
+		{&Wireless{SSID: "corp", EAP: &EAP{Method: "tls", Identity: "jdoe"}}, false},
+	}
+
+	for _, curr := range tests {
+		err := curr.wireless.Validate()
+
+		if curr.valid && err != nil {
+			t.Fatalf("%+v should be valid: %v", curr.wireless, err)
+		}
+
+		if !curr.valid && err == nil {
+			t.Fatalf("%+v should be invalid", curr.wireless)
+		}
+	}
+}
+
+func TestApplyWireless(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ifaces := []*Interface{
+		{Name: "wlan0", DHCP: true, Wireless: &Wireless{SSID: "home", PSK: "passphrase", Hidden: true}},
+		{Name: "wlan1", DHCP: true, Wireless: &Wireless{SSID: "corp", EAP: &EAP{Method: "peap",
+			Identity: "jdoe", Password: "secret", Phase2: "mschapv2", CACert: "/etc/certs/ca.pem"}}},
+		{Name: "eth0", DHCP: true},
+	}
+
+	if err = ApplyConfig(dir, ifaces, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ApplyWireless(dir, ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	path := wpaSupplicantConf(dir, "wlan0")
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `ctrl_interface=/run/wpa_supplicant
+
+network={
+	ssid=686f6d65
+	scan_ssid=1
+	key_mgmt=WPA-PSK
+	psk="passphrase"
+}
+`
+	if string(content) != expected {
+		t.Fatalf("Unexpected wpa_supplicant file, expected:\n%s\ngot:\n%s", expected, content)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("The wpa_supplicant file should only be readable by root: %v", fi.Mode())
+	}
+
+	if content, err = ioutil.ReadFile(wpaSupplicantConf(dir, "wlan1")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, curr := range []string{"key_mgmt=WPA-EAP", "eap=PEAP", `identity="jdoe"`,
+		`phase2="auth=MSCHAPV2"`, `ca_cert="/etc/certs/ca.pem"`} {
+		if !strings.Contains(string(content), curr) {
+			t.Fatalf("The wlan1 wpa_supplicant file misses %s: %s", curr, content)
+		}
+	}
+
+	// the default dhcp configuration doesn't match the wireless interfaces
+	if _, err = os.Stat(filepath.Join(dir, configDir, "10-wlan0.network")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(wpaSupplicantConf(dir, "eth0")); !os.IsNotExist(err) {
+		t.Fatal("A wired interface should not have a wpa_supplicant file")
+	}
+}
+
+func TestEnableWireless(t *testing.T) {
+	rec := cmd.NewRecorder()
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ifaces := []*Interface{
+		{Name: "eth0", DHCP: true},
+		{Name: "wlan0", DHCP: true, Wireless: &Wireless{SSID: "home"}},
+		{Name: "wifi", DHCP: true, Driver: "iwlwifi", Wireless: &Wireless{SSID: "home"}},
+		{Name: "wlp2s0", DHCP: true, HardwareAddr: "00:11:22:33:44:55", Wireless: &Wireless{SSID: "home"}},
+	}
+
+	if err = StartWireless(ifaces[:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = EnableWireless(dir, ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"systemctl restart wpa_supplicant@wlan0.service",
+		dir + "/usr/bin/systemctl --root=" + dir + " enable wpa_supplicant@wlan0.service",
+		dir + "/usr/bin/systemctl --root=" + dir + " enable wpa_supplicant@wifi.service",
+		dir + "/usr/bin/systemctl --root=" + dir + " enable wpa_supplicant@wlp2s0.service",
+	}
+
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Invalid commands: %v, expected: %v", lines, expected)
+	}
+
+	// the target names the interfaces as their wpa_supplicant services expect
+	links := map[string]string{
+		"10-wifi.link":   "[Match]\nDriver=iwlwifi\n\n[Link]\nName=wifi\n",
+		"10-wlp2s0.link": "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Link]\nName=wlp2s0\n",
+	}
+
+	for file, content := range links {
+		b, err := ioutil.ReadFile(filepath.Join(dir, configDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != content {
+			t.Fatalf("Unexpected %s, expected:\n%s\ngot:\n%s", file, content, b)
+		}
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, configDir, "10-wlan0.link")); !os.IsNotExist(err) {
+		t.Fatal("The name matched interface should not be pinned")
+	}
+}
+
+func TestIsWireless(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	saved := sysClassNet
+	defer func() { sysClassNet = saved }()
+	sysClassNet = dir
+
+	if err = os.MkdirAll(filepath.Join(dir, "wlp2s0", "wireless"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if !isWirelessDevice("wlp2s0") || isWirelessDevice("enp0s3") {
+		t.Fatal("Only wlp2s0 is a wireless device")
+	}
+
+	iface := &Interface{Name: "wlan0", Wireless: &Wireless{SSID: "home", PSK: "passphrase"}}
+
+	b, err := yaml.Marshal(iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Interface{}
+	if err = yaml.Unmarshal(b, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !loaded.IsWireless() || !reflect.DeepEqual(loaded.Wireless, iface.Wireless) {
+		t.Fatalf("The wireless settings were not loaded: %+v", loaded.Wireless)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// EventStart is emitted when a new progress task is started
+	EventStart = "start"
+
+	// EventPartial is emitted on each partial step of a MultiStep task
+	EventPartial = "partial"
+
+	// EventStep is emitted on each step of a Loop task
+	EventStep = "step"
+
+	// EventEnd is emitted when a progress task is completed, see Event.Outcome
+	EventEnd = "end"
+
+	// OutcomeSuccess is the outcome of a successfully completed task
+	OutcomeSuccess = "success"
+
+	// OutcomeFailure is the outcome of a failed task
+	OutcomeFailure = "failure"
+
+	// JSONStdout is the JSON stream path meaning the standard output
+	JSONStdout = "-"
+)
+
+// Event is a progress event as written by JSONClient, one event per line
+type Event struct {
+	ID          int       `json:"id"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Started     time.Time `json:"started"`
+	Time        time.Time `json:"time"`
+	Percent     *float64  `json:"percent,omitempty"`
+	Outcome     string    `json:"outcome,omitempty"`
+}
+
+// JSONClient is a progress.Client implementation writing the progress as a
+// newline-delimited JSON event stream, suitable for tools wrapping the installer
+type JSONClient struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+	id      int
+	desc    string
+	started time.Time
+	err     error
+}
+
+// jsonNow is the clock used to timestamp the events
+var jsonNow = time.Now
+
+// NewJSON creates a new JSONClient writing the events to w
+func NewJSON(w io.Writer) *JSONClient {
+	return &JSONClient{encoder: json.NewEncoder(w)}
+}
+
+// OpenJSON creates a new JSONClient writing the events to path, path can be a
+// regular file, a FIFO or JSONStdout. Opening a FIFO blocks until it has a reader.
+func OpenJSON(path string) (*JSONClient, error) {
+	if path == JSONStdout {
+		return NewJSON(os.Stdout), nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	jc := NewJSON(f)
+	jc.closer = f
+
+	return jc, nil
+}
+
+// Close closes the underlying file, if any, and returns the first write error
+func (jc *JSONClient) Close() error {
+	jc.mutex.Lock()
+	defer jc.mutex.Unlock()
+
+	if jc.closer != nil {
+		if err := jc.closer.Close(); err != nil && jc.err == nil {
+			jc.err = err
+		}
+		jc.closer = nil
+	}
+
+	return jc.err
+}
+
+// emit writes an event for the current task, the first write error is kept and
+// the following events are dropped since a broken stream can't be recovered
+func (jc *JSONClient) emit(evType string, percent *float64, outcome string) {
+	if jc.err != nil {
+		return
+	}
+
+	ev := &Event{
+		ID:          jc.id,
+		Type:        evType,
+		Description: jc.desc,
+		Started:     jc.started,
+		Time:        jc.started,
+		Percent:     percent,
+		Outcome:     outcome,
+	}
+
+	if evType != EventStart {
+		ev.Time = jsonNow()
+	}
+
+	jc.err = jc.encoder.Encode(ev)
+}
+
+// Desc is part of the progress.Client implementation, it starts a new task
+func (jc *JSONClient) Desc(desc string) {
+	jc.mutex.Lock()
+	defer jc.mutex.Unlock()
+
+	jc.id++
+	jc.desc = desc
+	jc.started = jsonNow()
+	jc.emit(EventStart, nil, "")
+}
+
+// Partial is part of the progress.Client implementation, it reports the task's
+// completion percentage
+func (jc *JSONClient) Partial(total int, step int) {
+	jc.mutex.Lock()
+	defer jc.mutex.Unlock()
+
+	percent := 0.0
+	if total > 0 {
+		percent = float64(step) / float64(total) * 100
+	}
+
+	jc.emit(EventPartial, &percent, "")
+}
+
+// Step is part of the progress.Client implementation, it works as a heartbeat for
+// tasks with no known completion
+func (jc *JSONClient) Step() {
+	jc.mutex.Lock()
+	defer jc.mutex.Unlock()
+
+	jc.emit(EventStep, nil, "")
+}
+
+// Success is part of the progress.Client implementation
+func (jc *JSONClient) Success() {
+	jc.mutex.Lock()
+	defer jc.mutex.Unlock()
+
+	percent := 100.0
+	jc.emit(EventEnd, &percent, OutcomeSuccess)
+}
+
+// Failure is part of the progress.Client implementation
+func (jc *JSONClient) Failure() {
+	jc.mutex.Lock()
+	defer jc.mutex.Unlock()
+
+	jc.emit(EventEnd, nil, OutcomeFailure)
+}
+
+// LoopWaitDuration is part of the progress.Client implementation, the loop steps
+// are only heartbeats so they're kept sparse
+func (jc *JSONClient) LoopWaitDuration() time.Duration {
+	return time.Second
+}
@@ -0,0 +1,136 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package progress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, data []byte) []*Event {
+	t.Helper()
+
+	res := []*Event{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		ev := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), ev); err != nil {
+			t.Fatalf("Invalid event line %q: %s", scanner.Text(), err)
+		}
+		res = append(res, ev)
+	}
+
+	return res
+}
+
+func TestJSONEvents(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	jsonNow = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	defer func() { jsonNow = time.Now }()
+
+	buf := &bytes.Buffer{}
+	Set(NewJSON(buf))
+
+	prg := MultiStep(4, "Writing %s", "partitions")
+	prg.Partial(1)
+	prg.Success()
+
+	prg = MultiStep(2, "Installing bundles")
+	prg.Failure()
+
+	events := readEvents(t, buf.Bytes())
+
+	expected := []struct {
+		id      int
+		evType  string
+		desc    string
+		percent float64
+		outcome string
+	}{
+		{1, EventStart, "Writing partitions", -1, ""},
+		{1, EventPartial, "Writing partitions", 25, ""},
+		{1, EventEnd, "Writing partitions", 100, OutcomeSuccess},
+		{2, EventStart, "Installing bundles", -1, ""},
+		{2, EventEnd, "Installing bundles", -1, OutcomeFailure},
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %s", len(expected), len(events), buf.String())
+	}
+
+	for idx, curr := range expected {
+		ev := events[idx]
+
+		if ev.ID != curr.id || ev.Type != curr.evType || ev.Description != curr.desc ||
+			ev.Outcome != curr.outcome {
+			t.Fatalf("Event %d mismatch, expected %+v, got %+v", idx, curr, ev)
+		}
+
+		if curr.percent < 0 && ev.Percent != nil {
+			t.Fatalf("Event %d should have no percentage, got %f", idx, *ev.Percent)
+		} else if curr.percent >= 0 && (ev.Percent == nil || *ev.Percent != curr.percent) {
+			t.Fatalf("Event %d should have percentage %f", idx, curr.percent)
+		}
+
+		if ev.Time.Before(ev.Started) {
+			t.Fatalf("Event %d time %s is before the task start %s", idx, ev.Time, ev.Started)
+		}
+	}
+
+	if !events[2].Started.Equal(events[0].Time) {
+		t.Fatalf("The end event should carry the task start time")
+	}
+}
+
+func TestJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-progress-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "events.json")
+
+	jc, err := OpenJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Set(jc)
+
+	prg := NewLoop("Waiting")
+	prg.Success()
+
+	if err = jc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := readEvents(t, data)
+	if len(events) < 2 {
+		t.Fatalf("Expected at least 2 events, got: %s", string(data))
+	}
+
+	first := events[0]
+	last := events[len(events)-1]
+
+	if first.Type != EventStart || last.Type != EventEnd || last.Outcome != OutcomeSuccess {
+		t.Fatalf("Unexpected events: %s", string(data))
+	}
+}
@@ -57,9 +57,12 @@ var (
 	impl Client
 )
 
-// Set defines the default progress client implementation
-func Set(pi Client) {
+// Set defines the default progress client implementation and returns the previous one
+func Set(pi Client) Client {
+	prev := impl
 	impl = pi
+
+	return prev
 }
 
 // MultiStep creates a new MultiStep implementation
@@ -0,0 +1,255 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// Config is the proxy configuration used to reach the network, the http and https
+// proxies are URLs and the credentials, if any, are added to both
+type Config struct {
+	HTTP     string
+	HTTPS    string
+	NoProxy  []string
+	User     string
+	Password string
+}
+
+var (
+	current      Config
+	currentMutex sync.RWMutex
+)
+
+// Set defines cfg as the proxy configuration of all the commands executions and
+// the installer's own http requests
+func Set(cfg Config) {
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+
+	current = cfg
+}
+
+// Get returns the current proxy configuration
+func Get() Config {
+	currentMutex.RLock()
+	defer currentMutex.RUnlock()
+
+	return current
+}
+
+// IsSet returns true if any proxy is configured
+func (cfg Config) IsSet() bool {
+	return cfg.HTTP != "" || cfg.HTTPS != ""
+}
+
+// IsValidURL returns empty string if str is a valid proxy URL, an empty str is valid
+func IsValidURL(str string) string {
+	if str == "" {
+		return ""
+	}
+
+	u, err := url.ParseRequestURI(str)
+	if err != nil || u.Host == "" {
+		return "Invalid URL for Proxy Server"
+	}
+
+	return ""
+}
+
+// Validate checks the proxy URLs
+func (cfg Config) Validate() error {
+	for _, curr := range []string{cfg.HTTP, cfg.HTTPS} {
+		if msg := IsValidURL(curr); msg != "" {
+			return errors.ValidationErrorf("%s: %s", msg, curr)
+		}
+	}
+
+	if cfg.Password != "" && cfg.User == "" {
+		return errors.ValidationErrorf("A proxy password requires a proxy user")
+	}
+
+	return nil
+}
+
+// withCredentials returns the proxy URL str with the configured credentials
+func (cfg Config) withCredentials(str string) string {
+	if str == "" || cfg.User == "" {
+		return str
+	}
+
+	u, err := url.Parse(str)
+	if err != nil {
+		return str
+	}
+
+	if cfg.Password != "" {
+		u.User = url.UserPassword(cfg.User, cfg.Password)
+	} else {
+		u.User = url.User(cfg.User)
+	}
+
+	return u.String()
+}
+
+// Env returns the proxy environment variables in the form name=value, curl honors
+// both the lower and upper case names but not every other program does
+func (cfg Config) Env() []string {
+	res := []string{}
+
+	vars := []struct {
+		name  string
+		value string
+	}{
+		{"http_proxy", cfg.withCredentials(cfg.HTTP)},
+		{"https_proxy", cfg.withCredentials(cfg.HTTPS)},
+		{"no_proxy", strings.Join(cfg.NoProxy, ",")},
+	}
+
+	for _, curr := range vars {
+		if curr.value == "" {
+			continue
+		}
+
+		res = append(res, fmt.Sprintf("%s=%s", curr.name, curr.value))
+		res = append(res, fmt.Sprintf("%s=%s", strings.ToUpper(curr.name), curr.value))
+	}
+
+	return res
+}
+
+// bypass returns true if host matches a no_proxy entry: a host name, a domain
+// suffix with or without the leading dot, an address, a network or "*"
+func (cfg Config) bypass(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, curr := range cfg.NoProxy {
+		curr = strings.ToLower(strings.TrimSpace(curr))
+
+		if curr == "*" || curr == host {
+			return true
+		}
+
+		if _, ipNet, err := net.ParseCIDR(curr); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if strings.HasSuffix(host, "."+strings.TrimPrefix(curr, ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Func is the http.Transport's Proxy implementation, the environment's proxy is used
+// if no proxy is configured
+func Func(req *http.Request) (*url.URL, error) {
+	cfg := Get()
+
+	if !cfg.IsSet() {
+		return http.ProxyFromEnvironment(req)
+	}
+
+	if cfg.bypass(req.URL.Host) {
+		return nil, nil
+	}
+
+	str := cfg.HTTP
+	if req.URL.Scheme == "https" {
+		str = cfg.HTTPS
+	}
+
+	if str == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(cfg.withCredentials(str))
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return u, nil
+}
+
+// NewTransport returns a http.Transport using the proxy configuration
+func NewTransport() *http.Transport {
+	return &http.Transport{Proxy: Func}
+}
+
+// WriteTarget writes the proxy configuration to the target system at root, the
+// login sessions get it from /etc/environment and the system services from the
+// systemd manager's default environment. The credentials are only written to the
+// root only readable /etc/environment, the systemd default environment is exposed
+// to every service
+func WriteTarget(root string, cfg Config) error {
+	env := cfg.Env()
+	if len(env) == 0 {
+		return nil
+	}
+
+	// the credentials are only readable by root
+	perm := os.FileMode(0644)
+	if cfg.User != "" {
+		perm = 0600
+	}
+
+	etcDir := filepath.Join(root, "etc")
+	if err := utils.MkdirAll(etcDir, 0755); err != nil {
+		return err
+	}
+
+	content := strings.Join(env, "\n") + "\n"
+	if err := writeFile(filepath.Join(etcDir, "environment"), content, perm); err != nil {
+		return err
+	}
+
+	confDir := filepath.Join(etcDir, "systemd", "system.conf.d")
+	if err := utils.MkdirAll(confDir, 0755); err != nil {
+		return err
+	}
+
+	quoted := []string{}
+	for _, curr := range (Config{HTTP: cfg.HTTP, HTTPS: cfg.HTTPS, NoProxy: cfg.NoProxy}).Env() {
+		quoted = append(quoted, fmt.Sprintf("%q", curr))
+	}
+
+	content = fmt.Sprintf("[Manager]\nDefaultEnvironment=%s\n", strings.Join(quoted, " "))
+
+	return writeFile(filepath.Join(confDir, "proxy.conf"), content, 0644)
+}
+
+// writeFile writes content to path, an existing file is replaced
+func writeFile(path string, content string, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err = f.WriteString(content); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
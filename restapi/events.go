@@ -0,0 +1,70 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package restapi
+
+import (
+	"sync"
+
+	"github.com/clearlinux/clr-installer/errors"
+)
+
+// eventLog keeps the progress event lines written by the progress.JSONClient so
+// any number of clients can follow the install progress from its beginning
+type eventLog struct {
+	mutex   sync.Mutex
+	lines   [][]byte
+	changed chan struct{}
+	closed  bool
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{changed: make(chan struct{})}
+}
+
+// Write is the io.Writer implementation, the progress.JSONClient writes one event
+// line per call
+func (el *eventLog) Write(p []byte) (int, error) {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	if el.closed {
+		return 0, errors.Errorf("The progress event log is closed")
+	}
+
+	el.lines = append(el.lines, append([]byte{}, p...))
+
+	close(el.changed)
+	el.changed = make(chan struct{})
+
+	return len(p), nil
+}
+
+// Close ends the event log, the clients following it are released
+func (el *eventLog) Close() error {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	if !el.closed {
+		el.closed = true
+		close(el.changed)
+	}
+
+	return nil
+}
+
+// since returns the lines starting at idx, a channel closed on the next change
+// and whether the log is closed
+func (el *eventLog) since(idx int) ([][]byte, <-chan struct{}, bool) {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	var res [][]byte
+
+	if idx < len(el.lines) {
+		res = el.lines[idx:]
+	}
+
+	return res, el.changed, el.closed
+}
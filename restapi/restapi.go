@@ -0,0 +1,602 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package restapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/controller"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/keyboard"
+	"github.com/clearlinux/clr-installer/language"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/progress"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/swupd"
+	"github.com/clearlinux/clr-installer/timezone"
+)
+
+const (
+	// StateIdle means no install was started yet
+	StateIdle = "idle"
+
+	// StateRunning means the install is in progress
+	StateRunning = "running"
+
+	// StateSuccess means the install has completed successfully
+	StateSuccess = "success"
+
+	// StateFailure means the install has failed
+	StateFailure = "failure"
+
+	yamlContentType   = "application/x-yaml"
+	jsonContentType   = "application/json"
+	ndjsonContentType = "application/x-ndjson"
+
+	// maxDescriptorSize limits the size of the uploaded descriptors
+	maxDescriptorSize = 1 << 20
+
+	shutdownTimeout = 5 * time.Second
+
+	// defaultHost is the address served when --rest-address has no host
+	defaultHost = "127.0.0.1"
+
+	// tokenSize is the number of random bytes of a generated token
+	tokenSize = 32
+)
+
+var (
+	// remoteDeniedKeys are the descriptor keys the remote clients can't set, the hooks
+	// run as root on the installer system
+	remoteDeniedKeys = []string{"pre-install", "post-install"}
+)
+
+// RestAPI is the frontend implementation for the remotely driven installs, it
+// serves an HTTP API to manage the install descriptor, start the install and follow
+// its progress. Every request must carry the "Authorization: Bearer <token>" header.
+//
+//	GET   /api/v1/descriptor     the current descriptor, yaml formatted
+//	PUT   /api/v1/descriptor     replaces the descriptor
+//	PATCH /api/v1/descriptor     merges the top level keys, null removes a key
+//	GET   /api/v1/validation     validates the current descriptor
+//	GET   /api/v1/block-devices  the available block devices, ?rescan=true rescans
+//	GET   /api/v1/install        the install state
+//	POST  /api/v1/install        starts the install, only one install is allowed
+//	GET   /api/v1/progress       the JSON progress event stream
+//	POST  /api/v1/finish         stops the server, ?reboot=true reboots the system
+type RestAPI struct {
+	mutex    sync.Mutex
+	md       *model.SystemInstall
+	rootDir  string
+	options  args.Args
+	token    string
+	state    string
+	instErr  error
+	events   *eventLog
+	finished chan bool
+
+	// install and blockDevices are replaced by the tests
+	install      func(rootDir string, md *model.SystemInstall, options args.Args) error
+	blockDevices func(rescan bool, userDefined []*storage.BlockDevice) ([]*storage.BlockDevice, error)
+}
+
+// installStatus is the GET /api/v1/install response
+type installStatus struct {
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// validationResult is the GET /api/v1/validation response
+type validationResult struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// New creates a new instance of RestAPI frontend implementation
+func New() *RestAPI {
+	return &RestAPI{
+		state:        StateIdle,
+		events:       newEventLog(),
+		finished:     make(chan bool, 1),
+		install:      controller.Install,
+		blockDevices: listBlockDevices,
+	}
+}
+
+func listBlockDevices(rescan bool, userDefined []*storage.BlockDevice) ([]*storage.BlockDevice, error) {
+	if rescan {
+		return storage.RescanBlockDevices(userDefined)
+	}
+
+	return storage.ListAvailableBlockDevices(userDefined)
+}
+
+// MustRun is part of the Frontend implementation and tells the core implementation that this
+// frontend wants or should be executed
+func (ra *RestAPI) MustRun(args *args.Args) bool {
+	return args.RESTAddress != ""
+}
+
+// Run is part of the Frontend implementation and is the actual entry point for the
+// REST API frontend, it serves the API until a client requests to finish
+func (ra *RestAPI) Run(md *model.SystemInstall, rootDir string, options args.Args) (bool, error) {
+	ra.md = md
+	ra.rootDir = rootDir
+	ra.options = options
+
+	if (options.RESTTLSCert == "") != (options.RESTTLSKey == "") {
+		return false, errors.Errorf("The REST API TLS requires both a certificate and a key")
+	}
+
+	generated := options.RESTTokenFile == ""
+
+	var err error
+	if ra.token, err = loadToken(options.RESTTokenFile); err != nil {
+		return false, err
+	}
+
+	progress.Set(progress.NewJSON(ra.events))
+
+	listener, err := net.Listen("tcp", listenAddress(options.RESTAddress))
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+
+	srv := &http.Server{Handler: ra.Handler()}
+	srvErr := make(chan error, 1)
+
+	go func() {
+		if options.RESTTLSCert != "" {
+			srvErr <- srv.ServeTLS(listener, options.RESTTLSCert, options.RESTTLSKey)
+			return
+		}
+
+		srvErr <- srv.Serve(listener)
+	}()
+
+	log.Info("REST API listening on: %s", listener.Addr())
+	fmt.Printf("Waiting for the install requests on: %s\n", listener.Addr())
+
+	// the token is never logged, the log is archived to the target
+	if generated {
+		fmt.Printf("REST API token: %s\n", ra.token)
+	}
+
+	var reboot bool
+
+	select {
+	case reboot = <-ra.finished:
+	case err = <-srvErr:
+		return false, errors.Wrap(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err = srv.Shutdown(ctx); err != nil {
+		log.Warning("Failed to shutdown the REST API server: %s", err)
+	}
+
+	ra.mutex.Lock()
+	defer ra.mutex.Unlock()
+
+	return reboot, ra.instErr
+}
+
+// listenAddress returns the address to listen on, an address without host is only
+// reachable from the installer system
+func listenAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host != "" {
+		return address
+	}
+
+	return net.JoinHostPort(defaultHost, port)
+}
+
+// loadToken reads the clients' bearer token from path, a random token is generated if
+// no path is provided
+func loadToken(path string) (string, error) {
+	if path == "" {
+		buf := make([]byte, tokenSize)
+		if _, err := rand.Read(buf); err != nil {
+			return "", errors.Wrap(err)
+		}
+
+		return hex.EncodeToString(buf), nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", errors.Errorf("The REST API token file is empty: %s", path)
+	}
+
+	return token, nil
+}
+
+// authenticate rejects the requests without the expected bearer token
+func (ra *RestAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if ra.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(ra.token)) != 1 {
+			log.Warning("Unauthorized REST API request from %s", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.Errorf("Unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler returns the http.Handler serving the API
+func (ra *RestAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v1/descriptor", ra.handleDescriptor)
+	mux.HandleFunc("/api/v1/validation", ra.handleValidation)
+	mux.HandleFunc("/api/v1/block-devices", ra.handleBlockDevices)
+	mux.HandleFunc("/api/v1/install", ra.handleInstall)
+	mux.HandleFunc("/api/v1/progress", ra.handleProgress)
+	mux.HandleFunc("/api/v1/finish", ra.handleFinish)
+
+	return ra.authenticate(mux)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warning("Failed to write the REST API response: %s", err)
+	}
+}
+
+func writeYAML(w http.ResponseWriter, v interface{}) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", yamlContentType)
+
+	if _, err = w.Write(b); err != nil {
+		log.Warning("Failed to write the REST API response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, errors.Errorf("Method not allowed: %s", r.Method))
+}
+
+// validate checks md as the installer does for the command line provided descriptors
+func validate(md *model.SystemInstall) error {
+	if err := md.Validate(); err != nil {
+		return err
+	}
+
+	if md.Keyboard != nil && !keyboard.IsValidKeyboard(md.Keyboard) {
+		return errors.ValidationErrorf("Invalid Keyboard '%s'", md.Keyboard.Code)
+	}
+
+	if md.Timezone != nil && !timezone.IsValidTimezone(md.Timezone) {
+		return errors.ValidationErrorf("Invalid Time Zone '%s'", md.Timezone.Code)
+	}
+
+	if md.Language != nil && !language.IsValidLanguage(md.Language) {
+		return errors.ValidationErrorf("Invalid Language '%s'", md.Language.Code)
+	}
+
+	return nil
+}
+
+// writeDescriptor writes md without its secrets, they can't be read back remotely
+func writeDescriptor(w http.ResponseWriter, md *model.SystemInstall) {
+	res, err := md.Redacted()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeYAML(w, res)
+}
+
+// checkRemoteKeys rejects the descriptor documents setting the keys the remote clients
+// are not allowed to
+func checkRemoteKeys(content []byte) error {
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, curr := range remoteDeniedKeys {
+		if _, ok := doc[curr]; ok {
+			return errors.Errorf("The %s hooks can't be set remotely", curr)
+		}
+	}
+
+	return nil
+}
+
+// mergeDescriptor merges the top level keys of the patch document into the md's
+// yaml representation, a null value removes the key
+func mergeDescriptor(md *model.SystemInstall, patch []byte) ([]byte, error) {
+	current, err := yaml.Marshal(md)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	doc := map[string]interface{}{}
+	if err = yaml.Unmarshal(current, &doc); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	changes := map[string]interface{}{}
+	if err = yaml.Unmarshal(patch, &changes); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	for k, v := range changes {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+
+		doc[k] = v
+	}
+
+	return yaml.Marshal(doc)
+}
+
+func (ra *RestAPI) handleDescriptor(w http.ResponseWriter, r *http.Request) {
+	ra.mutex.Lock()
+	defer ra.mutex.Unlock()
+
+	if r.Method == http.MethodGet {
+		writeDescriptor(w, ra.md)
+		return
+	}
+
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	if ra.state != StateIdle {
+		writeError(w, http.StatusConflict, errors.Errorf("The install was already started"))
+		return
+	}
+
+	content, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxDescriptorSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = checkRemoteKeys(content); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		if content, err = mergeDescriptor(ra.md, content); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	md, err := model.Load(content, ra.options)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the passphrase is never part of the descriptor, keep the one we got at startup
+	md.CryptPass = ra.md.CryptPass
+
+	// only the hooks of the startup descriptor are run
+	md.PreInstall = ra.md.PreInstall
+	md.PostInstall = ra.md.PostInstall
+	ra.md = md
+
+	log.Info("Install descriptor updated by %s", r.RemoteAddr)
+	writeDescriptor(w, ra.md)
+}
+
+func (ra *RestAPI) handleValidation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	ra.mutex.Lock()
+	defer ra.mutex.Unlock()
+
+	res := validationResult{Valid: true}
+
+	if err := validate(ra.md); err != nil {
+		res.Valid = false
+		res.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (ra *RestAPI) handleBlockDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	ra.mutex.Lock()
+	defer ra.mutex.Unlock()
+
+	bds, err := ra.blockDevices(r.URL.Query().Get("rescan") == "true", ra.md.TargetMedias)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeYAML(w, bds)
+}
+
+func (ra *RestAPI) handleInstall(w http.ResponseWriter, r *http.Request) {
+	ra.mutex.Lock()
+	defer ra.mutex.Unlock()
+
+	if r.Method == http.MethodGet {
+		status := installStatus{State: ra.state}
+		if ra.instErr != nil {
+			status.Error = ra.instErr.Error()
+		}
+
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	if ra.state != StateIdle {
+		writeError(w, http.StatusConflict, errors.Errorf("The install was already started"))
+		return
+	}
+
+	if err := validate(ra.md); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if ra.md.SwupdMirror != "" && !ra.md.IsOffline() {
+		if _, err := swupd.SetHostMirror(ra.md.SwupdMirror); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	// the install updates its descriptor, the requests are served from a snapshot
+	snapshot, err := ra.md.Clone()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ra.state = StateRunning
+	go ra.runInstall(ra.md)
+	ra.md = snapshot
+
+	log.Info("Install started by %s", r.RemoteAddr)
+	writeJSON(w, http.StatusAccepted, installStatus{State: ra.state})
+}
+
+func (ra *RestAPI) runInstall(md *model.SystemInstall) {
+	err := ra.install(ra.rootDir, md, ra.options)
+
+	ra.mutex.Lock()
+	if err != nil {
+		log.ErrorError(err)
+		ra.state = StateFailure
+		ra.instErr = err
+	} else {
+		ra.state = StateSuccess
+	}
+	ra.mutex.Unlock()
+
+	_ = ra.events.Close()
+}
+
+// handleProgress streams the progress events from the install start, the response
+// ends when the install is completed or the client goes away
+func (ra *RestAPI) handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	idx := 0
+
+	for {
+		lines, changed, closed := ra.events.since(idx)
+
+		for _, line := range lines {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+
+		idx += len(lines)
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if closed {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (ra *RestAPI) handleFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	ra.mutex.Lock()
+	defer ra.mutex.Unlock()
+
+	if ra.state == StateRunning {
+		writeError(w, http.StatusConflict, errors.Errorf("The install is in progress"))
+		return
+	}
+
+	select {
+	case ra.finished <- r.URL.Query().Get("reboot") == "true":
+	default:
+		writeError(w, http.StatusConflict, errors.Errorf("The server is already finishing"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, installStatus{State: ra.state})
+}
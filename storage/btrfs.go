@@ -0,0 +1,273 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+// A Subvolume describes a btrfs subvolume and where and how it's mounted
+type Subvolume struct {
+	Name       string `yaml:"name,omitempty"`
+	MountPoint string `yaml:"mountpoint,omitempty"`
+	Options    string `yaml:"options,omitempty"`
+}
+
+const (
+	// topLevelMountOptions mounts the file system's top level rather than its
+	// default subvolume, which is the one mounted as root
+	topLevelMountOptions = "subvolid=5"
+)
+
+var (
+	// mount options the kernel handles as mount flags rather than fs specific data
+	mountFlagsMap = map[string]uintptr{
+		"ro":         syscall.MS_RDONLY,
+		"nosuid":     syscall.MS_NOSUID,
+		"nodev":      syscall.MS_NODEV,
+		"noexec":     syscall.MS_NOEXEC,
+		"sync":       syscall.MS_SYNCHRONOUS,
+		"noatime":    syscall.MS_NOATIME,
+		"nodiratime": syscall.MS_NODIRATIME,
+		"relatime":   syscall.MS_RELATIME,
+	}
+)
+
+// parseMountOptions splits a fstab like comma separated list of mount options in the
+// syscall.Mount() flags and its file system specific data
+func parseMountOptions(options string) (uintptr, string) {
+	var flags uintptr
+	data := []string{}
+
+	for _, opt := range strings.Split(options, ",") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" || opt == "defaults" {
+			continue
+		}
+
+		if flag, ok := mountFlagsMap[opt]; ok {
+			flags = flags | flag
+			continue
+		}
+
+		data = append(data, opt)
+	}
+
+	if flags&(syscall.MS_NOATIME|syscall.MS_RELATIME) == 0 {
+		flags = flags | syscall.MS_RELATIME
+	}
+
+	return flags, strings.Join(data, ",")
+}
+
+// formatMountOptions is the reverse of parseMountOptions(), it returns the fstab like
+// comma separated list of mount options for the syscall.Mount() flags and data
+func formatMountOptions(flags uintptr, data string) string {
+	opts := []string{}
+
+	for _, opt := range []string{"ro", "nosuid", "nodev", "noexec", "sync", "noatime",
+		"nodiratime", "relatime"} {
+		if flags&mountFlagsMap[opt] != 0 {
+			opts = append(opts, opt)
+		}
+	}
+
+	if data != "" {
+		opts = append(opts, data)
+	}
+
+	if len(opts) == 0 {
+		return "defaults"
+	}
+
+	return strings.Join(opts, ",")
+}
+
+// getMountOptions returns the subvolume's mount options including the subvol= option
+func (sv *Subvolume) getMountOptions() string {
+	opts := []string{"subvol=" + sv.Name}
+
+	if sv.Options != "" {
+		opts = append(opts, sv.Options)
+	}
+
+	return strings.Join(opts, ",")
+}
+
+// hasSubvolumeMountPoint returns true if any of the bd's subvolumes is mounted at mnt
+func (bd *BlockDevice) hasSubvolumeMountPoint(mnt string) bool {
+	for _, sv := range bd.Subvolumes {
+		if sv.MountPoint == mnt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateSubvolumes checks the bd's subvolume definitions
+func (bd *BlockDevice) validateSubvolumes() error {
+	if bd.FsType != "btrfs" {
+		return errors.Errorf("%s: subvolumes require a btrfs file system", bd.Name)
+	}
+
+	names := map[string]bool{}
+	mounts := map[string]bool{}
+
+	if bd.MountPoint != "" {
+		mounts[bd.MountPoint] = true
+	}
+
+	for _, sv := range bd.Subvolumes {
+		if sv.Name == "" || strings.HasPrefix(sv.Name, "/") || strings.Contains(sv.Name, "..") {
+			return errors.Errorf("%s: invalid subvolume name: %q", bd.Name, sv.Name)
+		}
+
+		if names[sv.Name] {
+			return errors.Errorf("%s: duplicated subvolume: %s", bd.Name, sv.Name)
+		}
+		names[sv.Name] = true
+
+		if sv.MountPoint == "" {
+			continue
+		}
+
+		if msg := IsValidMount(sv.MountPoint); msg != "" {
+			return errors.Errorf("%s: subvolume %s: %s", bd.Name, sv.Name, msg)
+		}
+
+		if mounts[sv.MountPoint] {
+			return errors.Errorf("%s: duplicated mount point: %s", bd.Name, sv.MountPoint)
+		}
+		mounts[sv.MountPoint] = true
+	}
+
+	return nil
+}
+
+// MakeSubvolumes creates the bd's btrfs subvolumes, the subvolume mounted as root
+// is set as the file system's default subvolume
+func (bd *BlockDevice) MakeSubvolumes() error {
+	if bd.FsType != "btrfs" {
+		return errors.Errorf("Trying to run MakeSubvolumes() against a non btrfs file system")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "clr-installer-btrfs-")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if err = mount(bd.GetMappedDeviceFile(), tmpDir, bd.FsType, 0, ""); err != nil {
+		return errors.Errorf("mount %s: %v", tmpDir, err)
+	}
+
+	defer func() {
+		if err := unmount(tmpDir, 0); err != nil {
+			log.Warning("Failed to umount %s: %v", tmpDir, err)
+		}
+	}()
+
+	for _, args := range bd.getSubvolumeCommands(tmpDir) {
+		if err = cmd.RunAndLog(args...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// getSubvolumeCommands returns the commands creating the bd's subvolumes with the
+// file system's top level mounted at dir
+func (bd *BlockDevice) getSubvolumeCommands(dir string) [][]string {
+	res := [][]string{}
+
+	for _, sv := range bd.Subvolumes {
+		path := filepath.Join(dir, sv.Name)
+
+		res = append(res, []string{"btrfs", "subvolume", "create", path})
+
+		if sv.MountPoint == "/" {
+			res = append(res, []string{"btrfs", "subvolume", "set-default", path})
+		}
+	}
+
+	return res
+}
+
+// subvolumeDevice returns a copy of bd mounted at mnt with the mount options opts
+func (bd *BlockDevice) subvolumeDevice(mnt string, opts string) *BlockDevice {
+	return &BlockDevice{
+		Name:         bd.Name,
+		MappedName:   bd.MappedName,
+		FsType:       bd.FsType,
+		UUID:         bd.UUID,
+		Label:        bd.Label,
+		MountPoint:   mnt,
+		Type:         bd.Type,
+		Parent:       bd.Parent,
+		mountOptions: opts,
+	}
+}
+
+// SubvolumeDevices returns one block device per mounted subvolume of bd plus the
+// file system's top level if bd has a mount point, the returned devices are suitable
+// for Mount()
+func (bd *BlockDevice) SubvolumeDevices() []*BlockDevice {
+	res := []*BlockDevice{}
+
+	if bd.MountPoint != "" {
+		res = append(res, bd.subvolumeDevice(bd.MountPoint, topLevelMountOptions))
+	}
+
+	for _, sv := range bd.Subvolumes {
+		if sv.MountPoint == "" {
+			continue
+		}
+
+		res = append(res, bd.subvolumeDevice(sv.MountPoint, sv.getMountOptions()))
+	}
+
+	return res
+}
+
+// getSubvolumesTab returns the fstab entries for the bd's subvolumes and its top level
+// mount point. The gpt auto generator would mount the default subvolume so only a top
+// level mounted as root, which is then the default subvolume, is left to it
+func (bd *BlockDevice) getSubvolumesTab() []string {
+	res := []string{}
+
+	dev := bd.GetDeviceID()
+	if bd.Type == BlockDeviceTypeCrypt {
+		dev = bd.GetMappedDeviceFile()
+	}
+
+	if bd.MountPoint != "" && bd.MountPoint != "/" {
+		res = append(res, strings.Join([]string{dev, bd.MountPoint, bd.FsType,
+			topLevelMountOptions, "0", "2"}, " "))
+	}
+
+	for _, sv := range bd.Subvolumes {
+		if sv.MountPoint == "" {
+			continue
+		}
+
+		res = append(res, strings.Join([]string{dev, sv.MountPoint, bd.FsType,
+			sv.getMountOptions(), "0", "0"}, " "))
+	}
+
+	return res
+}
@@ -57,13 +57,9 @@ func (bd *BlockDevice) EncryptionRequiresPassphrase() bool {
 	return enabled
 }
 
-// MapEncrypted uses cryptsetup to format (initialize) and open (map) the
-// physical partion to an encrypted partition
-func (bd *BlockDevice) MapEncrypted(passphrase string) error {
-	if bd.Type != BlockDeviceTypeCrypt {
-		return errors.Errorf("Trying to run cryptsetup() against a non crypt partition")
-	}
-
+// getLuksFormatCommand returns the cryptsetup command initializing the bd's luks
+// header, the passphrase is read from stdin
+func (bd *BlockDevice) getLuksFormatCommand() []string {
 	args := []string{
 		"cryptsetup",
 		"--batch-mode",
@@ -76,26 +72,49 @@ func (bd *BlockDevice) MapEncrypted(passphrase string) error {
 		args = append(args, "--label="+bd.Label)
 	}
 
-	args = append(args, "luksFormat", bd.GetDeviceFile(), "-")
+	return append(args, "luksFormat", bd.GetDeviceFile(), "-")
+}
 
-	if err := cmd.PipeRunAndLog(passphrase, args...); err != nil {
-		return errors.Wrap(err)
+// getLuksOpenCommand returns the cryptsetup command mapping the bd's encrypted
+// partition to mapped, the passphrase is read from stdin
+func (bd *BlockDevice) getLuksOpenCommand(mapped string) []string {
+	return []string{
+		"cryptsetup",
+		"--batch-mode",
+		"luksOpen",
+		bd.GetDeviceFile(),
+		mapped,
+		"-",
 	}
+}
 
-	mapped, err := bd.getMappedName()
-	if err != nil {
+// MapEncrypted uses cryptsetup to format (initialize) and open (map) the
+// physical partion to an encrypted partition
+func (bd *BlockDevice) MapEncrypted(passphrase string) error {
+	if bd.Type != BlockDeviceTypeCrypt {
+		return errors.Errorf("Trying to run cryptsetup() against a non crypt partition")
+	}
+
+	if err := cmd.PipeRunAndLog(passphrase, bd.getLuksFormatCommand()...); err != nil {
 		return errors.Wrap(err)
 	}
 
-	args = []string{
-		"cryptsetup",
-		"--batch-mode",
-		"luksOpen",
+	return bd.OpenEncrypted(passphrase)
+}
+
+// OpenEncrypted uses cryptsetup to open (map) a physical partition previously
+// initialized by MapEncrypted
+func (bd *BlockDevice) OpenEncrypted(passphrase string) error {
+	if bd.Type != BlockDeviceTypeCrypt {
+		return errors.Errorf("Trying to run cryptsetup() against a non crypt partition")
 	}
 
-	args = append(args, bd.GetDeviceFile(), mapped, "-")
+	mapped, err := bd.getMappedName()
+	if err != nil {
+		return errors.Wrap(err)
+	}
 
-	if err := cmd.PipeRunAndLog(passphrase, args...); err != nil {
+	if err := cmd.PipeRunAndLog(passphrase, bd.getLuksOpenCommand(mapped)...); err != nil {
 		return errors.Wrap(err)
 	}
 
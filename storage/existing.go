@@ -0,0 +1,158 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+// HasExistingPartitions returns true if the bd disk reuses its existing partitions, in
+// that case its partition table is kept untouched
+func (bd *BlockDevice) HasExistingPartitions() bool {
+	for _, ch := range bd.Children {
+		if ch.Existing {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsFormatRequired returns true if a file system must be written to bd, existing
+// partitions are only formatted if explicitly requested
+func (bd *BlockDevice) IsFormatRequired() bool {
+	return !bd.Existing || bd.Format
+}
+
+// validateExistingPartitions checks the existing partitions definitions of the bd disk,
+// since the partition table is preserved all of its children must be existing partitions
+func (bd *BlockDevice) validateExistingPartitions() error {
+	if !bd.HasExistingPartitions() {
+		return nil
+	}
+
+	for _, ch := range bd.Children {
+		if !ch.Existing {
+			return errors.Errorf("%s: can not create partition %s while reusing existing partitions",
+				bd.Name, ch.Name)
+		}
+
+		if ch.Type != BlockDeviceTypePart {
+			return errors.Errorf("%s: only plain partitions can be reused", ch.Name)
+		}
+
+		if ch.RelativeSize != "" {
+			return errors.Errorf("%s: relative sizes are not supported for existing partitions",
+				ch.Name)
+		}
+
+		if !ch.Format {
+			if len(ch.Subvolumes) > 0 {
+				return errors.Errorf("%s: subvolumes require the existing partition to be formatted",
+					ch.Name)
+			}
+
+			continue
+		}
+
+		if _, found := bdOps[ch.FsType]; !found {
+			return errors.Errorf("%s: unsupported file system: %q", ch.Name, ch.FsType)
+		}
+	}
+
+	return nil
+}
+
+// findExistingPartition looks up the partition matching ch in the children of disk, the
+// partition is matched by UUID, PARTLABEL or name in that order
+func findExistingPartition(disk *BlockDevice, ch *BlockDevice) *BlockDevice {
+	for _, curr := range disk.Children {
+		if ch.UUID != "" {
+			if curr.UUID == ch.UUID {
+				return curr
+			}
+		} else if ch.PartLabel != "" {
+			if curr.PartLabel == ch.PartLabel {
+				return curr
+			}
+		} else if curr.Name == ch.Name {
+			return curr
+		}
+	}
+
+	return nil
+}
+
+// matchExistingPartitions matches the existing partitions of medias against the
+// block devices bds, the matched partitions are updated with their actual name,
+// size and identifiers; the actual file system is kept for unformatted partitions
+func matchExistingPartitions(medias []*BlockDevice, bds []*BlockDevice) error {
+	for _, curr := range medias {
+		if !curr.HasExistingPartitions() {
+			continue
+		}
+
+		var disk *BlockDevice
+
+		for _, bd := range bds {
+			if bd.Name == curr.Name {
+				disk = bd
+				break
+			}
+		}
+
+		if disk == nil {
+			return errors.Errorf("Could not find the target media: %s", curr.Name)
+		}
+
+		for _, ch := range curr.Children {
+			part := findExistingPartition(disk, ch)
+			if part == nil {
+				return errors.Errorf("%s: could not find the existing partition %s",
+					curr.Name, ch.Name)
+			}
+
+			if !ch.Format {
+				if ch.FsType == "" {
+					ch.FsType = part.FsType
+				} else if ch.FsType != part.FsType {
+					return errors.Errorf("%s: existing file system is %q, %q requested without format",
+						part.Name, part.FsType, ch.FsType)
+				}
+			}
+
+			ch.Name = part.Name
+			ch.UUID = part.UUID
+			ch.PartLabel = part.PartLabel
+			ch.Size = part.Size
+
+			log.Debug("Existing partition %s matched, format: %t", ch.Name, ch.Format)
+		}
+	}
+
+	return nil
+}
+
+// ResolveExistingPartitions matches the existing partitions of the medias against
+// the system's block devices
+func ResolveExistingPartitions(medias []*BlockDevice) error {
+	reuse := false
+
+	for _, curr := range medias {
+		reuse = reuse || curr.HasExistingPartitions()
+	}
+
+	if !reuse {
+		return nil
+	}
+
+	bds, err := listBlockDevices(nil)
+	if err != nil {
+		return err
+	}
+
+	return matchExistingPartitions(medias, bds)
+}
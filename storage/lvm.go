@@ -0,0 +1,248 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// LVMRequiredBundle the bundle needed if lvm2 logical volumes are used
+	LVMRequiredBundle = "storage-utils"
+)
+
+var (
+	lvmNameExp = regexp.MustCompile(`^[a-zA-Z0-9+_][a-zA-Z0-9+_.-]*$`)
+
+	lvmOps = &blockDeviceOps{nil, nil, lvmMakePartCommand}
+
+	activeVolumeGroups []string
+)
+
+// IsValidVolumeGroupName returns empty string if name is a valid lvm2 volume group
+// or logical volume name
+func IsValidVolumeGroupName(name string) string {
+	if name == "" {
+		return "Volume group name is required"
+	}
+
+	if !lvmNameExp.MatchString(name) {
+		return "Invalid volume group name characters"
+	}
+
+	return ""
+}
+
+// validateLogicalVolumes checks a physical volume's volume group and logical volume
+// definitions, only the last logical volume may omit its size (fill remaining space)
+func (bd *BlockDevice) validateLogicalVolumes() error {
+	if msg := IsValidVolumeGroupName(bd.VolumeGroup); msg != "" {
+		return errors.Errorf("%s: %s", bd.Name, msg)
+	}
+
+	if len(bd.Children) == 0 {
+		return errors.Errorf("Volume group %s has no logical volumes", bd.VolumeGroup)
+	}
+
+	var total uint64
+
+	for idx, lv := range bd.Children {
+		if lv.Type != BlockDeviceTypeLVM2Volume {
+			return errors.Errorf("Volume group %s: %s is not a logical volume",
+				bd.VolumeGroup, lv.Name)
+		}
+
+		if !lvmNameExp.MatchString(lv.Name) {
+			return errors.Errorf("Volume group %s: invalid logical volume name: %q",
+				bd.VolumeGroup, lv.Name)
+		}
+
+		if lv.RelativeSize != "" {
+			return errors.Errorf("Volume group %s: relative sizes are not supported for logical volumes",
+				bd.VolumeGroup)
+		}
+
+		if lv.Size == 0 && idx != len(bd.Children)-1 {
+			return errors.Errorf("Volume group %s: only the last logical volume may omit its size",
+				bd.VolumeGroup)
+		}
+
+		total = total + lv.Size
+	}
+
+	if bd.Size > 0 && total > bd.Size {
+		return errors.Errorf("Volume group %s: logical volumes size %d larger than physical volume size: %d",
+			bd.VolumeGroup, total, bd.Size)
+	}
+
+	return nil
+}
+
+// lvmMakePartCommand creates the partition holding a lvm2 physical volume
+func lvmMakePartCommand(bd *BlockDevice, start uint64, end uint64) (string, error) {
+	args := []string{
+		"mkpart",
+		bd.VolumeGroup,
+		fmt.Sprintf("%dM", start),
+		fmt.Sprintf("%dM", end),
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// getLogicalVolumeCreateCommand returns the lvcreate command for the lv logical volume
+// in the vg volume group, a logical volume without size takes the remaining free space
+func getLogicalVolumeCreateCommand(vg string, lv *BlockDevice) []string {
+	args := []string{
+		"lvcreate",
+		"--yes",
+		"--wipesignatures",
+		"y",
+		"-n",
+		lv.Name,
+	}
+
+	if lv.Size == 0 {
+		args = append(args, "-l", "100%FREE")
+	} else {
+		args = append(args, "-L", fmt.Sprintf("%db", lv.Size))
+	}
+
+	return append(args, vg)
+}
+
+// getVolumeGroupCommands returns the commands initializing bd as a lvm2 physical
+// volume and creating its volume group, or extending it if extend is true
+func (bd *BlockDevice) getVolumeGroupCommands(extend bool) [][]string {
+	vgCmd := "vgcreate"
+	if extend {
+		vgCmd = "vgextend"
+	}
+
+	return [][]string{
+		{
+			"pvcreate",
+			"-ff",
+			"--yes",
+			bd.GetDeviceFile(),
+		},
+		{
+			vgCmd,
+			bd.VolumeGroup,
+			bd.GetDeviceFile(),
+		},
+	}
+}
+
+// MakeLogicalVolumes initializes bd as a lvm2 physical volume, creates (or extends)
+// its volume group and creates the logical volumes described by its children
+func (bd *BlockDevice) MakeLogicalVolumes() error {
+	if bd.Type != BlockDeviceTypeLVM2Group {
+		return errors.Errorf("Trying to run MakeLogicalVolumes() against a non lvm2 partition")
+	}
+
+	// a volume group may span physical volumes of multiple target medias
+	extend := utils.StringSliceContains(activeVolumeGroups, bd.VolumeGroup)
+
+	for _, args := range bd.getVolumeGroupCommands(extend) {
+		if err := cmd.RunAndLog(args...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if !utils.StringSliceContains(activeVolumeGroups, bd.VolumeGroup) {
+		// Store the volume group for later deactivation
+		activeVolumeGroups = append(activeVolumeGroups, bd.VolumeGroup)
+	}
+
+	for _, lv := range bd.Children {
+		lv.Parent = bd
+
+		if err := cmd.RunAndLog(getLogicalVolumeCreateCommand(bd.VolumeGroup, lv)...); err != nil {
+			return errors.Wrap(err)
+		}
+
+		lv.MappedName = filepath.Join(bd.VolumeGroup, lv.Name)
+		log.Debug("Logical volume %q created as %q", lv.Name, lv.GetMappedDeviceFile())
+	}
+
+	return nil
+}
+
+// ActivateLogicalVolumes uses vgchange to activate the logical volumes previously
+// created by MakeLogicalVolumes on the bd physical volume
+func (bd *BlockDevice) ActivateLogicalVolumes() error {
+	if bd.Type != BlockDeviceTypeLVM2Group {
+		return errors.Errorf("Trying to run ActivateLogicalVolumes() against a non lvm2 partition")
+	}
+
+	// a volume group spanning multiple target medias is activated only once
+	if !utils.StringSliceContains(activeVolumeGroups, bd.VolumeGroup) {
+		if err := cmd.RunAndLog("vgchange", "-ay", bd.VolumeGroup); err != nil {
+			return errors.Wrap(err)
+		}
+
+		activeVolumeGroups = append(activeVolumeGroups, bd.VolumeGroup)
+	}
+
+	for _, lv := range bd.Children {
+		lv.Parent = bd
+		lv.MappedName = filepath.Join(bd.VolumeGroup, lv.Name)
+	}
+
+	return nil
+}
+
+// getLogicalVolumesTab returns the fstab entries for the logical volumes of the bd
+// physical volume, lvm2 volumes are not discovered by the gpt auto generator so the
+// entries are always written using the logical volume path
+func (bd *BlockDevice) getLogicalVolumesTab() []string {
+	res := []string{}
+
+	for _, lv := range bd.Children {
+		var ftab []string
+		lvFile := filepath.Join("/dev", bd.VolumeGroup, lv.Name)
+
+		if lv.FsType == "swap" {
+			ftab = append(ftab, lvFile, "none", "swap", "defaults", "0", "0")
+		} else if lv.MountPoint != "" {
+			pass := "2"
+			if lv.MountPoint == "/" {
+				pass = "1"
+			}
+
+			ftab = append(ftab, lvFile, lv.MountPoint, lv.FsType, "defaults", "0", pass)
+		}
+
+		if len(ftab) > 0 {
+			res = append(res, strings.Join(ftab, " "))
+		}
+	}
+
+	return res
+}
+
+// deactivateVolumeGroup uses vgchange to deactivate all the logical volumes of vg
+func deactivateVolumeGroup(vg string) error {
+	args := []string{
+		"vgchange",
+		"-an",
+		vg,
+	}
+
+	if err := cmd.RunAndLog(args...); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -45,12 +46,30 @@ var (
 		"/srv":  "3B8F8425-20E0-4F3B-907F-1A25A76F98E8",
 		"swap":  "0657FD6D-A4AB-43C4-84E5-0933C84B4F4F",
 		"efi":   "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+		"lvm":   "E6D6D379-F507-44C2-A23C-238F2A3DF928",
+		"raid":  "A19D880F-05FC-4D3B-A006-743F0F84911E",
 	}
 
 	mountedPoints   []string
 	mountedEncrypts []string
+
+	// time given to the kernel and udev to settle the new partitions
+	partitionSettleTime = time.Duration(4) * time.Second
+
+	// planMode makes the mounts run as mount(8) and umount(8) commands, see SetPlanMode()
+	planMode bool
 )
 
+// SetPlanMode enables or disables the plan mode and returns the previous mode. In plan
+// mode the file systems are mounted and unmounted with the mount(8) and umount(8)
+// commands run through the cmd executor, so a recording executor records them along
+// the other commands, and the new partitions are not waited for
+func SetPlanMode(enable bool) bool {
+	prev := planMode
+	planMode = enable
+	return prev
+}
+
 // MakeFs runs mkfs.* commands for a BlockDevice definition
 func (bd *BlockDevice) MakeFs() error {
 	if bd.Type == BlockDeviceTypeDisk {
@@ -66,14 +85,17 @@ func (bd *BlockDevice) MakeFs() error {
 	return errors.Errorf("MakeFs() not implemented for filesystem: %s", bd.FsType)
 }
 
-func makeFs(bd *BlockDevice, args []string) error {
+// getMakeFsCommand completes the mkfs.* command args with the bd's options and device
+func (bd *BlockDevice) getMakeFsCommand(args []string) []string {
 	if bd.options != "" {
 		args = append(args, strings.Split(bd.options, " ")...)
 	}
 
-	args = append(args, bd.GetMappedDeviceFile())
+	return append(args, bd.GetMappedDeviceFile())
+}
 
-	err := cmd.RunAndLog(args...)
+func makeFs(bd *BlockDevice, args []string) error {
+	err := cmd.RunAndLog(bd.getMakeFsCommand(args)...)
 	if err != nil {
 		return errors.Wrap(err)
 	}
@@ -82,14 +104,27 @@ func makeFs(bd *BlockDevice, args []string) error {
 }
 
 // getGUID determines the partition type guid either based on:
-//   + mount point
+//   + lvm2 physical volume or software raid member
+//   + mount point (or btrfs subvolume mounted as root)
 //   + file system type (i.e swap)
 //   + or if it's the "special" efi case
 func (bd *BlockDevice) getGUID() (string, error) {
+	if bd.Type == BlockDeviceTypeLVM2Group {
+		return guidMap["lvm"], nil
+	}
+
+	if bd.Type == BlockDeviceTypeRaidMember {
+		return guidMap["raid"], nil
+	}
+
 	if guid, ok := guidMap[bd.MountPoint]; ok {
 		return guid, nil
 	}
 
+	if bd.hasSubvolumeMountPoint("/") {
+		return guidMap["/"], nil
+	}
+
 	if guid, ok := guidMap[bd.FsType]; ok {
 		return guid, nil
 	}
@@ -117,8 +152,8 @@ func (bd *BlockDevice) isStandardMount() bool {
 	return standard
 }
 
-// Mount will mount a block devices bd considering its mount point and the
-// root directory
+// Mount will mount a block devices bd considering its mount point, its mount
+// options and the root directory
 func (bd *BlockDevice) Mount(root string) error {
 	if bd.Type == BlockDeviceTypeDisk {
 		return errors.Errorf("Trying to run mountFs() against a disk, partition required")
@@ -126,7 +161,9 @@ func (bd *BlockDevice) Mount(root string) error {
 
 	targetPath := filepath.Join(root, bd.MountPoint)
 
-	return mountFs(bd.GetMappedDeviceFile(), targetPath, bd.FsType, syscall.MS_RELATIME)
+	flags, data := parseMountOptions(bd.mountOptions)
+
+	return mountFs(bd.GetMappedDeviceFile(), targetPath, bd.FsType, flags, data)
 }
 
 // UmountAll unmounts all previously mounted devices
@@ -138,7 +175,7 @@ func UmountAll() error {
 	sort.Sort(sort.Reverse(sort.StringSlice(mountedPoints)))
 
 	for _, point := range mountedPoints {
-		if err := syscall.Unmount(point, syscall.MNT_FORCE|syscall.MNT_DETACH); err != nil {
+		if err := unmount(point, syscall.MNT_FORCE|syscall.MNT_DETACH); err != nil {
 			err = fmt.Errorf("umount %s: %v", point, err)
 			log.ErrorError(err)
 			fails = append(fails, point)
@@ -146,6 +183,29 @@ func UmountAll() error {
 			log.Debug("Unmounted ok: %s", point)
 		}
 	}
+	mountedPoints = nil
+
+	for _, vg := range activeVolumeGroups {
+		if err := deactivateVolumeGroup(vg); err != nil {
+			err = fmt.Errorf("deactivate volume group %s: %v", vg, err)
+			log.ErrorError(err)
+			fails = append(fails, "vg-"+vg)
+		} else {
+			log.Debug("Volume group %q deactivated", vg)
+		}
+	}
+	activeVolumeGroups = nil
+
+	for _, array := range activeRaidArrays {
+		if err := stopRaidArray(array); err != nil {
+			err = fmt.Errorf("stop raid array %s: %v", array, err)
+			log.ErrorError(err)
+			fails = append(fails, "md-"+array)
+		} else {
+			log.Debug("Raid array %q stopped", array)
+		}
+	}
+	activeRaidArrays = nil
 
 	for _, point := range mountedEncrypts {
 		if err := unMapEncrypted(point); err != nil {
@@ -156,6 +216,7 @@ func UmountAll() error {
 			log.Debug("Encrypted partition %q unmapped", point)
 		}
 	}
+	mountedEncrypts = nil
 
 	if len(fails) > 0 {
 		mountError = errors.Errorf("Failed to unmount: %v", fails)
@@ -164,40 +225,72 @@ func UmountAll() error {
 	return mountError
 }
 
-// WritePartitionTable writes the defined partitions to the actual block device
-func (bd *BlockDevice) WritePartitionTable(legacyBios bool) error {
-	if bd.Type != BlockDeviceTypeDisk && bd.Type != BlockDeviceTypeLoop {
-		return errors.Errorf("Type is partition, disk required")
+// getPartOps returns the block device operations used to partition bd, lvm2
+// physical volumes and raid members carry no file system of their own
+func (bd *BlockDevice) getPartOps() (*blockDeviceOps, bool) {
+	if bd.Type == BlockDeviceTypeLVM2Group {
+		return lvmOps, true
 	}
 
-	mesg := fmt.Sprintf("Writing partition table to: %s", bd.Name)
-	prg := progress.NewLoop(mesg)
-	log.Info(mesg)
-	args := []string{
-		"parted",
-		"-s",
-		bd.GetDeviceFile(),
-		"mklabel",
-		"gpt",
+	if bd.Type == BlockDeviceTypeRaidMember {
+		return raidOps, true
 	}
 
-	err := cmd.RunAndLog(args...)
-	if err != nil {
-		return errors.Wrap(err)
+	op, found := bdOps[bd.FsType]
+	return op, found
+}
+
+// ResolveDiskRelativeSizes resolves the bd's children relative sizes, if the disk
+// size was not declared it's queried from the actual block device
+func (bd *BlockDevice) ResolveDiskRelativeSizes() error {
+	if bd.Size == 0 {
+		w := bytes.NewBuffer(nil)
+
+		if err := cmd.Run(w, "blockdev", "--getsize64", bd.GetDeviceFile()); err != nil {
+			return errors.Errorf("%s", w.String())
+		}
+
+		size, err := strconv.ParseUint(strings.TrimSpace(w.String()), 10, 64)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		bd.Size = size
 	}
 
-	args = []string{
-		"parted",
-		"-a",
-		"optimal",
-		bd.GetDeviceFile(),
-		"--script",
+	return bd.ResolveRelativeSizes()
+}
+
+// partitionTableCommands holds the commands writing a disk's partition table
+type partitionTableCommands struct {
+	mklabel   []string   // creates the gpt label
+	mkpart    []string   // creates all the partitions
+	typecodes [][]string // sets the partitions type guid
+	boot      []string   // sets the boot partition flag, may be nil
+}
+
+// getPartitionTableCommands computes the commands writing the bd's partition table
+func (bd *BlockDevice) getPartitionTableCommands(legacyBios bool) (*partitionTableCommands, error) {
+	res := &partitionTableCommands{
+		mklabel: []string{
+			"parted",
+			"-s",
+			bd.GetDeviceFile(),
+			"mklabel",
+			"gpt",
+		},
+		mkpart: []string{
+			"parted",
+			"-a",
+			"optimal",
+			bd.GetDeviceFile(),
+			"--script",
+		},
 	}
 
 	var start uint64
 	bootPartition := -1
 	bootStyle := "boot"
-	guids := map[int]string{}
 
 	for idx, curr := range bd.Children {
 		// We have a /boot partition, use this
@@ -210,19 +303,16 @@ func (bd *BlockDevice) WritePartitionTable(legacyBios bool) error {
 	}
 
 	for idx, curr := range bd.Children {
-		var cmd string
-		var guid string
-
-		op, found := bdOps[curr.FsType]
+		op, found := curr.getPartOps()
 		if !found {
-			return errors.Errorf("No makePartCommand() implementation for: %s",
+			return nil, errors.Errorf("No makePartCommand() implementation for: %s",
 				curr.FsType)
 		}
 
 		end := start + (uint64(curr.Size) >> 20)
-		cmd, err = op.makePartCommand(curr, start, end)
+		cmd, err := op.makePartCommand(curr, start, end)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if curr.MountPoint == "/" {
@@ -233,57 +323,86 @@ func (bd *BlockDevice) WritePartitionTable(legacyBios bool) error {
 			}
 		}
 
-		guid, err = curr.getGUID()
+		guid, err := curr.getGUID()
 		if err != nil {
 			log.Warning("%s", err)
 		}
 
-		if curr.FsType != "swap" || curr.Type != BlockDeviceTypeCrypt {
-			guids[idx+1] = guid
+		if guid != "none" && (curr.FsType != "swap" || curr.Type != BlockDeviceTypeCrypt) {
+			res.typecodes = append(res.typecodes, []string{
+				"sgdisk",
+				bd.GetDeviceFile(),
+				fmt.Sprintf("--typecode=%d:%s", idx+1, guid),
+			})
 		}
 
-		args = append(args, cmd)
+		res.mkpart = append(res.mkpart, cmd)
 		start = end
 	}
 
-	err = cmd.RunAndLog(args...)
+	if bootPartition != -1 {
+		res.boot = []string{
+			"parted",
+			bd.GetDeviceFile(),
+			fmt.Sprintf("set %d %s on", bootPartition, bootStyle),
+		}
+	}
+
+	return res, nil
+}
+
+// WritePartitionTable writes the defined partitions to the actual block device
+func (bd *BlockDevice) WritePartitionTable(legacyBios bool) error {
+	if bd.Type != BlockDeviceTypeDisk && bd.Type != BlockDeviceTypeLoop {
+		return errors.Errorf("Type is partition, disk required")
+	}
+
+	// keep the partition table untouched when reusing existing partitions
+	if bd.HasExistingPartitions() {
+		log.Info("Reusing existing partitions of: %s", bd.Name)
+		return nil
+	}
+
+	if bd.HasRelativeSizes() {
+		if err := bd.ResolveDiskRelativeSizes(); err != nil {
+			return err
+		}
+	}
+
+	mesg := fmt.Sprintf("Writing partition table to: %s", bd.Name)
+	prg := progress.NewLoop(mesg)
+	log.Info(mesg)
+
+	pt, err := bd.getPartitionTableCommands(legacyBios)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.RunAndLog(pt.mklabel...)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	err = cmd.RunAndLog(pt.mkpart...)
 	if err != nil {
 		return errors.Wrap(err)
 	}
 	prg.Success()
 
 	msg := "Adjusting filesystem configurations"
-	prg = progress.MultiStep(len(guids), msg)
+	prg = progress.MultiStep(len(pt.typecodes), msg)
 	log.Info(msg)
-	cnt := 1
-	for idx, guid := range guids {
-		if guid == "none" {
-			continue
-		}
-
-		args = []string{
-			"sgdisk",
-			bd.GetDeviceFile(),
-			fmt.Sprintf("--typecode=%d:%s", idx, guid),
-		}
-
+	for idx, args := range pt.typecodes {
 		err = cmd.RunAndLog(args...)
 		if err != nil {
 			return errors.Wrap(err)
 		}
 
-		prg.Partial(cnt)
-		cnt = cnt + 1
+		prg.Partial(idx + 1)
 	}
 
-	if bootPartition != -1 {
-		args = []string{
-			"parted",
-			bd.GetDeviceFile(),
-			fmt.Sprintf("set %d %s on", bootPartition, bootStyle),
-		}
-
-		err = cmd.RunAndLog(args...)
+	if pt.boot != nil {
+		err = cmd.RunAndLog(pt.boot...)
 		if err != nil {
 			return errors.Wrap(err)
 		}
@@ -294,7 +413,9 @@ func (bd *BlockDevice) WritePartitionTable(legacyBios bool) error {
 		return err
 	}
 
-	time.Sleep(time.Duration(4) * time.Second)
+	if !planMode {
+		time.Sleep(partitionSettleTime)
+	}
 
 	prg.Success()
 
@@ -321,7 +442,7 @@ func MountMetaFs(rootDir string) error {
 	return nil
 }
 
-func mountFs(device string, mPointPath string, fsType string, flags uintptr) error {
+func mountFs(device string, mPointPath string, fsType string, flags uintptr, data string) error {
 	var err error
 
 	if _, err = os.Stat(mPointPath); os.IsNotExist(err) {
@@ -330,7 +451,7 @@ func mountFs(device string, mPointPath string, fsType string, flags uintptr) err
 		}
 	}
 
-	if err = syscall.Mount(device, mPointPath, fsType, flags, ""); err != nil {
+	if err = mount(device, mPointPath, fsType, flags, data); err != nil {
 		return errors.Errorf("mount %s: %v", mPointPath, err)
 	}
 	log.Debug("Mounted ok: %s", mPointPath)
@@ -340,22 +461,56 @@ func mountFs(device string, mPointPath string, fsType string, flags uintptr) err
 	return err
 }
 
+// mount mounts device at mPointPath, in plan mode with the equivalent mount(8) command
+func mount(device string, mPointPath string, fsType string, flags uintptr, data string) error {
+	if !planMode {
+		return syscall.Mount(device, mPointPath, fsType, flags, data)
+	}
+
+	args := []string{"mount", "--bind", device, mPointPath}
+	if flags&syscall.MS_BIND == 0 {
+		args = []string{"mount", "-t", fsType, "-o", formatMountOptions(flags, data),
+			device, mPointPath}
+	}
+
+	return cmd.RunAndLog(args...)
+}
+
+// unmount unmounts mPointPath, in plan mode with the equivalent umount(8) command
+func unmount(mPointPath string, flags int) error {
+	if !planMode {
+		return syscall.Unmount(mPointPath, flags)
+	}
+
+	args := []string{"umount"}
+
+	if flags&syscall.MNT_FORCE != 0 {
+		args = append(args, "--force")
+	}
+
+	if flags&syscall.MNT_DETACH != 0 {
+		args = append(args, "--lazy")
+	}
+
+	return cmd.RunAndLog(append(args, mPointPath)...)
+}
+
 func mountDevFs(rootDir string) error {
 	mPointPath := filepath.Join(rootDir, "dev")
 
-	return mountFs("/dev", mPointPath, "devtmpfs", syscall.MS_BIND)
+	return mountFs("/dev", mPointPath, "devtmpfs", syscall.MS_BIND, "")
 }
 
 func mountSysFs(rootDir string) error {
 	mPointPath := filepath.Join(rootDir, "sys")
 
-	return mountFs("/sys", mPointPath, "sysfs", syscall.MS_BIND)
+	return mountFs("/sys", mPointPath, "sysfs", syscall.MS_BIND, "")
 }
 
 func mountProcFs(rootDir string) error {
 	mPointPath := filepath.Join(rootDir, "proc")
 
-	return mountFs("/proc", mPointPath, "proc", syscall.MS_BIND)
+	return mountFs("/proc", mPointPath, "proc", syscall.MS_BIND, "")
 }
 
 func getMakeFsLabel(bd *BlockDevice) []string {
@@ -408,29 +563,29 @@ func commonMakePartCommand(bd *BlockDevice, start uint64, end uint64) (string, e
 	return strings.Join(args, " "), nil
 }
 
-func makeEncryptedSwap(bd *BlockDevice) error {
-
-	args := []string{
-		"wipefs",
-		bd.GetDeviceFile(),
-	}
-
-	err := cmd.RunAndLog(args...)
-	if err != nil {
-		return errors.Wrap(err)
-	}
-
-	args = []string{
-		"mkfs.ext2",
-		"-L",
-		filepath.Base(bd.GetMappedDeviceFile()),
-		bd.GetDeviceFile(),
-		"1M",
+// getEncryptedSwapCommands returns the commands preparing an encrypted swap partition,
+// a tiny labeled ext2 is used to identify the partition; it's overwritten on boot
+func getEncryptedSwapCommands(bd *BlockDevice) [][]string {
+	return [][]string{
+		{
+			"wipefs",
+			bd.GetDeviceFile(),
+		},
+		{
+			"mkfs.ext2",
+			"-L",
+			filepath.Base(bd.GetMappedDeviceFile()),
+			bd.GetDeviceFile(),
+			"1M",
+		},
 	}
+}
 
-	err = cmd.RunAndLog(args...)
-	if err != nil {
-		return errors.Wrap(err)
+func makeEncryptedSwap(bd *BlockDevice) error {
+	for _, args := range getEncryptedSwapCommands(bd) {
+		if err := cmd.RunAndLog(args...); err != nil {
+			return errors.Wrap(err)
+		}
 	}
 
 	return nil
@@ -552,18 +707,41 @@ func DetachLoopDevice(file string) {
 	_ = cmd.RunAndLog(args...)
 }
 
-// GenerateTabFiles creates the /etc mounting files if needed
+// GenerateTabFiles creates the /etc mounting files if needed, medias may also
+// contain the block devices of raid arrays (see RaidArray.Device())
 func GenerateTabFiles(rootDir string, medias []*BlockDevice) error {
 	var crypttab []string
 	var fstab []string
 	var errFound bool
 
 	for _, curr := range medias {
+		// raid arrays are mounted as a whole
+		if curr.isRaid() {
+			fstab = append(fstab, curr.getRaidTab()...)
+			continue
+		}
+
 		for _, ch := range curr.Children {
 			// Handle Encrypted partitions
 			var ctab []string
 			var ftab []string
 
+			if ch.Type == BlockDeviceTypeLVM2Group {
+				fstab = append(fstab, ch.getLogicalVolumesTab()...)
+				continue
+			}
+
+			// btrfs subvolumes are mounted by subvol= option
+			if len(ch.Subvolumes) > 0 {
+				if ch.Type == BlockDeviceTypeCrypt {
+					crypttab = append(crypttab, strings.Join([]string{
+						filepath.Base(ch.MappedName), ch.GetDeviceID()}, " "))
+				}
+
+				fstab = append(fstab, ch.getSubvolumesTab()...)
+				continue
+			}
+
 			if ch.Type == BlockDeviceTypeCrypt {
 				if ch.FsType == "swap" {
 					ctab = append(ctab, filepath.Base(ch.MappedName), ch.GetDeviceID(),
@@ -580,6 +758,19 @@ func GenerateTabFiles(rootDir string, medias []*BlockDevice) error {
 							ch.FsType, "defaults", "0", "2")
 					}
 				}
+			} else if ch.Existing {
+				// existing partitions may not carry the gpt auto generator's guids
+				if ch.FsType == "swap" {
+					ftab = append(ftab, ch.GetDeviceID(), "none", "swap", "defaults", "0", "0")
+				} else if ch.MountPoint != "" {
+					pass := "2"
+					if ch.MountPoint == "/" {
+						pass = "1"
+					}
+
+					ftab = append(ftab, ch.GetDeviceID(), ch.MountPoint,
+						ch.FsType, "defaults", "0", pass)
+				}
 			} else {
 				if !ch.isStandardMount() && ch.MountPoint != "" {
 					ftab = append(ftab, ch.GetDeviceID(), ch.MountPoint,
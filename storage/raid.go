@@ -0,0 +1,352 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// A RaidArray describes a mdadm software raid array built from partitions of one
+// or more target medias
+type RaidArray struct {
+	Name       string       `yaml:"name,omitempty"`
+	Level      string       `yaml:"level,omitempty"`
+	Members    []string     `yaml:"members,omitempty,flow"`
+	FsType     string       `yaml:"fstype,omitempty"`
+	MountPoint string       `yaml:"mountpoint,omitempty"`
+	Label      string       `yaml:"label,omitempty"`
+	Options    string       `yaml:"options,omitempty"`
+	device     *BlockDevice // the array's block device, allocated by Device()
+}
+
+const (
+	// RaidRequiredBundle the bundle needed if software raid arrays are used
+	RaidRequiredBundle = "storage-utils"
+
+	// MdadmConfFile is the mdadm configuration file written to the target
+	MdadmConfFile = "mdadm.conf"
+)
+
+var (
+	raidOps = &blockDeviceOps{nil, nil, raidMakePartCommand}
+
+	raidLevelMap = map[string]BlockDeviceType{
+		"raid1":  BlockDeviceTypeRaid1,
+		"raid10": BlockDeviceTypeRaid10,
+	}
+
+	// the minimum number of members for a given raid level
+	raidMinMembersMap = map[string]int{
+		"raid1":  2,
+		"raid10": 2,
+	}
+
+	activeRaidArrays []string
+)
+
+// Device returns the block device representing the assembled array, the returned
+// device is suitable for MakeFs() and Mount()
+func (ra *RaidArray) Device() *BlockDevice {
+	if ra.device == nil {
+		ra.device = &BlockDevice{
+			Name:       filepath.Join("md", ra.Name),
+			FsType:     ra.FsType,
+			MountPoint: ra.MountPoint,
+			Label:      ra.Label,
+			Type:       raidLevelMap[ra.Level],
+			options:    ra.Options,
+		}
+	}
+
+	return ra.device
+}
+
+// isRaid returns true if bd is an assembled software raid array
+func (bd *BlockDevice) isRaid() bool {
+	return bd.Type == BlockDeviceTypeRaid1 || bd.Type == BlockDeviceTypeRaid10
+}
+
+// findRaidMember looks up a raid member partition named name in the medias
+func findRaidMember(medias []*BlockDevice, name string) *BlockDevice {
+	for _, curr := range medias {
+		for _, ch := range curr.Children {
+			if ch.Name == name && ch.Type == BlockDeviceTypeRaidMember {
+				return ch
+			}
+		}
+	}
+
+	return nil
+}
+
+// Size returns the usable size of the array built from the medias' members, 0 if
+// any member size is not known
+func (ra *RaidArray) Size(medias []*BlockDevice) uint64 {
+	var min uint64
+
+	for _, member := range ra.Members {
+		bd := findRaidMember(medias, member)
+		if bd == nil || bd.Size == 0 {
+			return 0
+		}
+
+		if min == 0 || bd.Size < min {
+			min = bd.Size
+		}
+	}
+
+	// raid10 stripes over mirrored pairs
+	if ra.Level == "raid10" {
+		return min * uint64(len(ra.Members)) / 2
+	}
+
+	return min
+}
+
+// Validate checks the array definition and its members against the target medias
+func (ra *RaidArray) Validate(medias []*BlockDevice) error {
+	if ra.Name == "" {
+		return errors.Errorf("Raid array name is required")
+	}
+
+	if strings.Contains(ra.Name, "/") {
+		return errors.Errorf("Raid array %s: invalid name", ra.Name)
+	}
+
+	min, ok := raidMinMembersMap[ra.Level]
+	if !ok {
+		return errors.Errorf("Raid array %s: unsupported raid level: %q", ra.Name, ra.Level)
+	}
+
+	if len(ra.Members) < min {
+		return errors.Errorf("Raid array %s: %s requires at least %d members",
+			ra.Name, ra.Level, min)
+	}
+
+	if _, found := bdOps[ra.FsType]; !found {
+		return errors.Errorf("Raid array %s: unsupported file system: %q", ra.Name, ra.FsType)
+	}
+
+	if ra.MountPoint == "/boot" {
+		return errors.Errorf("Raid array %s: /boot is not supported on a raid array", ra.Name)
+	}
+
+	for _, member := range ra.Members {
+		if findRaidMember(medias, member) == nil {
+			return errors.Errorf("Raid array %s: could not find a linux_raid_member partition named %s",
+				ra.Name, member)
+		}
+	}
+
+	return nil
+}
+
+// ValidateRaidArrays checks if the minimal requirements for an installation are met
+// considering the arrays spanning multiple target medias: the EFI partition may live
+// in any of the medias and the root file system may live in an array
+func ValidateRaidArrays(medias []*BlockDevice, arrays []*RaidArray, legacyBios bool, cryptPass string) error {
+	req := &installRequirements{}
+	used := map[string]string{}
+
+	for _, ra := range arrays {
+		if err := ra.Validate(medias); err != nil {
+			return err
+		}
+
+		for _, member := range ra.Members {
+			if arr, ok := used[member]; ok {
+				return errors.Errorf("Partition %s is a member of both %s and %s raid arrays",
+					member, arr, ra.Name)
+			}
+
+			used[member] = ra.Name
+		}
+
+		if ra.MountPoint == "/" {
+			req.rootPartition = true
+		}
+	}
+
+	for _, curr := range medias {
+		if err := curr.validateChildren(req); err != nil {
+			return err
+		}
+	}
+
+	return req.check(legacyBios, cryptPass)
+}
+
+// raidMakePartCommand creates the partition holding a software raid array member
+func raidMakePartCommand(bd *BlockDevice, start uint64, end uint64) (string, error) {
+	args := []string{
+		"mkpart",
+		"linux-raid",
+		fmt.Sprintf("%dM", start),
+		fmt.Sprintf("%dM", end),
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// getRaidCreateCommand returns the mdadm command creating the array ra out of the
+// members device files
+func (ra *RaidArray) getRaidCreateCommand(members []string) []string {
+	args := []string{
+		"mdadm",
+		"--create",
+		ra.Device().GetDeviceFile(),
+		"--run",
+		"--metadata=1.2",
+		fmt.Sprintf("--name=%s", ra.Name),
+		fmt.Sprintf("--level=%s", strings.TrimPrefix(ra.Level, "raid")),
+		fmt.Sprintf("--raid-devices=%d", len(members)),
+	}
+
+	return append(args, members...)
+}
+
+// getMemberFiles returns the device files of the array's members partitions
+func (ra *RaidArray) getMemberFiles(medias []*BlockDevice) ([]string, error) {
+	members := []string{}
+
+	for _, name := range ra.Members {
+		member := findRaidMember(medias, name)
+		if member == nil {
+			return nil, errors.Errorf("Raid array %s: could not find member %s", ra.Name, name)
+		}
+
+		members = append(members, member.GetDeviceFile())
+	}
+
+	return members, nil
+}
+
+// Create uses mdadm to create (and start) the array out of its members partitions
+func (ra *RaidArray) Create(medias []*BlockDevice) error {
+	members, err := ra.getMemberFiles(medias)
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.RunAndLog(ra.getRaidCreateCommand(members)...); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Debug("Raid array %q created with members: %v", ra.Name, members)
+
+	// Store the array for later stopping
+	activeRaidArrays = append(activeRaidArrays, ra.Device().GetDeviceFile())
+
+	return nil
+}
+
+// Assemble uses mdadm to assemble (and start) the array previously created by Create
+func (ra *RaidArray) Assemble(medias []*BlockDevice) error {
+	members, err := ra.getMemberFiles(medias)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"mdadm", "--assemble", ra.Device().GetDeviceFile()}, members...)
+
+	if err := cmd.RunAndLog(args...); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Debug("Raid array %q assembled with members: %v", ra.Name, members)
+
+	// Store the array for later stopping
+	activeRaidArrays = append(activeRaidArrays, ra.Device().GetDeviceFile())
+
+	return nil
+}
+
+// stopRaidArray uses mdadm to stop a previously created array
+func stopRaidArray(file string) error {
+	args := []string{
+		"mdadm",
+		"--stop",
+		file,
+	}
+
+	if err := cmd.RunAndLog(args...); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// getRaidTab returns the fstab entry for the bd raid array, arrays are referred by
+// their /dev/md/<name> path which is kept stable by mdadm.conf
+func (bd *BlockDevice) getRaidTab() []string {
+	if bd.FsType == "swap" {
+		return []string{strings.Join([]string{bd.GetDeviceFile(), "none", "swap",
+			"defaults", "0", "0"}, " ")}
+	}
+
+	if bd.MountPoint == "" {
+		return []string{}
+	}
+
+	pass := "2"
+	if bd.MountPoint == "/" {
+		pass = "1"
+	}
+
+	return []string{strings.Join([]string{bd.GetDeviceFile(), bd.MountPoint, bd.FsType,
+		"defaults", "0", pass}, " ")}
+}
+
+// GenerateMdadmConf writes the target's /etc/mdadm.conf describing the arrays so
+// they're assembled with the same names on boot
+func GenerateMdadmConf(rootDir string, arrays []*RaidArray) error {
+	if len(arrays) == 0 {
+		return nil
+	}
+
+	lines := []string{"MAILADDR root"}
+
+	for _, ra := range arrays {
+		w := bytes.NewBuffer(nil)
+
+		args := []string{
+			"mdadm",
+			"--detail",
+			"--brief",
+			ra.Device().GetDeviceFile(),
+		}
+
+		if err := cmd.Run(w, args...); err != nil {
+			return errors.Errorf("%s", w.String())
+		}
+
+		for _, line := range strings.Split(w.String(), "\n") {
+			if strings.HasPrefix(line, "ARRAY") {
+				lines = append(lines, strings.TrimSpace(line))
+			}
+		}
+	}
+
+	etcDir := filepath.Join(rootDir, "etc")
+	if err := utils.MkdirAll(etcDir, 0755); err != nil {
+		return err
+	}
+
+	content := strings.Join(lines, "\n") + "\n"
+	if err := ioutil.WriteFile(filepath.Join(etcDir, MdadmConfFile), []byte(content), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
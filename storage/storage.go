@@ -33,16 +33,25 @@ type BlockDevice struct {
 	Serial          string           // device serial number
 	MountPoint      string           // where the device is mounted
 	Label           string           // label for the partition; set with mkfs
+	PartLabel       string           // gpt partition label
+	VolumeGroup     string           // lvm2 volume group name; set for physical volumes
 	Size            uint64           // size of the device
+	RelativeSize    string           // size relative to the disk: "<n>%" or "rest"
+	MinSize         uint64           // lower bound of a relative size
+	MaxSize         uint64           // upper bound of a relative size
 	Type            BlockDeviceType  // device type
 	State           BlockDeviceState // device state (running, live etc)
 	ReadOnly        bool             // read-only device
 	RemovableDevice bool             // removable device
 	Children        []*BlockDevice   // children devices/partitions
+	Subvolumes      []*Subvolume     // btrfs subvolumes
+	Existing        bool             // reuse an existing partition instead of creating it
+	Format          bool             // write a new file system to an existing partition
 	Parent          *BlockDevice     // Parent block device; nil for disk
 	userDefined     bool             // was this value set by user?
 	available       bool             // was it mounted the moment we loaded?
 	options         string           // arbitrary mkfs.* options
+	mountOptions    string           // arbitrary mount options
 }
 
 // Version used for reading and writing YAML
@@ -55,12 +64,19 @@ type blockDeviceYAMLMarshal struct {
 	Serial          string         `yaml:"serial,omitempty"`
 	MountPoint      string         `yaml:"mountpoint,omitempty"`
 	Label           string         `yaml:"label,omitempty"`
+	PartLabel       string         `yaml:"partlabel,omitempty"`
+	VolumeGroup     string         `yaml:"volumeGroup,omitempty"`
 	Size            string         `yaml:"size,omitempty"`
+	MinSize         string         `yaml:"minSize,omitempty"`
+	MaxSize         string         `yaml:"maxSize,omitempty"`
 	ReadOnly        string         `yaml:"ro,omitempty"`
 	RemovableDevice string         `yaml:"rm,omitempty"`
 	Type            string         `yaml:"type,omitempty"`
 	State           string         `yaml:"state,omitempty"`
 	Children        []*BlockDevice `yaml:"children,omitempty"`
+	Subvolumes      []*Subvolume   `yaml:"subvolumes,omitempty"`
+	Existing        bool           `yaml:"existing,omitempty"`
+	Format          bool           `yaml:"format,omitempty"`
 	Options         string         `yaml:"options,omitempty"`
 }
 
@@ -92,6 +108,15 @@ const (
 	// BlockDeviceTypeLoop identifies a BlockDevice as a loop device (created with losetup)
 	BlockDeviceTypeLoop
 
+	// BlockDeviceTypeRaidMember identifies a BlockDevice as a software raid array member
+	BlockDeviceTypeRaidMember
+
+	// BlockDeviceTypeRaid1 identifies a BlockDevice as a raid1 software raid array
+	BlockDeviceTypeRaid1
+
+	// BlockDeviceTypeRaid10 identifies a BlockDevice as a raid10 software raid array
+	BlockDeviceTypeRaid10
+
 	// BlockDeviceTypeUnknown identifies a BlockDevice as unknown
 	BlockDeviceTypeUnknown
 
@@ -106,12 +131,20 @@ const (
 
 	// MinimumPartitionSize is smallest size for any partition
 	MinimumPartitionSize = 1048576
+
+	// RestSize is the relative size of the partitions sharing the disk's remaining space
+	RestSize = "rest"
+
+	// partitionTableOverhead is the disk space reserved for the partitions alignment
+	// and the gpt backup header when resolving relative sizes
+	partitionTableOverhead = 2 * 1048576
 )
 
 var (
 	avBlockDevices      []*BlockDevice
 	lsblkBinary         = "lsblk"
 	storageExp          = regexp.MustCompile(`^([0-9]*(\.)?[0-9]*)([bkmgtp]{1}){0,1}$`)
+	relativeSizeExp     = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?)%$`)
 	labelExp            = regexp.MustCompile(`^([[:word:]-+_]+)$`)
 	mountExp            = regexp.MustCompile(`^(/|(/[[:word:]-+_]+)+)$`)
 	blockDeviceStateMap = map[BlockDeviceState]string{
@@ -127,6 +160,9 @@ var (
 		BlockDeviceTypeRom:        "rom",
 		BlockDeviceTypeLVM2Group:  "LVM2_member",
 		BlockDeviceTypeLVM2Volume: "lvm",
+		BlockDeviceTypeRaidMember: "linux_raid_member",
+		BlockDeviceTypeRaid1:      "raid1",
+		BlockDeviceTypeRaid10:     "raid10",
 		BlockDeviceTypeUnknown:    "",
 	}
 	aliasPrefixTable = map[string]string{
@@ -233,16 +269,28 @@ func (bd *BlockDevice) Clone() *BlockDevice {
 		Serial:          bd.Serial,
 		MountPoint:      bd.MountPoint,
 		Label:           bd.Label,
+		PartLabel:       bd.PartLabel,
+		VolumeGroup:     bd.VolumeGroup,
 		Size:            bd.Size,
+		RelativeSize:    bd.RelativeSize,
+		MinSize:         bd.MinSize,
+		MaxSize:         bd.MaxSize,
 		Type:            bd.Type,
 		State:           bd.State,
 		ReadOnly:        bd.ReadOnly,
 		RemovableDevice: bd.RemovableDevice,
+		Existing:        bd.Existing,
+		Format:          bd.Format,
 		Parent:          bd.Parent,
 		userDefined:     bd.userDefined,
 		available:       bd.available,
 	}
 
+	for _, sv := range bd.Subvolumes {
+		csv := *sv
+		clone.Subvolumes = append(clone.Subvolumes, &csv)
+	}
+
 	clone.Children = []*BlockDevice{}
 
 	for _, curr := range bd.Children {
@@ -306,20 +354,119 @@ func (bd *BlockDevice) GetConfiguredStatus() ConfigStatus {
 	return status
 }
 
+// MountPointSize returns the size of the file system mounted at mnt, the btrfs
+// subvolumes share their partition's size and a logical volume without size fills
+// its volume group. found is false if nothing is mounted at mnt, the size is 0 if
+// it's not known yet i.e a relative size not yet resolved.
+func MountPointSize(medias []*BlockDevice, arrays []*RaidArray, mnt string) (size uint64, found bool) {
+	for _, curr := range medias {
+		for _, ch := range curr.Children {
+			if ch.MountPoint == mnt || ch.hasSubvolumeMountPoint(mnt) {
+				return ch.Size, true
+			}
+
+			if ch.Type != BlockDeviceTypeLVM2Group {
+				continue
+			}
+
+			for _, lv := range ch.Children {
+				if lv.MountPoint != mnt {
+					continue
+				}
+
+				if lv.Size == 0 {
+					return volumeGroupFreeSize(medias, ch.VolumeGroup), true
+				}
+
+				return lv.Size, true
+			}
+		}
+	}
+
+	for _, ra := range arrays {
+		if ra.MountPoint == mnt {
+			return ra.Size(medias), true
+		}
+	}
+
+	return 0, false
+}
+
+// volumeGroupFreeSize returns the size of the vg volume group's physical volumes,
+// across all the medias, not used by its sized logical volumes. It's 0 if a physical
+// volume's size is not known yet
+func volumeGroupFreeSize(medias []*BlockDevice, vg string) uint64 {
+	var total, used uint64
+
+	for _, curr := range medias {
+		for _, ch := range curr.Children {
+			if ch.Type != BlockDeviceTypeLVM2Group || ch.VolumeGroup != vg {
+				continue
+			}
+
+			if ch.Size == 0 {
+				return 0
+			}
+
+			total = total + ch.Size
+
+			for _, lv := range ch.Children {
+				used = used + lv.Size
+			}
+		}
+	}
+
+	if used >= total {
+		return 0
+	}
+
+	return total - used
+}
+
 // FsTypeNotSwap returns true if the file system type is not swap
 func (bd *BlockDevice) FsTypeNotSwap() bool {
 	return bd.FsType != "swap"
 }
 
-// Validate checks if the minimal requirements for a installation is met
-func (bd *BlockDevice) Validate(legacyBios bool, cryptPass string) error {
-	bootPartition := false
-	rootPartition := false
-	encrypted := false
+// installRequirements records which of the installation's minimal requirements the
+// validated block devices fulfill
+type installRequirements struct {
+	bootPartition bool
+	rootPartition bool
+	encrypted     bool
+}
+
+// check returns an error if the minimal requirements for an installation are not met
+func (req *installRequirements) check(legacyBios bool, cryptPass string) error {
+	if !req.bootPartition && !legacyBios {
+		return errors.Errorf("Could not find a suitable EFI partition")
+	}
+
+	if !req.rootPartition {
+		return errors.Errorf("Could not find a root partition")
+	}
+
+	if req.encrypted && cryptPass == "" {
+		return errors.Errorf("Encrypted file system enabled, but missing passphase")
+	}
+
+	return nil
+}
+
+// validateChildren validates the bd's partitions and records the requirements they
+// fulfill in req
+func (bd *BlockDevice) validateChildren(req *installRequirements) error {
+	if err := bd.validateRelativeSizes(); err != nil {
+		return err
+	}
+
+	if err := bd.validateExistingPartitions(); err != nil {
+		return err
+	}
 
 	for _, ch := range bd.Children {
 		if ch.FsType == "vfat" && ch.MountPoint == "/boot" {
-			bootPartition = true
+			req.bootPartition = true
 
 			if ch.Type == BlockDeviceTypeCrypt {
 				return errors.Errorf("Encryption of /boot is not supported")
@@ -327,29 +474,46 @@ func (bd *BlockDevice) Validate(legacyBios bool, cryptPass string) error {
 		}
 
 		if ch.MountPoint == "/" {
-			rootPartition = true
+			req.rootPartition = true
 		}
 
-		if ch.Type == BlockDeviceTypeCrypt && ch.FsTypeNotSwap() {
-			encrypted = true
+		if len(ch.Subvolumes) > 0 {
+			if err := ch.validateSubvolumes(); err != nil {
+				return err
+			}
+
+			req.rootPartition = req.rootPartition || ch.hasSubvolumeMountPoint("/")
 		}
-	}
 
-	if !bootPartition && !legacyBios {
-		return errors.Errorf("Could not find a suitable EFI partition")
-	}
+		if ch.Type == BlockDeviceTypeLVM2Group {
+			if err := ch.validateLogicalVolumes(); err != nil {
+				return err
+			}
 
-	if !rootPartition {
-		return errors.Errorf("Could not find a root partition")
+			for _, lv := range ch.Children {
+				if lv.MountPoint == "/" {
+					req.rootPartition = true
+				}
+			}
+		}
 	}
 
-	if encrypted && cryptPass == "" {
-		return errors.Errorf("Encrypted file system enabled, but missing passphase")
-	}
+	req.encrypted = req.encrypted || bd.EncryptionRequiresPassphrase()
 
 	return nil
 }
 
+// Validate checks if the minimal requirements for a installation is met
+func (bd *BlockDevice) Validate(legacyBios bool, cryptPass string) error {
+	req := &installRequirements{}
+
+	if err := bd.validateChildren(req); err != nil {
+		return err
+	}
+
+	return req.check(legacyBios, cryptPass)
+}
+
 // RemoveChild removes a partition from disk block device
 func (bd *BlockDevice) RemoveChild(child *BlockDevice) {
 	copyBd := bd.Clone()
@@ -477,6 +641,157 @@ func (bd *BlockDevice) FreeSpace() (uint64, error) {
 	return bd.Size - total, nil
 }
 
+// HasRelativeSizes returns true if any of the bd's children has a relative size
+func (bd *BlockDevice) HasRelativeSizes() bool {
+	for _, ch := range bd.Children {
+		if ch.RelativeSize != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// boundSize applies the bd's min and max bounds to size
+func (bd *BlockDevice) boundSize(size uint64) uint64 {
+	if bd.MaxSize > 0 && size > bd.MaxSize {
+		size = bd.MaxSize
+	}
+
+	if size < bd.MinSize {
+		size = bd.MinSize
+	}
+
+	return size
+}
+
+// validateRelativeSizes checks the relative sizes and bounds of the bd's children, the
+// sum of percentages must not exceed the disk. If the disk size is known the relative
+// sizes are also resolved, without being applied, to check they fit in the disk
+func (bd *BlockDevice) validateRelativeSizes() error {
+	var total float64
+
+	for _, ch := range bd.Children {
+		if ch.MaxSize > 0 && ch.MinSize > ch.MaxSize {
+			return errors.Errorf("%s: minimum size %d larger than maximum size: %d",
+				ch.Name, ch.MinSize, ch.MaxSize)
+		}
+
+		if ch.RelativeSize == "" {
+			continue
+		}
+
+		pct, err := parseRelativeSize(ch.RelativeSize)
+		if err != nil {
+			return errors.Errorf("%s: %v", ch.Name, err)
+		}
+
+		total = total + pct
+	}
+
+	if total > 100 {
+		return errors.Errorf("%s: partitions relative sizes sum up to %.2f%%", bd.Name, total)
+	}
+
+	if bd.Size > 0 && bd.HasRelativeSizes() {
+		if _, err := bd.relativeSizes(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ResolveRelativeSizes computes the size of the children declared with a relative size:
+// percentages are relative to the disk size less the partition table overhead and the "rest" partitions equally share the
+// space left by all the other partitions, the min and max bounds are applied to the
+// computed sizes
+func (bd *BlockDevice) ResolveRelativeSizes() error {
+	sizes, err := bd.relativeSizes()
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range bd.Children {
+		if ch.RelativeSize != "" {
+			ch.Size = sizes[ch]
+			log.Debug("%s: relative size %q resolved to %d bytes", ch.Name, ch.RelativeSize, ch.Size)
+		}
+	}
+
+	return nil
+}
+
+// usableSize returns the disk size available to the partitions, the percentages are
+// relative to it so they can sum up to 100%
+func (bd *BlockDevice) usableSize() uint64 {
+	if bd.Size <= partitionTableOverhead {
+		return 0
+	}
+
+	return bd.Size - partitionTableOverhead
+}
+
+// relativeSizes returns the sizes the bd's children with a relative size resolve to,
+// a resolved size must not be smaller than MinimumPartitionSize and all the partitions
+// plus the partition table overhead must fit in the disk
+func (bd *BlockDevice) relativeSizes() (map[*BlockDevice]uint64, error) {
+	if bd.Size == 0 {
+		return nil, errors.Errorf("%s: unknown disk size, can not resolve relative sizes", bd.Name)
+	}
+
+	res := map[*BlockDevice]uint64{}
+	used := uint64(partitionTableOverhead)
+	rest := []*BlockDevice{}
+
+	for _, ch := range bd.Children {
+		if ch.RelativeSize == "" {
+			used = used + ch.Size
+			continue
+		}
+
+		pct, err := parseRelativeSize(ch.RelativeSize)
+		if err != nil {
+			return nil, errors.Errorf("%s: %v", ch.Name, err)
+		}
+
+		if ch.RelativeSize == RestSize {
+			rest = append(rest, ch)
+			continue
+		}
+
+		res[ch] = ch.boundSize(uint64(float64(bd.usableSize()) * pct / 100))
+		used = used + res[ch]
+	}
+
+	if len(rest) > 0 {
+		var free uint64
+
+		if used < bd.Size {
+			free = (bd.Size - used) / uint64(len(rest))
+		}
+
+		for _, ch := range rest {
+			res[ch] = ch.boundSize(free)
+			used = used + res[ch]
+		}
+	}
+
+	for _, ch := range bd.Children {
+		if ch.RelativeSize != "" && res[ch] < MinimumPartitionSize {
+			return nil, errors.Errorf("%s: relative size %q resolves to %d bytes, less than the minimum partition size: %d",
+				ch.Name, ch.RelativeSize, res[ch], MinimumPartitionSize)
+		}
+	}
+
+	if used > bd.Size {
+		return nil, errors.Errorf("%s: Partition Sizes %d larger than Device Size: %d",
+			bd.Name, used, bd.Size)
+	}
+
+	return res, nil
+}
+
 // HumanReadableSizeWithUnitAndPrecision converts the size representation in bytes to the
 // closest human readable format i.e 10M, 1G, 2T etc with a forced unit and precision
 func (bd *BlockDevice) HumanReadableSizeWithUnitAndPrecision(unit string, precision int) (string, error) {
@@ -778,17 +1093,17 @@ func IsValidMount(str string) string {
 }
 
 // IsValidSize returns an empty string if
-// -- size is suffixed with B, K, M, G, T, P
+// -- size is suffixed with B, K, M, G, T, P or is a relative size (i.e 50% or rest)
 // -- size is greater than MinimumPartitionSize
 // -- size is less than (or equal to) current size + free space
 func (bd *BlockDevice) IsValidSize(str string) string {
 	str = strings.ToLower(str)
 
-	if !storageExp.MatchString(str) {
-		return "Invalid size, may only be suffixed by: B, K, M, G, T or P"
+	if !storageExp.MatchString(str) && !IsRelativeSize(str) {
+		return "Invalid size, may only be suffixed by: B, K, M, G, T, P or %"
 	}
 
-	size, err := ParseVolumeSize(str)
+	size, err := bd.ParsePartitionSize(str)
 	if err != nil {
 		return "Invalid size"
 	} else if size < MinimumPartitionSize {
@@ -805,6 +1120,63 @@ func (bd *BlockDevice) IsValidSize(str string) string {
 	return ""
 }
 
+// ParsePartitionSize parses str as ParseVolumeSize() does but also accepts sizes
+// relative to the bd's parent disk: a percentage of the disk size or "rest" meaning
+// the current size plus the disk's free space
+func (bd *BlockDevice) ParsePartitionSize(str string) (uint64, error) {
+	if !IsRelativeSize(str) {
+		return ParseVolumeSize(str)
+	}
+
+	if bd.Parent == nil {
+		return 0, errors.Errorf("%s: relative size requires a parent disk", bd.Name)
+	}
+
+	pct, err := parseRelativeSize(str)
+	if err != nil {
+		return 0, err
+	}
+
+	if pct == 0 {
+		return bd.MaxParitionSize(), nil
+	}
+
+	return uint64(float64(bd.Parent.usableSize()) * pct / 100), nil
+}
+
+// IsRelativeSize returns true if str is a size relative to the disk size, either
+// a percentage (i.e 50%) or "rest"
+func IsRelativeSize(str string) bool {
+	str = strings.ToLower(strings.TrimSpace(str))
+
+	return str == RestSize || relativeSizeExp.MatchString(str)
+}
+
+// parseRelativeSize returns the percentage represented by a relative size, "rest"
+// has no percentage of its own and results in 0
+func parseRelativeSize(str string) (float64, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+
+	if str == RestSize {
+		return 0, nil
+	}
+
+	if !relativeSizeExp.MatchString(str) {
+		return 0, errors.Errorf("Invalid relative size: %q", str)
+	}
+
+	pct, err := strconv.ParseFloat(relativeSizeExp.ReplaceAllString(str, `$1`), 64)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	if pct <= 0 || pct > 100 {
+		return 0, errors.Errorf("Invalid relative size: %q, must be in the (0, 100] range", str)
+	}
+
+	return pct, nil
+}
+
 // ParseVolumeSize will parse a string formatted (1M, 10G, 2T) size and return its representation
 // in bytes
 func ParseVolumeSize(str string) (uint64, error) {
@@ -965,6 +1337,15 @@ func (bd *BlockDevice) UnmarshalJSON(b []byte) error {
 			}
 
 			bd.Label = label
+		case "partlabel":
+			var label string
+
+			label, err = getNextStrToken(dec, "partlabel")
+			if err != nil {
+				return err
+			}
+
+			bd.PartLabel = label
 		case "ro":
 			bd.ReadOnly, err = getNextBoolToken(dec, "ro")
 			if err != nil {
@@ -1000,12 +1381,26 @@ func (bd *BlockDevice) MarshalYAML() (interface{}, error) {
 	bdm.Serial = bd.Serial
 	bdm.MountPoint = bd.MountPoint
 	bdm.Label = bd.Label
+	bdm.PartLabel = bd.PartLabel
+	bdm.VolumeGroup = bd.VolumeGroup
 	bdm.Size = strconv.FormatUint(bd.Size, 10)
+	if bd.RelativeSize != "" {
+		bdm.Size = bd.RelativeSize
+	}
+	if bd.MinSize > 0 {
+		bdm.MinSize = strconv.FormatUint(bd.MinSize, 10)
+	}
+	if bd.MaxSize > 0 {
+		bdm.MaxSize = strconv.FormatUint(bd.MaxSize, 10)
+	}
 	bdm.ReadOnly = strconv.FormatBool(bd.ReadOnly)
 	bdm.RemovableDevice = strconv.FormatBool(bd.RemovableDevice)
 	bdm.Type = bd.Type.String()
 	bdm.State = bd.State.String()
 	bdm.Children = bd.Children
+	bdm.Subvolumes = bd.Subvolumes
+	bdm.Existing = bd.Existing
+	bdm.Format = bd.Format
 	bdm.Options = bd.options
 
 	return bdm, nil
@@ -1029,10 +1424,17 @@ func (bd *BlockDevice) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	bd.Serial = unmarshBlockDevice.Serial
 	bd.MountPoint = unmarshBlockDevice.MountPoint
 	bd.Label = unmarshBlockDevice.Label
+	bd.PartLabel = unmarshBlockDevice.PartLabel
+	bd.VolumeGroup = unmarshBlockDevice.VolumeGroup
 	bd.Children = unmarshBlockDevice.Children
+	bd.Subvolumes = unmarshBlockDevice.Subvolumes
+	bd.Existing = unmarshBlockDevice.Existing
+	bd.Format = unmarshBlockDevice.Format
 	bd.options = unmarshBlockDevice.Options
-	// Convert String to Uint64
-	if unmarshBlockDevice.Size != "" {
+	// Convert String to Uint64, relative sizes are resolved later against the disk size
+	if IsRelativeSize(unmarshBlockDevice.Size) {
+		bd.RelativeSize = strings.ToLower(unmarshBlockDevice.Size)
+	} else if unmarshBlockDevice.Size != "" {
 		uSize, err := ParseVolumeSize(unmarshBlockDevice.Size)
 		if err != nil {
 			return err
@@ -1040,6 +1442,22 @@ func (bd *BlockDevice) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		bd.Size = uSize
 	}
 
+	if unmarshBlockDevice.MinSize != "" {
+		uSize, err := ParseVolumeSize(unmarshBlockDevice.MinSize)
+		if err != nil {
+			return err
+		}
+		bd.MinSize = uSize
+	}
+
+	if unmarshBlockDevice.MaxSize != "" {
+		uSize, err := ParseVolumeSize(unmarshBlockDevice.MaxSize)
+		if err != nil {
+			return err
+		}
+		bd.MaxSize = uSize
+	}
+
 	// Map the BlockDeviceType
 	if unmarshBlockDevice.Type != "" {
 		iType, err := parseBlockDeviceType(unmarshBlockDevice.Type)
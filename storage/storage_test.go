@@ -6,13 +6,19 @@ package storage
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
 	"testing"
 	"text/template"
 	"time"
 
+	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/progress"
 	"github.com/clearlinux/clr-installer/utils"
 )
@@ -875,3 +881,687 @@ func TestWriteConfigFiles(t *testing.T) {
 		t.Fatalf("Failed to create directories to write config file: %v\n", err)
 	}
 }
+
+func TestValidateLogicalVolumes(t *testing.T) {
+	tests := []struct {
+		pv    *BlockDevice
+		valid bool
+	}{
+		{&BlockDevice{Name: "sda3", Size: 10 << 30, Type: BlockDeviceTypeLVM2Group, VolumeGroup: "clearvg",
+			Children: []*BlockDevice{
+				{Name: "root", Size: 4 << 30, Type: BlockDeviceTypeLVM2Volume, FsType: "ext4", MountPoint: "/"},
+				{Name: "var", Type: BlockDeviceTypeLVM2Volume, FsType: "ext4", MountPoint: "/var"},
+			}}, true},
+		{&BlockDevice{Name: "sda3", Size: 10 << 30, Type: BlockDeviceTypeLVM2Group,
+			Children: []*BlockDevice{
+				{Name: "root", Size: 4 << 30, Type: BlockDeviceTypeLVM2Volume, FsType: "ext4", MountPoint: "/"},
+			}}, false},
+		{&BlockDevice{Name: "sda3", Size: 10 << 30, Type: BlockDeviceTypeLVM2Group, VolumeGroup: "clearvg"}, false},
+		{&BlockDevice{Name: "sda3", Size: 10 << 30, Type: BlockDeviceTypeLVM2Group, VolumeGroup: "clearvg",
+			Children: []*BlockDevice{
+				{Name: "root", Type: BlockDeviceTypeLVM2Volume, FsType: "ext4", MountPoint: "/"},
+				{Name: "var", Size: 4 << 30, Type: BlockDeviceTypeLVM2Volume, FsType: "ext4", MountPoint: "/var"},
+			}}, false},
+		{&BlockDevice{Name: "sda3", Size: 4 << 30, Type: BlockDeviceTypeLVM2Group, VolumeGroup: "clearvg",
+			Children: []*BlockDevice{
+				{Name: "root", Size: 4 << 30, Type: BlockDeviceTypeLVM2Volume, FsType: "ext4", MountPoint: "/"},
+				{Name: "var", Size: 4 << 30, Type: BlockDeviceTypeLVM2Volume, FsType: "ext4", MountPoint: "/var"},
+			}}, false},
+		{&BlockDevice{Name: "sda3", Size: 10 << 30, Type: BlockDeviceTypeLVM2Group, VolumeGroup: "clearvg",
+			Children: []*BlockDevice{
+				{Name: "root", Size: 4 << 30, Type: BlockDeviceTypePart, FsType: "ext4", MountPoint: "/"},
+			}}, false},
+	}
+
+	for idx, curr := range tests {
+		err := curr.pv.validateLogicalVolumes()
+		if curr.valid && err != nil {
+			t.Fatalf("Test %d should be valid: %s", idx, err)
+		} else if !curr.valid && err == nil {
+			t.Fatalf("Test %d should be INVALID", idx)
+		}
+	}
+}
+
+func TestLogicalVolumeCreateCommand(t *testing.T) {
+	tests := []struct {
+		lv       *BlockDevice
+		expected string
+	}{
+		{&BlockDevice{Name: "root", Size: 4 << 30},
+			"lvcreate --yes --wipesignatures y -n root -L 4294967296b clearvg"},
+		{&BlockDevice{Name: "var"},
+			"lvcreate --yes --wipesignatures y -n var -l 100%FREE clearvg"},
+	}
+
+	for _, curr := range tests {
+		res := strings.Join(getLogicalVolumeCreateCommand("clearvg", curr.lv), " ")
+		if res != curr.expected {
+			t.Fatalf("Invalid lvcreate command: %q, expected: %q", res, curr.expected)
+		}
+	}
+}
+
+func TestWriteLVMConfigFiles(t *testing.T) {
+	bd := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	bd.AddChild(&BlockDevice{FsType: "vfat", MountPoint: "/boot", Type: BlockDeviceTypePart})
+	bd.AddChild(&BlockDevice{Type: BlockDeviceTypeLVM2Group, VolumeGroup: "clearvg",
+		Children: []*BlockDevice{
+			{Name: "root", Type: BlockDeviceTypeLVM2Volume, FsType: "ext4", MountPoint: "/"},
+			{Name: "swap", Type: BlockDeviceTypeLVM2Volume, FsType: "swap"},
+			{Name: "var", Type: BlockDeviceTypeLVM2Volume, FsType: "xfs", MountPoint: "/var"},
+		}})
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, []*BlockDevice{bd}); err != nil {
+		t.Fatalf("Failed to write config files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	expected := "/dev/clearvg/root / ext4 defaults 0 1\n" +
+		"/dev/clearvg/swap none swap defaults 0 0\n" +
+		"/dev/clearvg/var /var xfs defaults 0 2\n"
+
+	if string(content) != expected {
+		t.Fatalf("Invalid fstab content: %q, expected: %q", string(content), expected)
+	}
+}
+
+func newRaidTestMedias() []*BlockDevice {
+	medias := []*BlockDevice{}
+
+	for _, name := range []string{"sda", "sdb"} {
+		bd := &BlockDevice{Name: name, Type: BlockDeviceTypeDisk}
+		bd.AddChild(&BlockDevice{FsType: "vfat", MountPoint: "/boot", Type: BlockDeviceTypePart})
+		bd.AddChild(&BlockDevice{Size: 4 << 30, Type: BlockDeviceTypeRaidMember})
+		medias = append(medias, bd)
+	}
+
+	return medias
+}
+
+func TestValidateRaidArrays(t *testing.T) {
+	tests := []struct {
+		arrays []*RaidArray
+		valid  bool
+	}{
+		{[]*RaidArray{{Name: "root", Level: "raid1", Members: []string{"sda2", "sdb2"},
+			FsType: "ext4", MountPoint: "/"}}, true},
+		{[]*RaidArray{{Name: "root", Level: "raid5", Members: []string{"sda2", "sdb2"},
+			FsType: "ext4", MountPoint: "/"}}, false},
+		{[]*RaidArray{{Name: "root", Level: "raid1", Members: []string{"sda2"},
+			FsType: "ext4", MountPoint: "/"}}, false},
+		{[]*RaidArray{{Name: "root", Level: "raid1", Members: []string{"sda2", "sdc2"},
+			FsType: "ext4", MountPoint: "/"}}, false},
+		{[]*RaidArray{{Name: "root", Level: "raid1", Members: []string{"sda1", "sdb1"},
+			FsType: "ext4", MountPoint: "/"}}, false},
+		{[]*RaidArray{{Name: "root", Level: "raid1", Members: []string{"sda2", "sdb2"},
+			FsType: "ntfs", MountPoint: "/"}}, false},
+		{[]*RaidArray{{Name: "home", Level: "raid1", Members: []string{"sda2", "sdb2"},
+			FsType: "ext4", MountPoint: "/home"}}, false},
+		{[]*RaidArray{
+			{Name: "root", Level: "raid1", Members: []string{"sda2", "sdb2"},
+				FsType: "ext4", MountPoint: "/"},
+			{Name: "home", Level: "raid1", Members: []string{"sda2", "sdb2"},
+				FsType: "ext4", MountPoint: "/home"},
+		}, false},
+	}
+
+	for idx, curr := range tests {
+		err := ValidateRaidArrays(newRaidTestMedias(), curr.arrays, false, "")
+		if curr.valid && err != nil {
+			t.Fatalf("Test %d should be valid: %s", idx, err)
+		} else if !curr.valid && err == nil {
+			t.Fatalf("Test %d should be INVALID", idx)
+		}
+	}
+}
+
+func TestRaidCreateCommand(t *testing.T) {
+	ra := &RaidArray{Name: "root", Level: "raid10", Members: []string{"sda2", "sdb2"}}
+	expected := "mdadm --create /dev/md/root --run --metadata=1.2 --name=root --level=10 " +
+		"--raid-devices=2 /dev/sda2 /dev/sdb2"
+
+	res := strings.Join(ra.getRaidCreateCommand([]string{"/dev/sda2", "/dev/sdb2"}), " ")
+	if res != expected {
+		t.Fatalf("Invalid mdadm command: %q, expected: %q", res, expected)
+	}
+
+	if ra.Device().Type != BlockDeviceTypeRaid10 {
+		t.Fatalf("Invalid raid array device type: %s", ra.Device().Type)
+	}
+}
+
+func TestWriteRaidConfigFiles(t *testing.T) {
+	medias := newRaidTestMedias()
+	ra := &RaidArray{Name: "root", Level: "raid1", Members: []string{"sda2", "sdb2"},
+		FsType: "ext4", MountPoint: "/"}
+	medias = append(medias, ra.Device())
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, medias); err != nil {
+		t.Fatalf("Failed to write config files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	expected := "/dev/md/root / ext4 defaults 0 1\n"
+	if string(content) != expected {
+		t.Fatalf("Invalid fstab content: %q, expected: %q", string(content), expected)
+	}
+}
+
+func TestValidateSubvolumes(t *testing.T) {
+	tests := []struct {
+		bd    *BlockDevice
+		valid bool
+	}{
+		{&BlockDevice{Name: "sda3", FsType: "btrfs", Subvolumes: []*Subvolume{
+			{Name: "@", MountPoint: "/", Options: "compress=zstd"},
+			{Name: "@home", MountPoint: "/home"},
+			{Name: "@snapshots"},
+		}}, true},
+		{&BlockDevice{Name: "sda3", FsType: "ext4", Subvolumes: []*Subvolume{
+			{Name: "@", MountPoint: "/"},
+		}}, false},
+		{&BlockDevice{Name: "sda3", FsType: "btrfs", Subvolumes: []*Subvolume{
+			{MountPoint: "/"},
+		}}, false},
+		{&BlockDevice{Name: "sda3", FsType: "btrfs", Subvolumes: []*Subvolume{
+			{Name: "/@", MountPoint: "/"},
+		}}, false},
+		{&BlockDevice{Name: "sda3", FsType: "btrfs", Subvolumes: []*Subvolume{
+			{Name: "@", MountPoint: "/"},
+			{Name: "@", MountPoint: "/home"},
+		}}, false},
+		{&BlockDevice{Name: "sda3", FsType: "btrfs", Subvolumes: []*Subvolume{
+			{Name: "@", MountPoint: "/"},
+			{Name: "@home", MountPoint: "/"},
+		}}, false},
+		{&BlockDevice{Name: "sda3", FsType: "btrfs", Subvolumes: []*Subvolume{
+			{Name: "@", MountPoint: "home"},
+		}}, false},
+	}
+
+	for idx, curr := range tests {
+		err := curr.bd.validateSubvolumes()
+		if curr.valid && err != nil {
+			t.Fatalf("Test %d should be valid: %s", idx, err)
+		} else if !curr.valid && err == nil {
+			t.Fatalf("Test %d should be INVALID", idx)
+		}
+	}
+}
+
+func TestParseMountOptions(t *testing.T) {
+	tests := []struct {
+		options string
+		flags   uintptr
+		data    string
+	}{
+		{"", syscall.MS_RELATIME, ""},
+		{"defaults", syscall.MS_RELATIME, ""},
+		{"subvol=@,compress=zstd,noatime", syscall.MS_NOATIME, "subvol=@,compress=zstd"},
+		{"subvol=@var,nodev,nosuid", syscall.MS_NODEV | syscall.MS_NOSUID | syscall.MS_RELATIME,
+			"subvol=@var"},
+	}
+
+	for _, curr := range tests {
+		flags, data := parseMountOptions(curr.options)
+		if flags != curr.flags || data != curr.data {
+			t.Fatalf("Invalid mount options for %q: (%d, %q), expected: (%d, %q)",
+				curr.options, flags, data, curr.flags, curr.data)
+		}
+	}
+}
+
+func TestWriteSubvolumesConfigFiles(t *testing.T) {
+	bd := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	bd.AddChild(&BlockDevice{FsType: "vfat", MountPoint: "/boot", Type: BlockDeviceTypePart})
+	bd.AddChild(&BlockDevice{FsType: "btrfs", UUID: "1234", Type: BlockDeviceTypePart,
+		MountPoint: "/srv",
+		Subvolumes: []*Subvolume{
+			{Name: "@", MountPoint: "/", Options: "compress=zstd,noatime"},
+			{Name: "@home", MountPoint: "/home"},
+			{Name: "@snapshots"},
+		}})
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, []*BlockDevice{bd}); err != nil {
+		t.Fatalf("Failed to write config files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	expected := "UUID=1234 /srv btrfs subvolid=5 0 2\n" +
+		"UUID=1234 / btrfs subvol=@,compress=zstd,noatime 0 0\n" +
+		"UUID=1234 /home btrfs subvol=@home 0 0\n"
+
+	if string(content) != expected {
+		t.Fatalf("Invalid fstab content: %q, expected: %q", string(content), expected)
+	}
+
+	devs := bd.Children[1].SubvolumeDevices()
+	if len(devs) != 3 || devs[0].MountPoint != "/srv" || devs[0].mountOptions != "subvolid=5" {
+		t.Fatalf("The top level should be mounted along the subvolumes: %+v", devs)
+	}
+}
+
+func TestResolveRelativeSizes(t *testing.T) {
+	bd := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Size: 100 << 30}
+	boot := &BlockDevice{Name: "sda1", Size: 150 << 20}
+	swap := &BlockDevice{Name: "sda2", RelativeSize: "5%", MaxSize: 4 << 30}
+	root := &BlockDevice{Name: "sda3", RelativeSize: "25%"}
+	home := &BlockDevice{Name: "sda4", RelativeSize: RestSize}
+
+	bd.AddChild(boot)
+	bd.AddChild(swap)
+	bd.AddChild(root)
+	bd.AddChild(home)
+
+	if err := bd.validateRelativeSizes(); err != nil {
+		t.Fatalf("Relative sizes should be valid: %s", err)
+	}
+
+	if err := bd.ResolveRelativeSizes(); err != nil {
+		t.Fatalf("Failed to resolve relative sizes: %s", err)
+	}
+
+	if swap.Size != 4<<30 {
+		t.Fatalf("Invalid swap size: %d, expected: %d", swap.Size, uint64(4<<30))
+	}
+
+	if expected := (bd.Size - partitionTableOverhead) / 4; root.Size != expected {
+		t.Fatalf("Invalid root size: %d, expected: %d", root.Size, expected)
+	}
+
+	expected := bd.Size - boot.Size - swap.Size - root.Size - partitionTableOverhead
+	if home.Size != expected {
+		t.Fatalf("Invalid home size: %d, expected: %d", home.Size, expected)
+	}
+
+	// the percentages are relative to the space left by the partition table
+	valid := []*BlockDevice{
+		{Name: "sdc", Size: 100 << 30, Children: []*BlockDevice{
+			{Name: "sdc1", RelativeSize: "50%"},
+			{Name: "sdc2", RelativeSize: "50%"},
+		}},
+		{Name: "sdc", Size: 10 << 30, Children: []*BlockDevice{
+			{Name: "sdc1", Size: 5 << 30},
+			{Name: "sdc2", RelativeSize: "40%"},
+			{Name: "sdc3", RelativeSize: RestSize},
+		}},
+	}
+
+	for idx, curr := range valid {
+		if err := curr.validateRelativeSizes(); err != nil {
+			t.Fatalf("Layout %d should be valid: %s", idx, err)
+		}
+	}
+
+	small := &BlockDevice{Name: "sdb", Type: BlockDeviceTypeDisk, Size: 10 << 30}
+	small.AddChild(&BlockDevice{Name: "sdb1", RelativeSize: "50%"})
+	small.AddChild(&BlockDevice{Name: "sdb2", RelativeSize: RestSize, MinSize: 8 << 30})
+
+	if err := small.ResolveRelativeSizes(); err == nil {
+		t.Fatalf("Should fail to resolve sizes larger than the disk")
+	}
+}
+
+func TestInvalidRelativeSizes(t *testing.T) {
+	tests := []*BlockDevice{
+		{Name: "sda", Children: []*BlockDevice{
+			{Name: "sda1", RelativeSize: "60%"},
+			{Name: "sda2", RelativeSize: "50%"},
+		}},
+		{Name: "sda", Children: []*BlockDevice{
+			{Name: "sda1", RelativeSize: "0%"},
+		}},
+		{Name: "sda", Children: []*BlockDevice{
+			{Name: "sda1", RelativeSize: RestSize, MinSize: 8 << 30, MaxSize: 4 << 30},
+		}},
+		// no space left for the rest partition
+		{Name: "sda", Size: 10 << 30, Children: []*BlockDevice{
+			{Name: "sda1", Size: 10<<30 - partitionTableOverhead},
+			{Name: "sda2", RelativeSize: RestSize},
+		}},
+		// the fixed size partition doesn't leave room for half of the usable space
+		{Name: "sda", Size: 10 << 30, Children: []*BlockDevice{
+			{Name: "sda1", Size: 5 << 30},
+			{Name: "sda2", RelativeSize: "50%"},
+		}},
+		// resolves below the minimum partition size
+		{Name: "sda", Size: 10 << 30, Children: []*BlockDevice{
+			{Name: "sda1", RelativeSize: "0.001%"},
+		}},
+	}
+
+	for idx, curr := range tests {
+		if err := curr.validateRelativeSizes(); err == nil {
+			t.Fatalf("Test %d should be INVALID", idx)
+		}
+	}
+
+	for _, str := range []string{"50%", "12.5%", "rest", "REST"} {
+		if !IsRelativeSize(str) {
+			t.Fatalf("%q should be a relative size", str)
+		}
+	}
+
+	for _, str := range []string{"50", "10G", "%", "rest%"} {
+		if IsRelativeSize(str) {
+			t.Fatalf("%q should NOT be a relative size", str)
+		}
+	}
+}
+
+func TestValidateExistingPartitions(t *testing.T) {
+	tests := []struct {
+		bd    *BlockDevice
+		valid bool
+	}{
+		{&BlockDevice{Name: "sda", Children: []*BlockDevice{
+			{Name: "sda1", Type: BlockDeviceTypePart, Existing: true},
+			{Name: "sda2", Type: BlockDeviceTypePart, FsType: "ext4", Existing: true, Format: true},
+		}}, true},
+		{&BlockDevice{Name: "sda", Children: []*BlockDevice{
+			{Name: "sda1", Type: BlockDeviceTypePart, Existing: true},
+			{Name: "sda2", Type: BlockDeviceTypePart, FsType: "ext4"},
+		}}, false},
+		{&BlockDevice{Name: "sda", Children: []*BlockDevice{
+			{Name: "sda1", Type: BlockDeviceTypeCrypt, FsType: "ext4", Existing: true},
+		}}, false},
+		{&BlockDevice{Name: "sda", Children: []*BlockDevice{
+			{Name: "sda1", Type: BlockDeviceTypePart, Existing: true, Format: true},
+		}}, false},
+	}
+
+	for idx, curr := range tests {
+		err := curr.bd.validateExistingPartitions()
+		if curr.valid && err != nil {
+			t.Fatalf("Test %d should be valid: %s", idx, err)
+		} else if !curr.valid && err == nil {
+			t.Fatalf("Test %d should be INVALID", idx)
+		}
+	}
+}
+
+func TestMatchExistingPartitions(t *testing.T) {
+	disk := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Children: []*BlockDevice{
+		{Name: "sda1", FsType: "vfat", UUID: "AAAA-BBBB", Size: 512 << 20},
+		{Name: "sda2", FsType: "ntfs", UUID: "1111", Size: 100 << 30},
+		{Name: "sda3", FsType: "ext4", UUID: "2222", PartLabel: "clear-root", Size: 20 << 30},
+		{Name: "sda4", FsType: "ext4", UUID: "3333", Size: 50 << 30},
+	}}
+
+	boot := &BlockDevice{Name: "sda1", Type: BlockDeviceTypePart, MountPoint: "/boot", Existing: true}
+	root := &BlockDevice{Name: "root", Type: BlockDeviceTypePart, FsType: "xfs", MountPoint: "/",
+		PartLabel: "clear-root", Existing: true, Format: true}
+	home := &BlockDevice{Name: "home", Type: BlockDeviceTypePart, MountPoint: "/home",
+		UUID: "3333", Existing: true}
+
+	media := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	media.AddChild(boot)
+	media.AddChild(root)
+	media.AddChild(home)
+
+	if err := matchExistingPartitions([]*BlockDevice{media}, []*BlockDevice{disk}); err != nil {
+		t.Fatalf("Failed to match existing partitions: %s", err)
+	}
+
+	if boot.FsType != "vfat" || boot.Size != 512<<20 {
+		t.Fatalf("Invalid boot partition: %s %d", boot.FsType, boot.Size)
+	}
+
+	if root.Name != "sda3" || root.FsType != "xfs" {
+		t.Fatalf("Invalid root partition: %s %s", root.Name, root.FsType)
+	}
+
+	if home.Name != "sda4" || home.FsType != "ext4" {
+		t.Fatalf("Invalid home partition: %s %s", home.Name, home.FsType)
+	}
+
+	missing := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	missing.AddChild(&BlockDevice{Name: "sda9", Type: BlockDeviceTypePart, Existing: true})
+
+	if err := matchExistingPartitions([]*BlockDevice{missing}, []*BlockDevice{disk}); err == nil {
+		t.Fatalf("Should fail to match a missing partition")
+	}
+
+	mismatch := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	mismatch.AddChild(&BlockDevice{Name: "sda2", Type: BlockDeviceTypePart, FsType: "ext4",
+		Existing: true})
+
+	if err := matchExistingPartitions([]*BlockDevice{mismatch}, []*BlockDevice{disk}); err == nil {
+		t.Fatalf("Should fail to reuse a file system of a different type without format")
+	}
+}
+
+func TestWriteExistingConfigFiles(t *testing.T) {
+	bd := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	bd.AddChild(&BlockDevice{FsType: "vfat", MountPoint: "/boot", UUID: "AAAA-BBBB",
+		Type: BlockDeviceTypePart, Existing: true})
+	bd.AddChild(&BlockDevice{FsType: "ext4", MountPoint: "/", UUID: "2222",
+		Type: BlockDeviceTypePart, Existing: true, Format: true})
+	bd.AddChild(&BlockDevice{FsType: "ext4", MountPoint: "/home", UUID: "3333",
+		Type: BlockDeviceTypePart, Existing: true})
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	if err = GenerateTabFiles(rootDir, []*BlockDevice{bd}); err != nil {
+		t.Fatalf("Failed to write config files: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(rootDir, "etc", "fstab"))
+	if err != nil {
+		t.Fatalf("Failed to read fstab: %v", err)
+	}
+
+	expected := "UUID=AAAA-BBBB /boot vfat defaults 0 2\n" +
+		"UUID=2222 / ext4 defaults 0 1\n" +
+		"UUID=3333 /home ext4 defaults 0 2\n"
+
+	if string(content) != expected {
+		t.Fatalf("Invalid fstab content: %q, expected: %q", string(content), expected)
+	}
+}
+
+func TestRecordedWritePartitionTable(t *testing.T) {
+	progress.Set(&FakeInstall{})
+
+	rec := cmd.NewRecorder()
+	prev := cmd.SetExecutor(rec)
+	defer cmd.SetExecutor(prev)
+
+	settle := partitionSettleTime
+	partitionSettleTime = 0
+	defer func() {
+		partitionSettleTime = settle
+	}()
+
+	bd := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk, Size: 8 << 30}
+	bd.AddChild(&BlockDevice{Name: "sda1", FsType: "vfat", MountPoint: "/boot", Size: 150 << 20,
+		Type: BlockDeviceTypePart})
+	bd.AddChild(&BlockDevice{Name: "sda2", FsType: "swap", Size: 256 << 20, Type: BlockDeviceTypePart})
+	bd.AddChild(&BlockDevice{Name: "sda3", FsType: "ext4", MountPoint: "/", RelativeSize: RestSize,
+		Type: BlockDeviceTypePart})
+
+	if err := bd.WritePartitionTable(false); err != nil {
+		t.Fatalf("Failed to write the partition table: %s", err)
+	}
+
+	expected := []string{
+		"parted -s /dev/sda mklabel gpt",
+		"parted -a optimal /dev/sda --script mkpart EFI fat32 0M 150M mkpart linux-swap 150M 406M mkpart / 406M 8190M",
+		"sgdisk /dev/sda --typecode=1:C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+		"sgdisk /dev/sda --typecode=2:0657FD6D-A4AB-43C4-84E5-0933C84B4F4F",
+		"sgdisk /dev/sda --typecode=3:4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709",
+		"parted /dev/sda set 1 boot on",
+		"partprobe /dev/sda",
+	}
+
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Invalid commands: %q, expected: %q", lines, expected)
+	}
+
+	for _, ch := range bd.Children {
+		rec.Reset()
+
+		if err := ch.MakeFs(); err != nil {
+			t.Fatalf("Failed to write the %s file system: %s", ch.Name, err)
+		}
+
+		lines := rec.Commands()
+		if len(lines) != 1 || lines[0].Args[len(lines[0].Args)-1] != ch.GetDeviceFile() {
+			t.Fatalf("Invalid mkfs command for %s: %v", ch.Name, rec.Lines())
+		}
+	}
+
+	rec.Script("", fmt.Errorf("parted failed"), "parted")
+
+	if err := bd.WritePartitionTable(false); err == nil {
+		t.Fatalf("WritePartitionTable() should fail when parted fails")
+	}
+}
+
+func TestPlanModeMount(t *testing.T) {
+	rec := cmd.NewRecorder()
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+	defer SetPlanMode(SetPlanMode(true))
+
+	rootDir, err := ioutil.TempDir("", "clr-installer-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+
+	bd := &BlockDevice{Name: "sda1", FsType: "btrfs", MountPoint: "/srv", Type: BlockDeviceTypePart}
+	for _, curr := range bd.SubvolumeDevices() {
+		if err = curr.Mount(rootDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = MountMetaFs(rootDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = UmountAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"mount -t btrfs -o relatime,subvolid=5 /dev/sda1 " + filepath.Join(rootDir, "srv"),
+		"mount --bind /proc " + filepath.Join(rootDir, "proc"),
+		"mount --bind /sys " + filepath.Join(rootDir, "sys"),
+		"mount --bind /dev " + filepath.Join(rootDir, "dev"),
+		"umount --force --lazy " + filepath.Join(rootDir, "sys"),
+		"umount --force --lazy " + filepath.Join(rootDir, "srv"),
+		"umount --force --lazy " + filepath.Join(rootDir, "proc"),
+		"umount --force --lazy " + filepath.Join(rootDir, "dev"),
+	}
+
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Invalid commands: %q, expected: %q", lines, expected)
+	}
+}
+
+func TestMountPointSize(t *testing.T) {
+	sda := &BlockDevice{Name: "sda", Type: BlockDeviceTypeDisk}
+	sda.AddChild(&BlockDevice{Name: "sda1", FsType: "btrfs", MountPoint: "/", Size: 8 << 30,
+		Type: BlockDeviceTypePart, Subvolumes: []*Subvolume{{Name: "home", MountPoint: "/home"}}})
+	sda.AddChild(&BlockDevice{Name: "sda2", Size: 4 << 30, Type: BlockDeviceTypeRaidMember})
+
+	vg := &BlockDevice{Name: "sda3", Type: BlockDeviceTypeLVM2Group, VolumeGroup: "vg0", Size: 4 << 30}
+	vg.AddChild(&BlockDevice{Name: "var", FsType: "ext4", MountPoint: "/var", Size: 2 << 30,
+		Type: BlockDeviceTypeLVM2Volume})
+	vg.AddChild(&BlockDevice{Name: "opt", FsType: "ext4", MountPoint: "/opt",
+		Type: BlockDeviceTypeLVM2Volume})
+	sda.AddChild(vg)
+
+	sdb := &BlockDevice{Name: "sdb", Type: BlockDeviceTypeDisk}
+	sdb.AddChild(&BlockDevice{Name: "sdb1", Size: 6 << 30, Type: BlockDeviceTypeRaidMember})
+
+	// the volume group is extended with a physical volume of another media
+	sdb.AddChild(&BlockDevice{Name: "sdb2", Type: BlockDeviceTypeLVM2Group, VolumeGroup: "vg0", Size: 1 << 30})
+
+	medias := []*BlockDevice{sda, sdb}
+	arrays := []*RaidArray{{Name: "data", Level: "raid1", Members: []string{"sda2", "sdb1"},
+		FsType: "ext4", MountPoint: "/srv"}}
+
+	tests := []struct {
+		mnt   string
+		size  uint64
+		found bool
+	}{
+		{"/", 8 << 30, true},
+		{"/home", 8 << 30, true},
+		{"/var", 2 << 30, true},
+		{"/opt", 3 << 30, true},
+		{"/srv", 4 << 30, true},
+		{"/usr", 0, false},
+	}
+
+	for _, curr := range tests {
+		size, found := MountPointSize(medias, arrays, curr.mnt)
+		if size != curr.size || found != curr.found {
+			t.Fatalf("%s: expected size %d (%v), got: %d (%v)", curr.mnt, curr.size, curr.found,
+				size, found)
+		}
+	}
+
+	// the size left to the filling logical volume is not known yet
+	vg.Size = 0
+	if size, found := MountPointSize(medias, arrays, "/opt"); size != 0 || !found {
+		t.Fatalf("Unexpected unresolved /opt size: %d (%v)", size, found)
+	}
+
+	arrays[0].Level = "raid10"
+	if size := arrays[0].Size(medias); size != 4<<30 {
+		t.Fatalf("Unexpected raid10 size: %d", size)
+	}
+}
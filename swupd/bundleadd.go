@@ -0,0 +1,141 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/progress"
+)
+
+// stepsPerStage is the progress resolution of each bundle-add stage, the stages
+// reporting a percentage are tracked within their range
+const stepsPerStage = 100
+
+var (
+	// bundleAddStages are the swupd bundle-add output lines starting each stage of
+	// the operation, in the order they're printed
+	bundleAddStages = []string{
+		"Loading required manifests",
+		"Downloading packs",
+		"Installing bundle(s) files",
+		"Calling post-update helper scripts",
+	}
+
+	// BundleAddSteps is the total of steps AddBundles() reports to its progress
+	BundleAddSteps = len(bundleAddStages) * stepsPerStage
+
+	percentExp = regexp.MustCompile(`([0-9]{1,3})%\s*$`)
+)
+
+// bundleAddParser parses the swupd bundle-add output, every line is logged and
+// the progress is reported to prg; the reported steps never go backwards
+type bundleAddParser struct {
+	prg     progress.Progress
+	partial string
+	stage   int
+	step    int
+}
+
+func (bp *bundleAddParser) Write(p []byte) (int, error) {
+	// the percentage updates are written over the same line
+	content := strings.Replace(bp.partial+string(p), "\r", "\n", -1)
+	lines := strings.Split(content, "\n")
+
+	// the last element is an incomplete line, keep it for the next write
+	bp.partial = lines[len(lines)-1]
+
+	for _, curr := range lines[:len(lines)-1] {
+		bp.parseLine(curr)
+	}
+
+	return len(p), nil
+}
+
+func (bp *bundleAddParser) parseLine(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	log.Debug(line)
+
+	for idx, curr := range bundleAddStages {
+		if strings.HasPrefix(strings.TrimSpace(line), curr) {
+			bp.stage = idx + 1
+			bp.update(idx * stepsPerStage)
+			return
+		}
+	}
+
+	if bp.stage == 0 {
+		return
+	}
+
+	match := percentExp.FindStringSubmatch(line)
+	if len(match) < 2 {
+		return
+	}
+
+	percent, err := strconv.Atoi(match[1])
+	if err != nil || percent > 100 {
+		return
+	}
+
+	bp.update((bp.stage-1)*stepsPerStage + percent*stepsPerStage/100)
+}
+
+func (bp *bundleAddParser) update(step int) {
+	if step <= bp.step {
+		return
+	}
+
+	bp.step = step
+
+	if bp.prg != nil {
+		bp.prg.Partial(step)
+	}
+}
+
+// flush parses the last incomplete line, if any
+func (bp *bundleAddParser) flush() {
+	if bp.partial != "" {
+		bp.parseLine(bp.partial)
+		bp.partial = ""
+	}
+}
+
+// AddBundles executes a single "swupd bundle-add" operation for all the bundles, the
+// operation's progress is reported to prg in a BundleAddSteps scale. swupd may skip
+// the bundles it fails to install, use MissingBundles() to find them out.
+func (s *SoftwareUpdater) AddBundles(bundles []string, prg progress.Progress) error {
+	bp := &bundleAddParser{prg: prg}
+
+	err := cmd.Run(bp, s.getBundleAddCommand(bundles...)...)
+	bp.flush()
+
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// MissingBundles returns the bundles not installed in the target
+func (s *SoftwareUpdater) MissingBundles(bundles []string) []string {
+	res := []string{}
+
+	for _, curr := range bundles {
+		if !s.IsBundleInstalled(curr) {
+			res = append(res, curr)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,356 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/proxy"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// DefaultContentURL is the content URL used to load the bundle catalogue when
+	// no mirror is configured
+	DefaultContentURL = "https://cdn.download.clearlinux.org/update"
+
+	// manifestWorkers is the number of bundle manifests fetched concurrently
+	manifestWorkers = 8
+)
+
+var (
+	catalogues      = map[string]*Catalogue{}
+	cataloguesMutex sync.Mutex
+
+	// manifestClient fetches the manifests, the file:// URLs of the offline
+	// content are read from the local file system
+	manifestClient = newManifestClient()
+)
+
+// Catalogue is the list of the bundles available for a given version
+type Catalogue struct {
+	Version string
+	Bundles []*Bundle
+
+	// Manifests is true if the catalogue was loaded from the version's manifests,
+	// otherwise it's the static bundles.json list which may be stale
+	Manifests bool
+
+	// Failed maps the bundles whose manifest could not be loaded to the failure,
+	// their dependencies and size are not known
+	Failed map[string]error
+}
+
+func newManifestClient() *http.Client {
+	transport := proxy.NewTransport()
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
+
+// fetchManifest returns the content of the manifest name at url for version
+func fetchManifest(url string, version string, name string) (io.ReadCloser, error) {
+	path := fmt.Sprintf("%s/%s/Manifest.%s", strings.TrimSuffix(url, "/"), version, name)
+
+	resp, err := manifestClient.Get(path)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, errors.Errorf("Failed to fetch %s: %s", path, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// parseMoM returns the bundles listed by a MoM and the version of their manifests
+func parseMoM(r io.Reader) (map[string]string, error) {
+	res := map[string]string{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		// entries are in the form: <flags> <hash> <version> <name>
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 || !strings.HasPrefix(fields[0], "M") {
+			continue
+		}
+
+		res[fields[3]] = fields[2]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if len(res) == 0 {
+		return nil, errors.Errorf("No bundle found in the MoM")
+	}
+
+	return res, nil
+}
+
+// parseManifestHeader fills bundle with the dependencies and size declared by the
+// bundle manifest's header
+func parseManifestHeader(r io.Reader, bundle *Bundle) error {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// the header ends with the first empty line
+		if line == "" {
+			break
+		}
+
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(fields[1])
+
+		switch fields[0] {
+		// the also-add bundles are installed along by default
+		case "includes", "also-add":
+			bundle.Includes = append(bundle.Includes, value)
+		case "contentsize":
+			size, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return errors.Errorf("Invalid contentsize: %q", value)
+			}
+			bundle.Size = size
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// LoadCatalogue loads the bundles available in version from the MoM and bundle
+// manifests at the content url. The manifests don't carry any description, the
+// descriptions are taken from bundles.json when it knows the bundle. A bundle
+// manifest failing to load is recorded in the catalogue's Failed bundles, the
+// catalogue only fails if none of the bundle manifests could be loaded.
+func LoadCatalogue(url string, version string) (*Catalogue, error) {
+	body, err := fetchManifest(url, version, "MoM")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseMoM(body)
+	_ = body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	descs := map[string]string{}
+	if bdls, err := LoadBundleList(); err == nil {
+		for _, curr := range bdls {
+			descs[curr.Name] = curr.Desc
+		}
+	}
+
+	cat := &Catalogue{Version: version, Manifests: true, Failed: map[string]error{}}
+	for name := range entries {
+		cat.Bundles = append(cat.Bundles, &Bundle{Name: name, Desc: descs[name]})
+	}
+
+	sort.Slice(cat.Bundles, func(i, j int) bool {
+		return cat.Bundles[i].Name < cat.Bundles[j].Name
+	})
+
+	work := make(chan *Bundle)
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < manifestWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for bundle := range work {
+				if err := loadBundleManifest(url, entries[bundle.Name], bundle); err != nil {
+					mutex.Lock()
+					cat.Failed[bundle.Name] = err
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, curr := range cat.Bundles {
+		work <- curr
+	}
+	close(work)
+	wg.Wait()
+
+	if len(cat.Failed) == len(cat.Bundles) {
+		return nil, errors.Errorf("Failed to load the bundle manifests: %v", cat.Failed[cat.Bundles[0].Name])
+	}
+
+	for _, curr := range cat.Bundles {
+		if err, ok := cat.Failed[curr.Name]; ok {
+			log.Warning("Failed to load the %s bundle manifest, its size is not known: %v", curr.Name, err)
+		}
+	}
+
+	return cat, nil
+}
+
+// loadBundleManifest fills bundle with the header of its manifest of version at url
+func loadBundleManifest(url string, version string, bundle *Bundle) error {
+	body, err := fetchManifest(url, version, bundle.Name)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = body.Close()
+	}()
+
+	if err = parseManifestHeader(body, bundle); err != nil {
+		return errors.Errorf("Manifest.%s: %v", bundle.Name, err)
+	}
+
+	return nil
+}
+
+// LoadBundleCatalogue returns the bundle catalogue of version, if the manifests
+// can not be loaded the static bundles.json list is used instead. The catalogues
+// completely loaded from the manifests are cached.
+func LoadBundleCatalogue(url string, version string) (*Catalogue, error) {
+	key := url + "#" + version
+
+	cataloguesMutex.Lock()
+	defer cataloguesMutex.Unlock()
+
+	if cat, ok := catalogues[key]; ok {
+		return cat, nil
+	}
+
+	cat, err := LoadCatalogue(url, version)
+	if err == nil {
+		// the bundles failing to load are retried by the next call
+		if len(cat.Failed) == 0 {
+			catalogues[key] = cat
+		}
+		return cat, nil
+	}
+
+	log.Warning("Failed to load the bundle catalogue of version %s from %s, using %s: %v",
+		version, url, "bundles.json", err)
+
+	bdls, err := LoadBundleList()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Catalogue{Version: version, Bundles: bdls}, nil
+}
+
+// CatalogueURL returns the content URL the catalogue is loaded from: the
+// configured content URL, mirror or the default content URL
+func (s *SoftwareUpdater) CatalogueURL(mirror string) string {
+	if s.contentURL != "" {
+		return s.contentURL
+	}
+
+	if mirror != "" {
+		return mirror
+	}
+
+	return DefaultContentURL
+}
+
+// Lookup returns the bundle name or nil if it's not in the catalogue
+func (cat *Catalogue) Lookup(name string) *Bundle {
+	for _, curr := range cat.Bundles {
+		if curr.Name == name {
+			return curr
+		}
+	}
+
+	return nil
+}
+
+// Dependencies returns the bundles and all the bundles they include, recursively
+func (cat *Catalogue) Dependencies(bundles []string) []string {
+	res := []string{}
+	pending := append([]string{}, bundles...)
+
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+
+		if utils.StringSliceContains(res, name) {
+			continue
+		}
+
+		res = append(res, name)
+
+		if bundle := cat.Lookup(name); bundle != nil {
+			pending = append(pending, bundle.Includes...)
+		}
+	}
+
+	return res
+}
+
+// InstallSize returns the installed size of the bundles and all their dependencies,
+// the static list doesn't know the bundle sizes so 0 is returned for it as well as
+// for bundles depending on a bundle whose manifest failed to load
+func (cat *Catalogue) InstallSize(bundles []string) uint64 {
+	var total uint64
+
+	for _, curr := range cat.Dependencies(bundles) {
+		if _, ok := cat.Failed[curr]; ok {
+			return 0
+		}
+
+		if bundle := cat.Lookup(curr); bundle != nil {
+			total = total + bundle.Size
+		}
+	}
+
+	return total
+}
+
+// Validate checks all the bundles are available, the static list may not know
+// about every bundle so only the manifest based catalogues reject a bundle
+func (cat *Catalogue) Validate(bundles []string) error {
+	if !cat.Manifests {
+		return nil
+	}
+
+	unknown := []string{}
+	for _, curr := range bundles {
+		if cat.Lookup(curr) == nil {
+			unknown = append(unknown, curr)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return errors.ValidationErrorf("Bundles not available in version %s: %s",
+			cat.Version, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
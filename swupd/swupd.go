@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -38,12 +39,15 @@ type SoftwareUpdater struct {
 	contentURL         string
 	versionURL         string
 	skipDiskSpaceCheck bool
+	offline            bool
 }
 
 // Bundle maps a map name and description with the actual checkbox
 type Bundle struct {
-	Name string // Name the bundle name or id
-	Desc string // Desc is the bundle long description
+	Name     string   // Name the bundle name or id
+	Desc     string   // Desc is the bundle long description
+	Includes []string // Includes are the bundles this bundle depends on
+	Size     uint64   // Size is the bundle's own installed size in bytes
 }
 
 // IsCoreBundle checks if bundle is in the list of core bundles
@@ -71,9 +75,39 @@ func New(rootDir string, options args.Args) *SoftwareUpdater {
 		options.SwupdContentURL,
 		options.SwupdVersionURL,
 		options.SwupdSkipDiskSpaceCheck,
+		false,
 	}
 }
 
+// OfflineURL returns the file:// URL of the local content directory dir
+func OfflineURL(dir string) string {
+	return "file://" + filepath.Clean(dir)
+}
+
+// SetOfflineContent makes all the swupd operations use the content and version
+// files of the local content directory dir instead of the network
+func (s *SoftwareUpdater) SetOfflineContent(dir string) {
+	s.contentURL = OfflineURL(dir)
+	s.versionURL = OfflineURL(dir)
+	s.offline = true
+}
+
+// CheckOfflineContent checks the local content directory dir provides the
+// version to be installed
+func CheckOfflineContent(dir string, version string) error {
+	mom := filepath.Join(dir, version, "Manifest.MoM")
+
+	if _, err := os.Stat(mom); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("Version %s not found in the offline content: %s", version, dir)
+		}
+
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
 func (s *SoftwareUpdater) setExtraFlags(args []string) []string {
 	if s.format != "" {
 		args = append(args, fmt.Sprintf("--format=%s", s.format))
@@ -90,8 +124,11 @@ func (s *SoftwareUpdater) setExtraFlags(args []string) []string {
 	return args
 }
 
-// Verify runs "swupd verify" operation
-func (s *SoftwareUpdater) Verify(version string, mirror string) error {
+// getVerifyCommands returns the commands installing the base system: the core bundles
+// and the mirror setup if any
+func (s *SoftwareUpdater) getVerifyCommands(version string, mirror string) [][]string {
+	res := [][]string{}
+
 	args := []string{
 		"swupd",
 		"verify",
@@ -99,7 +136,8 @@ func (s *SoftwareUpdater) Verify(version string, mirror string) error {
 
 	args = s.setExtraFlags(args)
 
-	if mirror != "" {
+	// the mirror is only set to the target when installing from the offline content
+	if mirror != "" && !s.offline {
 		args = append(args, fmt.Sprintf("--url=%s", mirror))
 	}
 	args = append(args,
@@ -113,71 +151,62 @@ func (s *SoftwareUpdater) Verify(version string, mirror string) error {
 			"--no-scripts",
 		}...)
 
-	err := cmd.RunAndLog(args...)
-	if err != nil {
-		return errors.Wrap(err)
-	}
+	res = append(res, args)
 
 	if mirror != "" {
-		args = []string{
+		res = append(res, []string{
 			"swupd",
 			"mirror",
 			fmt.Sprintf("--path=%s", s.rootDir),
 			"--set",
 			mirror,
-		}
-
-		err = cmd.RunAndLog(args...)
-		if err != nil {
-			return errors.Wrap(err)
-		}
+		})
 	}
 
-	args = []string{
-		"swupd",
-		"bundle-add",
-	}
-
-	if s.skipDiskSpaceCheck {
-		args = append(args, "--skip-diskspace-check")
-	}
-
-	args = s.setExtraFlags(args)
-
-	args = append(args,
-		fmt.Sprintf("--path=%s", s.rootDir),
-		fmt.Sprintf("--statedir=%s", s.stateDir),
-	)
-
 	// Remove the 'os-core' bundle as it is already
 	// installed and will cause a failure
+	bundles := []string{}
 	for _, bundle := range CoreBundles {
 		if bundle != "os-core" {
-			args = append(args, bundle)
+			bundles = append(bundles, bundle)
 		}
 	}
 
-	err = cmd.RunAndLog(args...)
-	if err != nil {
-		return errors.Wrap(err)
+	return append(res, s.getBundleAddCommand(bundles...))
+}
+
+// Verify runs "swupd verify" operation
+func (s *SoftwareUpdater) Verify(version string, mirror string) error {
+	for _, args := range s.getVerifyCommands(version, mirror) {
+		if err := cmd.RunAndLog(args...); err != nil {
+			return errors.Wrap(err)
+		}
 	}
 
 	return nil
 }
 
-// Update executes the "swupd update" operation
-func (s *SoftwareUpdater) Update() error {
+// getUpdateCommand returns the "swupd update" command
+func (s *SoftwareUpdater) getUpdateCommand() []string {
 	args := []string{
 		"swupd",
 		"update",
 		"--keepcache",
+	}
+
+	args = s.setExtraFlags(args)
+
+	return append(args,
 		fmt.Sprintf("--path=%s", s.rootDir),
 		fmt.Sprintf("--statedir=%s", s.stateDir),
-	}
+	)
+}
 
+// Update executes the "swupd update" operation
+func (s *SoftwareUpdater) Update() error {
 	log.Info("Checking for swupd updates")
 
-	err := cmd.RunAndLog(args...)
+	err := cmd.RunAndLog(s.getUpdateCommand()...)
 	if err != nil {
 		return errors.Wrap(err)
 	}
@@ -185,11 +214,9 @@ func (s *SoftwareUpdater) Update() error {
 	return nil
 }
 
-// DisableUpdate executes the "systemctl" to disable auto update operation
-// "swupd autoupdate" currently does not --path
-// See Issue https://github.com/clearlinux/swupd-client/issues/527
-func (s *SoftwareUpdater) DisableUpdate() error {
-	args := []string{
+// getDisableUpdateCommand returns the command masking the target's update units
+func (s *SoftwareUpdater) getDisableUpdateCommand() []string {
+	return []string{
 		filepath.Join(s.rootDir, "/usr/bin/systemctl"),
 		fmt.Sprintf("--root=%s", s.rootDir),
 		"mask",
@@ -197,8 +224,13 @@ func (s *SoftwareUpdater) DisableUpdate() error {
 		"swupd-update.service",
 		"swupd-update.timer",
 	}
+}
 
-	err := cmd.RunAndLog(args...)
+// DisableUpdate executes the "systemctl" to disable auto update operation
+// "swupd autoupdate" currently does not --path
+// See Issue https://github.com/clearlinux/swupd-client/issues/527
+func (s *SoftwareUpdater) DisableUpdate() error {
+	err := cmd.RunAndLog(s.getDisableUpdateCommand()...)
 	if err != nil {
 		return errors.Wrap(err)
 	}
@@ -378,8 +410,8 @@ func parseSwupdMirror(data []byte) (string, error) {
 	return string(match[1]), nil
 }
 
-// BundleAdd executes the "swupd bundle-add" operation for a single bundle
-func (s *SoftwareUpdater) BundleAdd(bundle string) error {
+// getBundleAddCommand returns the "swupd bundle-add" command for bundles
+func (s *SoftwareUpdater) getBundleAddCommand(bundles ...string) []string {
 	args := []string{
 		"swupd",
 		"bundle-add",
@@ -394,10 +426,20 @@ func (s *SoftwareUpdater) BundleAdd(bundle string) error {
 	args = append(args,
 		fmt.Sprintf("--path=%s", s.rootDir),
 		fmt.Sprintf("--statedir=%s", s.stateDir),
-		bundle,
 	)
 
-	err := cmd.RunAndLog(args...)
+	return append(args, bundles...)
+}
+
+// IsBundleInstalled returns true if bundle is already tracked as installed in the target
+func (s *SoftwareUpdater) IsBundleInstalled(bundle string) bool {
+	_, err := os.Stat(filepath.Join(s.rootDir, "usr", "share", "clear", "bundles", bundle))
+	return err == nil
+}
+
+// BundleAdd executes the "swupd bundle-add" operation for a single bundle
+func (s *SoftwareUpdater) BundleAdd(bundle string) error {
+	err := cmd.RunAndLog(s.getBundleAddCommand(bundle)...)
 	if err != nil {
 		return errors.Wrap(err)
 	}
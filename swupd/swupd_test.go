@@ -5,9 +5,18 @@
 package swupd
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/utils"
 )
 
@@ -100,3 +109,291 @@ func TestNewWithState(t *testing.T) {
 		t.Fatalf("stateDir should not be set to: %s", sw.stateDir)
 	}
 }
+
+func TestRecordedInstall(t *testing.T) {
+	rec := cmd.NewRecorder()
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	sw := New("/tmp/test", args.Args{SwupdSkipDiskSpaceCheck: true})
+
+	if err := sw.Verify("25000", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.DisableUpdate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.BundleAdd("editors"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"swupd verify --path=/tmp/test --statedir=/tmp/test/var/lib/swupd --install -m 25000 --force --no-scripts",
+		"swupd bundle-add --skip-diskspace-check --path=/tmp/test --statedir=/tmp/test/var/lib/swupd os-core-update openssh-server",
+		"/tmp/test/usr/bin/systemctl --root=/tmp/test mask --now swupd-update.service swupd-update.timer",
+		"swupd bundle-add --skip-diskspace-check --path=/tmp/test --statedir=/tmp/test/var/lib/swupd editors",
+	}
+
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Invalid commands: %q, expected: %q", lines, expected)
+	}
+
+	rec.Reset()
+
+	if err := sw.Verify("25000", "https://mirror.example.com/update"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := rec.Lines()
+	if len(lines) < 2 || lines[1] != "swupd mirror --path=/tmp/test --set https://mirror.example.com/update" {
+		t.Fatalf("Invalid mirror command: %q", lines)
+	}
+}
+
+func TestRecordedBundleAdd(t *testing.T) {
+	rec := cmd.NewRecorder()
+	prev := cmd.SetExecutor(rec)
+	defer cmd.SetExecutor(prev)
+
+	sw := New("/tmp/test", args.Args{})
+
+	if err := sw.BundleAdd("editors"); err != nil {
+		t.Fatalf("BundleAdd() should not fail: %s", err)
+	}
+
+	expected := "swupd bundle-add --path=/tmp/test --statedir=/tmp/test/var/lib/swupd editors"
+	if lines := rec.Lines(); len(lines) != 1 || lines[0] != expected {
+		t.Fatalf("Invalid commands: %v, expected: %q", lines, expected)
+	}
+
+	rec.Script("", fmt.Errorf("exit status 18"), "swupd", "bundle-add")
+
+	if err := sw.BundleAdd("editors"); err == nil {
+		t.Fatalf("BundleAdd() should fail when swupd fails")
+	}
+}
+
+func TestOfflineContent(t *testing.T) {
+	sw := New("/tmp/test", args.Args{})
+	sw.SetOfflineContent("/run/media/installer/swupd/")
+
+	rec := cmd.NewRecorder()
+	prev := cmd.SetExecutor(rec)
+
+	if err := sw.Verify("25000", "https://mirror.example.com/update"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.BundleAdd("editors"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd.SetExecutor(prev)
+
+	expected := []string{
+		"swupd verify --contenturl=file:///run/media/installer/swupd " +
+			"--versionurl=file:///run/media/installer/swupd --path=/tmp/test " +
+			"--statedir=/tmp/test/var/lib/swupd --install -m 25000 --force --no-scripts",
+		"swupd mirror --path=/tmp/test --set https://mirror.example.com/update",
+		"swupd bundle-add --contenturl=file:///run/media/installer/swupd " +
+			"--versionurl=file:///run/media/installer/swupd --path=/tmp/test " +
+			"--statedir=/tmp/test/var/lib/swupd os-core-update openssh-server",
+		"swupd update --keepcache --contenturl=file:///run/media/installer/swupd " +
+			"--versionurl=file:///run/media/installer/swupd --path=/tmp/test " +
+			"--statedir=/tmp/test/var/lib/swupd",
+		"swupd bundle-add --contenturl=file:///run/media/installer/swupd " +
+			"--versionurl=file:///run/media/installer/swupd --path=/tmp/test " +
+			"--statedir=/tmp/test/var/lib/swupd editors",
+	}
+
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Invalid commands: %q, expected: %q", lines, expected)
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-offline-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err = CheckOfflineContent(dir, "25000"); err == nil {
+		t.Fatal("A missing version should be reported")
+	}
+
+	if err = os.MkdirAll(filepath.Join(dir, "25000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "25000", "Manifest.MoM"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = CheckOfflineContent(dir, "25000"); err != nil {
+		t.Fatalf("The version should be found: %v", err)
+	}
+}
+
+type testProgress struct {
+	steps []int
+}
+
+func (tp *testProgress) Partial(step int) {
+	tp.steps = append(tp.steps, step)
+}
+
+func (tp *testProgress) Success() {}
+
+func (tp *testProgress) Failure() {}
+
+func TestAddBundles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-bundles-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	output := "Loading required manifests...\n" +
+		"Downloading packs...\n" +
+		"Installing bundle(s) files...\n" +
+		"\t...10%\r\t...50%\r\t...50%\r\t...100%\n" +
+		"Warning: Bundle \"bogus\" is invalid, skipping it...\n" +
+		"Calling post-update helper scripts.\n" +
+		"Failed to install 1 of 2 bundles\n"
+
+	rec := cmd.NewRecorder()
+	rec.Script(output, fmt.Errorf("exit status 18"), "swupd", "bundle-add")
+	prev := cmd.SetExecutor(rec)
+	defer cmd.SetExecutor(prev)
+
+	sw := New(dir, args.Args{})
+	prg := &testProgress{}
+
+	if err = sw.AddBundles([]string{"editors", "bogus"}, prg); err == nil {
+		t.Fatal("AddBundles() should fail when swupd fails")
+	}
+
+	expected := "swupd bundle-add --path=" + dir + " --statedir=" + dir + "/var/lib/swupd editors bogus"
+	if lines := rec.Lines(); len(lines) != 1 || lines[0] != expected {
+		t.Fatalf("Invalid commands: %v, expected: %q", lines, expected)
+	}
+
+	steps := []int{100, 200, 210, 250, 300}
+	if fmt.Sprint(prg.steps) != fmt.Sprint(steps) {
+		t.Fatalf("Invalid progress steps: %v, expected: %v", prg.steps, steps)
+	}
+
+	tracking := filepath.Join(dir, "usr", "share", "clear", "bundles")
+	if err = os.MkdirAll(tracking, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(tracking, "editors"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if missing := sw.MissingBundles([]string{"editors", "bogus"}); len(missing) != 1 || missing[0] != "bogus" {
+		t.Fatalf("Invalid missing bundles: %v", missing)
+	}
+}
+
+func TestLoadCatalogue(t *testing.T) {
+	manifests := map[string]string{
+		"/update/25000/Manifest.MoM": "MANIFEST\t25\nversion:\t25000\nprevious:\t24990\n\n" +
+			"M...\t0000\t24990\tos-core\n" +
+			"M...\t0000\t25000\teditors\n" +
+			"M...\t0000\t25000\tpython3-basic\n",
+		"/update/24990/Manifest.os-core": "MANIFEST\t25\nversion:\t24990\ncontentsize:\t1000\n\n" +
+			"F...\t0000\t24990\t/usr/bin/true\n",
+		"/update/25000/Manifest.editors": "MANIFEST\t25\nversion:\t25000\ncontentsize:\t300\n" +
+			"includes:\tos-core\nincludes:\tpython3-basic\n\n",
+		"/update/25000/Manifest.python3-basic": "MANIFEST\t25\nversion:\t25000\ncontentsize:\t200\n" +
+			"includes:\tos-core\n\n",
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := manifests[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	cat, err := LoadCatalogue(srv.URL+"/update", "25000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cat.Manifests || len(cat.Bundles) != 3 {
+		t.Fatalf("Unexpected catalogue: %+v", cat)
+	}
+
+	editors := cat.Lookup("editors")
+	if editors == nil || editors.Size != 300 || strings.Join(editors.Includes, " ") != "os-core python3-basic" {
+		t.Fatalf("Unexpected editors bundle: %+v", editors)
+	}
+
+	if deps := cat.Dependencies([]string{"editors"}); strings.Join(deps, " ") != "editors os-core python3-basic" {
+		t.Fatalf("Unexpected editors dependencies: %v", deps)
+	}
+
+	if size := cat.InstallSize([]string{"editors", "os-core"}); size != 1500 {
+		t.Fatalf("The editors install size should be 1500, got %d", size)
+	}
+
+	if err = cat.Validate([]string{"editors", "os-core"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cat.Validate([]string{"editors", "no-such-bundle"}); err == nil {
+		t.Fatal("A bundle not in the MoM should be rejected")
+	}
+
+	if _, err = LoadCatalogue(srv.URL+"/update", "30000"); err == nil {
+		t.Fatal("An unknown version should fail to load")
+	}
+
+	cat, err = LoadBundleCatalogue(srv.URL+"/update", "25000")
+	if err != nil || !cat.Manifests {
+		t.Fatalf("The manifest catalogue should be loaded: %v", err)
+	}
+
+	if again, _ := LoadBundleCatalogue(srv.URL+"/update", "25000"); again != cat {
+		t.Fatal("The manifest catalogue should be cached")
+	}
+
+	if err = (&Catalogue{}).Validate([]string{"no-such-bundle"}); err != nil {
+		t.Fatalf("The static bundle list should not reject bundles: %v", err)
+	}
+
+	// a failing bundle manifest only makes that bundle's size unknown
+	delete(manifests, "/update/25000/Manifest.python3-basic")
+
+	cat, err = LoadCatalogue(srv.URL+"/update", "25000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cat.Failed["python3-basic"]; !ok || len(cat.Failed) != 1 {
+		t.Fatalf("Only python3-basic should have failed: %v", cat.Failed)
+	}
+
+	if size := cat.InstallSize([]string{"os-core"}); size != 1000 {
+		t.Fatalf("The os-core install size should be 1000, got %d", size)
+	}
+
+	if size := cat.InstallSize([]string{"editors"}); size != 0 {
+		t.Fatalf("The editors install size should not be known, got %d", size)
+	}
+
+	if err = cat.Validate([]string{"editors", "python3-basic"}); err != nil {
+		t.Fatal(err)
+	}
+}
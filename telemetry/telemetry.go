@@ -230,34 +230,37 @@ func (tl *Telemetry) CreateTelemetryConf(rootDir string) error {
 // file to enable the uploading of telemetry records to the remote server.
 // Necessary as we change the default hostname to localhost in the server URI
 // during image creation to ensure record caching during the install.
-func (tl *Telemetry) CreateLocalTelemetryConf() error {
+// rootDir is the installer system's root, i.e "/"
+func (tl *Telemetry) CreateLocalTelemetryConf(rootDir string) error {
+	localConfFile := filepath.Join(rootDir, customTelemetryConf)
 
 	// Ensure the customer configuration file directory exists
-	targetConfDir := filepath.Dir(customTelemetryConf)
+	targetConfDir := filepath.Dir(localConfFile)
 	if err := utils.MkdirAll(targetConfDir, 0755); err != nil {
 		return err
 	}
 
-	if err := utils.CopyFile(defaultTelemetryConf, customTelemetryConf); err != nil {
-		log.Warning("Failed to copy telemetry config %q", customTelemetryConf)
+	if err := utils.CopyFile(defaultTelemetryConf, localConfFile); err != nil {
+		log.Warning("Failed to copy telemetry config %q", localConfFile)
 	}
 
-	log.Debug("Created Local Telemetry server configuration file %q", customTelemetryConf)
+	log.Debug("Created Local Telemetry server configuration file %q", localConfFile)
 
 	return nil
 }
 
 // UpdateLocalTelemetryServer updates the local custom Telemetry configuration
-// file using the customer server and ID
-func (tl *Telemetry) UpdateLocalTelemetryServer() error {
+// file using the customer server and ID, rootDir is the installer system's root
+func (tl *Telemetry) UpdateLocalTelemetryServer(rootDir string) error {
+	localConfFile := filepath.Join(rootDir, customTelemetryConf)
 
 	// Make sure we can read the current custom Telemetry configuration file
-	origConf, readErr := ioutil.ReadFile(customTelemetryConf)
+	origConf, readErr := ioutil.ReadFile(localConfFile)
 	if readErr != nil {
 		return readErr
 	}
 
-	newConfFile := customTelemetryConf + ".new"
+	newConfFile := localConfFile + ".new"
 	newConf := serverExp.ReplaceAll(origConf, []byte("${1}"+tl.URL+"${3}"))
 	// Replace the server
 	// Write the new file
@@ -267,7 +270,7 @@ func (tl *Telemetry) UpdateLocalTelemetryServer() error {
 	}
 
 	// Move the new file into place
-	moveErr := os.Rename(newConfFile, customTelemetryConf)
+	moveErr := os.Rename(newConfFile, localConfFile)
 	if moveErr != nil {
 		return moveErr
 	}
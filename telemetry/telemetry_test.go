@@ -323,12 +323,12 @@ func TestWriteLocalConfig(t *testing.T) {
 		t.Fatalf("Setting telemetry server (%q) should not return error: %s\n", url, err)
 	}
 
-	err := telem.CreateLocalTelemetryConf()
+	err := telem.CreateLocalTelemetryConf("/")
 	if err != nil {
 		t.Fatal("Should have succeeded to write local config file")
 	}
 
-	err = telem.UpdateLocalTelemetryServer()
+	err = telem.UpdateLocalTelemetryServer("/")
 	if err != nil {
 		t.Fatal("Should have succeeded to update local config file")
 	}
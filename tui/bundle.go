@@ -7,14 +7,26 @@ package tui
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/VladimirMarkelov/clui"
+	"github.com/clearlinux/clr-installer/controller"
+	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/swupd"
 )
 
 // BundlePage is the Page implementation for the proxy configuration page
 type BundlePage struct {
 	BasePage
+	lblFrm    *clui.Frame
+	statusLbl *clui.Label
+	catalogue *swupd.Catalogue
+	bundles   []*BundleCheck
+	loadOnce  sync.Once
+
+	// mutex guards the bundles, the checkboxes are replaced once the catalogue is
+	// loaded in background
+	mutex sync.Mutex
 }
 
 // BundleCheck maps a map name and description with the actual checkbox
@@ -23,20 +35,37 @@ type BundleCheck struct {
 	check  *clui.CheckBox
 }
 
-var (
-	bundles = []*BundleCheck{}
-)
-
 // GetConfiguredValue Returns the string representation of currently value set
 func (bp *BundlePage) GetConfiguredValue() string {
 	return strings.Join(bp.getModel().Bundles, ", ")
 }
 
-// Activate marks the checkbox selections based on the data model
+// Activate shows the bundle catalogue of the version to be installed and marks the
+// checkbox selections based on the data model. The catalogue is loaded in background
+// on the first activation, the static bundle list is shown meanwhile.
 func (bp *BundlePage) Activate() {
 	model := bp.getModel()
 
-	for _, curr := range bundles {
+	bp.loadOnce.Do(func() {
+		bdls, err := swupd.LoadBundleList()
+		if err != nil {
+			bp.Panic(err)
+			return
+		}
+
+		bp.mutex.Lock()
+		bp.showCatalogue(&swupd.Catalogue{Bundles: bdls})
+		bp.mutex.Unlock()
+
+		bp.statusLbl.SetTitle("Loading the bundle catalogue...")
+
+		go bp.loadCatalogue()
+	})
+
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+
+	for _, curr := range bp.bundles {
 		state := 0
 
 		if model.ContainsBundle(curr.bundle.Name) {
@@ -47,34 +76,79 @@ func (bp *BundlePage) Activate() {
 	}
 }
 
-func newBundlePage(tui *Tui) (Page, error) {
-	bdls, err := swupd.LoadBundleList()
+// loadCatalogue replaces the static bundle list by the catalogue loaded from the
+// manifests, the current selections are kept. The checkboxes are only swapped once
+// the catalogue is loaded, holding the bundles lock, and the screen is redrawn by
+// the main loop
+func (bp *BundlePage) loadCatalogue() {
+	cat, err := controller.LoadBundleCatalogue(bp.getModel(), bp.tui.options)
 	if err != nil {
-		return nil, err
+		log.Warning("Failed to load the bundle catalogue: %v", err)
 	}
 
-	for _, curr := range bdls {
-		bundles = append(bundles, &BundleCheck{curr, nil})
+	if err == nil && cat.Manifests {
+		bp.mutex.Lock()
+
+		states := map[string]int{}
+		for _, curr := range bp.bundles {
+			states[curr.bundle.Name] = curr.check.State()
+		}
+
+		bp.showCatalogue(cat)
+
+		for _, curr := range bp.bundles {
+			curr.check.SetState(states[curr.bundle.Name])
+		}
+
+		bp.mutex.Unlock()
 	}
 
+	bp.statusLbl.SetTitle("")
+	clui.PutEvent(clui.Event{Type: clui.EventRedraw})
+}
+
+// showCatalogue recreates the bundle checkboxes for the cat's bundles, the caller
+// must hold the bundles lock
+func (bp *BundlePage) showCatalogue(cat *swupd.Catalogue) {
+	for _, curr := range bp.bundles {
+		curr.check.Destroy()
+	}
+	bp.bundles = []*BundleCheck{}
+
+	for _, curr := range cat.Bundles {
+		// the core bundles are always installed and the kernel has its own page
+		if swupd.IsCoreBundle(curr.Name) || strings.HasPrefix(curr.Name, "kernel-") {
+			continue
+		}
+
+		lbl := curr.Name
+		if curr.Desc != "" {
+			lbl = fmt.Sprintf("%s: %s", curr.Name, curr.Desc)
+		}
+
+		check := clui.CreateCheckBox(bp.lblFrm, AutoSize, lbl, AutoSize)
+		check.SetPack(clui.Horizontal)
+
+		bp.bundles = append(bp.bundles, &BundleCheck{curr, check})
+	}
+
+	bp.catalogue = cat
+}
+
+func newBundlePage(tui *Tui) (Page, error) {
 	page := &BundlePage{}
 	page.setupMenu(tui, TuiPageBundle, "Bundle Selection", NoButtons, TuiPageMenu)
 
 	clui.CreateLabel(page.content, 2, 2, "Select additional bundles to be added to the system", Fixed)
+	page.statusLbl = clui.CreateLabel(page.content, AutoSize, 1, "", Fixed)
 
 	frm := clui.CreateFrame(page.content, AutoSize, 14, BorderNone, Fixed)
 	frm.SetPack(clui.Vertical)
 	frm.SetScrollable(true)
 
-	lblFrm := clui.CreateFrame(frm, AutoSize, AutoSize, BorderNone, Fixed)
-	lblFrm.SetPack(clui.Vertical)
-	lblFrm.SetPaddings(2, 0)
-
-	for _, curr := range bundles {
-		lbl := fmt.Sprintf("%s: %s", curr.bundle.Name, curr.bundle.Desc)
-		curr.check = clui.CreateCheckBox(lblFrm, AutoSize, lbl, AutoSize)
-		curr.check.SetPack(clui.Horizontal)
-	}
+	page.lblFrm = clui.CreateFrame(frm, AutoSize, AutoSize, BorderNone, Fixed)
+	page.lblFrm.SetPack(clui.Vertical)
+	page.lblFrm.SetPaddings(2, 0)
 
 	fldFrm := clui.CreateFrame(frm, 30, AutoSize, BorderNone, Fixed)
 	fldFrm.SetPack(clui.Vertical)
@@ -87,7 +161,9 @@ func newBundlePage(tui *Tui) (Page, error) {
 	confirmBtn := CreateSimpleButton(page.cFrame, AutoSize, AutoSize, "Confirm", Fixed)
 	confirmBtn.OnClick(func(ev clui.Event) {
 		anySelected := false
-		for _, curr := range bundles {
+
+		page.mutex.Lock()
+		for _, curr := range page.bundles {
 			if curr.check.State() == 1 {
 				page.getModel().AddBundle(curr.bundle.Name)
 				anySelected = true
@@ -95,6 +171,7 @@ func newBundlePage(tui *Tui) (Page, error) {
 				page.getModel().RemoveBundle(curr.bundle.Name)
 			}
 		}
+		page.mutex.Unlock()
 
 		page.SetDone(anySelected)
 		page.GotoPage(TuiPageMenu)
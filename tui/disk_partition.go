@@ -342,7 +342,7 @@ func newDiskPartitionPage(tui *Tui) (Page, error) {
 				// Use the actual size, not the human readable
 				sel.part.Size = page.sizeTrue
 			} else {
-				size, err := storage.ParseVolumeSize(page.sizeEdit.Title())
+				size, err := sel.part.ParsePartitionSize(page.sizeEdit.Title())
 				if err == nil {
 					sel.part.Size = size
 				}
@@ -156,8 +156,9 @@ func newMenuPage(tui *Tui) (Page, error) {
 
 	page.installBtn = CreateSimpleButton(page.cFrame, AutoSize, AutoSize, "Install", Fixed)
 	page.installBtn.OnClick(func(ev clui.Event) {
-		if !controller.NetworkPassing {
-			// Network needs to be validated before the install
+		if !controller.NetworkPassing && !page.tui.model.IsOffline() {
+			// Network needs to be validated before the install, unless
+			// installing from the offline content
 			if dialog, err := CreateNetworkTestDialogBox(page.tui.model); err == nil {
 				if dialog.RunNetworkTest() {
 					// Automatically close if it worked
@@ -75,6 +75,9 @@ func (page *NetworkPage) showLabel(frm *clui.Frame, txt string) {
 
 func (page *NetworkPage) showInterface(frm *clui.Frame, iface *network.Interface) {
 	lbl := fmt.Sprintf(" interface: %s", iface.Name)
+	if iface.IsWireless() {
+		lbl = fmt.Sprintf(" wireless:  %s", iface.Name)
+	}
 
 	btn := CreateSimpleButton(frm, AutoSize, 1, lbl, Fixed)
 	btn.SetAlign(AlignLeft)
@@ -86,6 +89,14 @@ func (page *NetworkPage) showInterface(frm *clui.Frame, iface *network.Interface
 
 	page.btns = append(page.btns, btn)
 
+	if iface.Wireless != nil {
+		page.showLabel(frm, fmt.Sprintf("  ssid:    %s", iface.Wireless.SSID))
+	}
+
+	if iface.HardwareAddr != "" {
+		page.showLabel(frm, fmt.Sprintf("  mac:     %s", iface.HardwareAddr))
+	}
+
 	for _, addr := range iface.Addrs {
 		ipLabel := addr.VersionString()
 
@@ -5,6 +5,7 @@
 package tui
 
 import (
+	"strings"
 	"time"
 
 	"github.com/clearlinux/clr-installer/network"
@@ -24,16 +25,46 @@ type NetworkInterfacePage struct {
 	GatewayWarning *clui.Label
 	DNSEdit        *clui.EditField
 	DNSWarning     *clui.Label
+	IP6Edit        *clui.EditField
+	IP6Warning     *clui.Label
+	Prefix6Edit    *clui.EditField
+	Prefix6Warning *clui.Label
+	Gateway6Edit   *clui.EditField
+	Gateway6Warn   *clui.Label
+	DNS6Edit       *clui.EditField
+	DNS6Warning    *clui.Label
+	DomainsEdit    *clui.EditField
+	DomainsWarning *clui.Label
+	NTPEdit        *clui.EditField
+	NTPWarning     *clui.Label
+	SSIDEdit       *clui.EditField
+	SSIDWarning    *clui.Label
+	PSKEdit        *clui.EditField
+	PSKWarning     *clui.Label
 	ifaceLbl       *clui.Label
 	DHCPCheck      *clui.CheckBox
+	DHCP6Check     *clui.CheckBox
+	AcceptRACheck  *clui.CheckBox
+	MatchMACCheck  *clui.CheckBox
 	confirmBtn     *SimpleButton
 
 	defaultValues struct {
-		IP      string
-		NetMask string
-		Gateway string
-		DNS     string
-		DHCP    bool
+		IP       string
+		NetMask  string
+		Gateway  string
+		DNS      string
+		DHCP     bool
+		IP6      string
+		Prefix6  string
+		Gateway6 string
+		DNS6     string
+		DHCP6    bool
+		AcceptRA bool
+		MatchMAC bool
+		Domains  string
+		NTP      string
+		SSID     string
+		PSK      string
 	}
 }
 
@@ -58,6 +89,19 @@ func (page *NetworkInterfacePage) clearAllWarnings() {
 	page.setConfirmButton()
 }
 
+func (page *NetworkInterfacePage) clearIPv6Warnings() {
+	page.IP6Warning.SetTitle("")
+	page.Prefix6Warning.SetTitle("")
+	page.Gateway6Warn.SetTitle("")
+	page.DNS6Warning.SetTitle("")
+	page.DomainsWarning.SetTitle("")
+	page.NTPWarning.SetTitle("")
+	page.SSIDWarning.SetTitle("")
+	page.PSKWarning.SetTitle("")
+
+	page.setConfirmButton()
+}
+
 // Activate will set the fields with the selected interface info
 func (page *NetworkInterfacePage) Activate() {
 	sel := page.getSelectedInterface()
@@ -66,11 +110,12 @@ func (page *NetworkInterfacePage) Activate() {
 	page.IPEdit.SetTitle("")
 	page.NetMaskEdit.SetTitle("")
 	page.GatewayEdit.SetTitle(sel.Gateway)
-	page.DNSEdit.SetTitle(sel.DNS)
+	page.DNSEdit.SetTitle(strings.Join(sel.DNS, ", "))
 	page.clearAllWarnings()
+	page.clearIPv6Warnings()
 
 	page.defaultValues.Gateway = sel.Gateway
-	page.defaultValues.DNS = sel.DNS
+	page.defaultValues.DNS = page.DNSEdit.Title()
 	page.defaultValues.DHCP = sel.DHCP
 
 	showIPv4 := sel.HasIPv4Addr()
@@ -88,11 +133,61 @@ func (page *NetworkInterfacePage) Activate() {
 	}
 
 	page.setDHCP(sel.DHCP)
+
+	page.IP6Edit.SetTitle("")
+	page.Prefix6Edit.SetTitle("")
+	page.Gateway6Edit.SetTitle(sel.Gateway6)
+	page.DNS6Edit.SetTitle(strings.Join(sel.DNS6, ", "))
+
+	// only the configured addresses, the router advertisement ones are not pinned
+	for _, addr := range sel.StaticIPv6Addrs() {
+		page.IP6Edit.SetTitle(addr.IP)
+		page.Prefix6Edit.SetTitle(addr.NetMask)
+		break
+	}
+
+	page.defaultValues.IP6 = page.IP6Edit.Title()
+	page.defaultValues.Prefix6 = page.Prefix6Edit.Title()
+	page.defaultValues.Gateway6 = page.Gateway6Edit.Title()
+	page.defaultValues.DNS6 = page.DNS6Edit.Title()
+	page.defaultValues.DHCP6 = sel.DHCP6
+	page.defaultValues.AcceptRA = !sel.IgnoreRA
+
+	page.DomainsEdit.SetTitle(strings.Join(sel.Domains, ", "))
+	page.NTPEdit.SetTitle(strings.Join(sel.NTP, ", "))
+	page.defaultValues.Domains = page.DomainsEdit.Title()
+	page.defaultValues.NTP = page.NTPEdit.Title()
+
+	// only the wireless interfaces connect to a network
+	page.SSIDEdit.SetTitle("")
+	page.PSKEdit.SetTitle("")
+	page.SSIDEdit.SetEnabled(sel.IsWireless())
+	page.PSKEdit.SetEnabled(sel.IsWireless())
+
+	if sel.Wireless != nil {
+		page.SSIDEdit.SetTitle(sel.Wireless.SSID)
+		page.PSKEdit.SetTitle(sel.Wireless.PSK)
+	}
+
+	page.defaultValues.SSID = page.SSIDEdit.Title()
+	page.defaultValues.PSK = page.PSKEdit.Title()
+
+	setCheckState(page.DHCP6Check, sel.DHCP6)
+	setCheckState(page.AcceptRACheck, !sel.IgnoreRA)
+
+	// the interface can only be matched by the hardware address it was found with
+	page.defaultValues.MatchMAC = sel.MACAddress != ""
+	page.MatchMACCheck.SetEnabled(sel.HardwareAddr != "" || sel.MACAddress != "")
+	setCheckState(page.MatchMACCheck, page.defaultValues.MatchMAC)
 }
 
 func (page *NetworkInterfacePage) setConfirmButton() {
 	if page.IPWarning.Title() == "" && page.NetMaskWarning.Title() == "" &&
-		page.GatewayWarning.Title() == "" && page.DNSWarning.Title() == "" {
+		page.GatewayWarning.Title() == "" && page.DNSWarning.Title() == "" &&
+		page.IP6Warning.Title() == "" && page.Prefix6Warning.Title() == "" &&
+		page.Gateway6Warn.Title() == "" && page.DNS6Warning.Title() == "" &&
+		page.DomainsWarning.Title() == "" && page.NTPWarning.Title() == "" &&
+		page.SSIDWarning.Title() == "" && page.PSKWarning.Title() == "" {
 		page.confirmBtn.SetEnabled(true)
 	} else {
 		page.confirmBtn.SetEnabled(false)
@@ -106,6 +201,74 @@ func (page *NetworkInterfacePage) validateIPField(editField *clui.EditField, war
 	page.setConfirmButton()
 }
 
+// validateListField validates the comma separated values of editField, the ipv6,
+// search domains and NTP settings are optional so an empty field is valid
+func (page *NetworkInterfacePage) validateListField(editField *clui.EditField, warnLabel *clui.Label,
+	validate func(string) string) {
+	msg := ""
+
+	for _, curr := range splitList(editField.Title()) {
+		if msg = validate(curr); msg != "" {
+			break
+		}
+	}
+
+	warnLabel.SetTitle(msg)
+
+	page.setConfirmButton()
+}
+
+// validateDNSField validates the DNS servers list, a static configuration requires
+// at least one server
+func (page *NetworkInterfacePage) validateDNSField() {
+	if len(splitList(page.DNSEdit.Title())) == 0 {
+		page.validateIPField(page.DNSEdit, page.DNSWarning)
+		return
+	}
+
+	page.validateListField(page.DNSEdit, page.DNSWarning, network.IsValidIP)
+}
+
+// validateWirelessFields validates the wireless network, an empty SSID leaves the
+// interface disconnected and an empty passphrase is an open network
+func (page *NetworkInterfacePage) validateWirelessFields() {
+	page.SSIDWarning.SetTitle("")
+	page.PSKWarning.SetTitle("")
+
+	if page.SSIDEdit.Title() != "" {
+		page.SSIDWarning.SetTitle(network.IsValidSSID(page.SSIDEdit.Title()))
+	}
+
+	if page.PSKEdit.Title() != "" {
+		page.PSKWarning.SetTitle(network.IsValidPSK(page.PSKEdit.Title()))
+	}
+
+	page.setConfirmButton()
+}
+
+// splitList splits a comma separated list of values, the empty values are dropped
+func splitList(str string) []string {
+	res := []string{}
+
+	for _, curr := range strings.Split(str, ",") {
+		if curr = strings.TrimSpace(curr); curr != "" {
+			res = append(res, curr)
+		}
+	}
+
+	return res
+}
+
+func setCheckState(check *clui.CheckBox, value bool) {
+	state := 0
+
+	if value {
+		state = 1
+	}
+
+	check.SetState(state)
+}
+
 func (page *NetworkInterfacePage) getDHCP() bool {
 	state := page.DHCPCheck.State()
 	if state == 1 {
@@ -155,6 +318,32 @@ func validateIPEdit(k term.Key, ch rune) bool {
 	return true
 }
 
+func validateIPv6Edit(k term.Key, ch rune) bool {
+	if k == term.KeyBackspace || k == term.KeyBackspace2 {
+		return false
+	}
+
+	return !strings.ContainsRune("0123456789abcdefABCDEF:.", ch)
+}
+
+// validateIPListEdit also accepts the separators of a comma separated list
+func validateIPListEdit(k term.Key, ch rune) bool {
+	if ch == ',' || ch == ' ' {
+		return false
+	}
+
+	return validateIPEdit(k, ch)
+}
+
+// validateIPv6ListEdit also accepts the separators of a comma separated list
+func validateIPv6ListEdit(k term.Key, ch rune) bool {
+	if ch == ',' || ch == ' ' {
+		return false
+	}
+
+	return validateIPv6Edit(k, ch)
+}
+
 func newNetworkInterfacePage(tui *Tui) (Page, error) {
 	page := &NetworkInterfacePage{}
 	page.setup(tui, TuiPageInterface, NoButtons, TuiPageMenu)
@@ -171,6 +360,14 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 	newFieldLabel(lblFrm, "Subnet mask:")
 	newFieldLabel(lblFrm, "Gateway:")
 	newFieldLabel(lblFrm, "DNS:")
+	newFieldLabel(lblFrm, "IPv6 address:")
+	newFieldLabel(lblFrm, "IPv6 prefix:")
+	newFieldLabel(lblFrm, "IPv6 gateway:")
+	newFieldLabel(lblFrm, "IPv6 DNS:")
+	newFieldLabel(lblFrm, "Search domains:")
+	newFieldLabel(lblFrm, "NTP servers:")
+	newFieldLabel(lblFrm, "Wireless SSID:")
+	newFieldLabel(lblFrm, "Passphrase:")
 
 	fldFrm := clui.CreateFrame(frm, 30, AutoSize, BorderNone, Fixed)
 	fldFrm.SetPack(clui.Vertical)
@@ -184,7 +381,16 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 	page.IPEdit, _ = newEditField(fldFrm, false, validateIPEdit)
 	page.NetMaskEdit, _ = newEditField(fldFrm, false, validateIPEdit)
 	page.GatewayEdit, _ = newEditField(fldFrm, false, validateIPEdit)
-	page.DNSEdit, _ = newEditField(fldFrm, false, validateIPEdit)
+	page.DNSEdit, _ = newEditField(fldFrm, false, validateIPListEdit)
+	page.IP6Edit, _ = newEditField(fldFrm, false, validateIPv6Edit)
+	page.Prefix6Edit, _ = newEditField(fldFrm, false, validateIPv6Edit)
+	page.Gateway6Edit, _ = newEditField(fldFrm, false, validateIPv6Edit)
+	page.DNS6Edit, _ = newEditField(fldFrm, false, validateIPv6ListEdit)
+	page.DomainsEdit, _ = newEditField(fldFrm, false, nil)
+	page.NTPEdit, _ = newEditField(fldFrm, false, nil)
+	page.SSIDEdit, _ = newEditField(fldFrm, false, nil)
+	page.PSKEdit, _ = newEditField(fldFrm, false, nil)
+	page.PSKEdit.SetPasswordMode(true)
 
 	eLblFrm := clui.CreateFrame(frm, 20, AutoSize, BorderNone, Fixed)
 	eLblFrm.SetPack(clui.Vertical)
@@ -195,6 +401,14 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 	page.NetMaskWarning = newErrorLabel(eLblFrm)
 	page.GatewayWarning = newErrorLabel(eLblFrm)
 	page.DNSWarning = newErrorLabel(eLblFrm)
+	page.IP6Warning = newErrorLabel(eLblFrm)
+	page.Prefix6Warning = newErrorLabel(eLblFrm)
+	page.Gateway6Warn = newErrorLabel(eLblFrm)
+	page.DNS6Warning = newErrorLabel(eLblFrm)
+	page.DomainsWarning = newErrorLabel(eLblFrm)
+	page.NTPWarning = newErrorLabel(eLblFrm)
+	page.SSIDWarning = newErrorLabel(eLblFrm)
+	page.PSKWarning = newErrorLabel(eLblFrm)
 
 	page.IPEdit.OnChange(func(ev clui.Event) {
 		page.validateIPField(page.IPEdit, page.IPWarning)
@@ -206,13 +420,41 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 		page.validateIPField(page.GatewayEdit, page.GatewayWarning)
 	})
 	page.DNSEdit.OnChange(func(ev clui.Event) {
-		page.validateIPField(page.DNSEdit, page.DNSWarning)
+		page.validateDNSField()
+	})
+
+	page.IP6Edit.OnChange(func(ev clui.Event) {
+		page.validateListField(page.IP6Edit, page.IP6Warning, network.IsValidIPv6)
+	})
+	page.Prefix6Edit.OnChange(func(ev clui.Event) {
+		page.validateListField(page.Prefix6Edit, page.Prefix6Warning, network.IsValidIPv6Prefix)
+	})
+	page.Gateway6Edit.OnChange(func(ev clui.Event) {
+		page.validateListField(page.Gateway6Edit, page.Gateway6Warn, network.IsValidIPv6)
+	})
+	page.DNS6Edit.OnChange(func(ev clui.Event) {
+		page.validateListField(page.DNS6Edit, page.DNS6Warning, network.IsValidIPv6)
+	})
+	page.DomainsEdit.OnChange(func(ev clui.Event) {
+		page.validateListField(page.DomainsEdit, page.DomainsWarning, network.IsValidDomain)
+	})
+	page.NTPEdit.OnChange(func(ev clui.Event) {
+		page.validateListField(page.NTPEdit, page.NTPWarning, network.IsValidNTPServer)
+	})
+	page.SSIDEdit.OnChange(func(ev clui.Event) {
+		page.validateWirelessFields()
+	})
+	page.PSKEdit.OnChange(func(ev clui.Event) {
+		page.validateWirelessFields()
 	})
 
 	dhcpFrm := clui.CreateFrame(fldFrm, 5, 2, BorderNone, Fixed)
 	dhcpFrm.SetPack(clui.Vertical)
 
 	page.DHCPCheck = clui.CreateCheckBox(dhcpFrm, 1, "Automatic/dhcp", Fixed)
+	page.DHCP6Check = clui.CreateCheckBox(dhcpFrm, 1, "DHCPv6", Fixed)
+	page.AcceptRACheck = clui.CreateCheckBox(dhcpFrm, 1, "IPv6 router advertisement", Fixed)
+	page.MatchMACCheck = clui.CreateCheckBox(dhcpFrm, 1, "Match by MAC address", Fixed)
 
 	page.DHCPCheck.OnChange(func(ev int) {
 		enable := true
@@ -224,7 +466,7 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 			page.validateIPField(page.IPEdit, page.IPWarning)
 			page.validateIPField(page.NetMaskEdit, page.NetMaskWarning)
 			page.validateIPField(page.GatewayEdit, page.GatewayWarning)
-			page.validateIPField(page.DNSEdit, page.DNSWarning)
+			page.validateDNSField()
 		}
 
 		page.IPEdit.SetEnabled(enable)
@@ -251,6 +493,17 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 		DHCP := page.getDHCP()
 		Gateway := page.GatewayEdit.Title()
 		DNS := page.DNSEdit.Title()
+		IP6 := page.IP6Edit.Title()
+		Prefix6 := page.Prefix6Edit.Title()
+		Gateway6 := page.Gateway6Edit.Title()
+		DNS6 := page.DNS6Edit.Title()
+		DHCP6 := page.DHCP6Check.State() == 1
+		AcceptRA := page.AcceptRACheck.State() == 1
+		MatchMAC := page.MatchMACCheck.State() == 1
+		Domains := page.DomainsEdit.Title()
+		NTP := page.NTPEdit.Title()
+		SSID := page.SSIDEdit.Title()
+		PSK := page.PSKEdit.Title()
 		changed := false
 
 		if IP != page.defaultValues.IP {
@@ -273,6 +526,18 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 			changed = true
 		}
 
+		if IP6 != page.defaultValues.IP6 || Prefix6 != page.defaultValues.Prefix6 ||
+			Gateway6 != page.defaultValues.Gateway6 || DNS6 != page.defaultValues.DNS6 ||
+			DHCP6 != page.defaultValues.DHCP6 || AcceptRA != page.defaultValues.AcceptRA ||
+			MatchMAC != page.defaultValues.MatchMAC {
+			changed = true
+		}
+
+		if Domains != page.defaultValues.Domains || NTP != page.defaultValues.NTP ||
+			SSID != page.defaultValues.SSID || PSK != page.defaultValues.PSK {
+			changed = true
+		}
+
 		if changed {
 			sel := page.getSelectedInterface()
 			if !sel.HasIPv4Addr() {
@@ -289,9 +554,46 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 				}
 			}
 
+			// the page edits a single static ipv6 address
+			addrs := []*network.Addr{}
+			for _, addr := range sel.Addrs {
+				if addr.Version == network.IPv4 {
+					addrs = append(addrs, addr)
+				}
+			}
+
+			if IP6 != "" {
+				if Prefix6 == "" {
+					Prefix6 = "64"
+				}
+
+				addrs = append(addrs, &network.Addr{IP: IP6, NetMask: Prefix6, Version: network.IPv6})
+			}
+
+			sel.Addrs = addrs
 			sel.DHCP = DHCP
 			sel.Gateway = Gateway
-			sel.DNS = DNS
+			sel.DNS = splitList(DNS)
+			sel.Domains = splitList(Domains)
+			sel.NTP = splitList(NTP)
+
+			if SSID == "" {
+				sel.Wireless = nil
+			} else if SSID != page.defaultValues.SSID || PSK != page.defaultValues.PSK {
+				// the page only sets up the open and psk networks
+				sel.Wireless = &network.Wireless{SSID: SSID, PSK: PSK}
+			}
+			sel.Gateway6 = Gateway6
+			sel.DNS6 = splitList(DNS6)
+			sel.DHCP6 = DHCP6
+			sel.IgnoreRA = !AcceptRA
+
+			if !MatchMAC {
+				sel.MACAddress = ""
+			} else if sel.MACAddress == "" {
+				sel.MACAddress = sel.HardwareAddr
+			}
+
 			page.getModel().AddNetworkInterface(sel)
 		}
 
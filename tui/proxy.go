@@ -5,45 +5,83 @@
 package tui
 
 import (
-	"net/url"
+	"strings"
 	"time"
 
 	"github.com/VladimirMarkelov/clui"
+
+	"github.com/clearlinux/clr-installer/proxy"
 )
 
 // ProxyPage is the Page implementation for the proxy configuration page
 type ProxyPage struct {
 	BasePage
+	httpProxyEdit     *clui.EditField
+	httpProxyWarning  *clui.Label
 	httpsProxyEdit    *clui.EditField
 	httpsProxyWarning *clui.Label
+	noProxyEdit       *clui.EditField
+	userEdit          *clui.EditField
+	passwordEdit      *clui.EditField
 	confirmBtn        *SimpleButton
 }
 
 // GetConfiguredValue Returns the string representation of currently value set
 func (pp *ProxyPage) GetConfiguredValue() string {
-	value := pp.getModel().HTTPSProxy
+	md := pp.getModel()
+
+	if md.HTTPSProxy != "" {
+		return md.HTTPSProxy
+	}
 
-	if value == "" {
-		return "No HTTPS proxy URL set"
+	if md.HTTPProxy != "" {
+		return md.HTTPProxy
 	}
 
-	return value
+	return "No proxy URL set"
 }
 
-// Activate sets the https proxy with the current model's value
+// Activate sets the proxy fields with the current model's values
 func (pp *ProxyPage) Activate() {
-	pp.httpsProxyEdit.SetTitle(pp.getModel().HTTPSProxy)
+	md := pp.getModel()
+
+	pp.httpProxyEdit.SetTitle(md.HTTPProxy)
+	pp.httpProxyWarning.SetTitle("")
+	pp.httpsProxyEdit.SetTitle(md.HTTPSProxy)
 	pp.httpsProxyWarning.SetTitle("")
+	pp.noProxyEdit.SetTitle(strings.Join(md.NoProxy, ", "))
+	pp.userEdit.SetTitle(md.ProxyUser)
+	pp.passwordEdit.SetTitle(md.ProxyPassword)
 }
 
 func (pp *ProxyPage) setConfirmButton() {
-	if pp.httpsProxyWarning.Title() == "" {
+	if pp.httpProxyWarning.Title() == "" && pp.httpsProxyWarning.Title() == "" {
 		pp.confirmBtn.SetEnabled(true)
 	} else {
 		pp.confirmBtn.SetEnabled(false)
 	}
 }
 
+// newProxyField creates a proxy URL edit and its warning label
+func (pp *ProxyPage) newProxyField(frm *clui.Frame) (*clui.EditField, *clui.Label) {
+	iframe := clui.CreateFrame(frm, 5, 2, BorderNone, Fixed)
+	iframe.SetPack(clui.Vertical)
+
+	edit := clui.CreateEditField(iframe, 1, "", Fixed)
+
+	warning := clui.CreateLabel(iframe, 1, 1, "", Fixed)
+	warning.SetMultiline(true)
+	warning.SetBackColor(errorLabelBg)
+	warning.SetTextColor(errorLabelFg)
+
+	edit.OnChange(func(ev clui.Event) {
+		warning.SetTitle(proxy.IsValidURL(edit.Title()))
+		pp.setConfirmButton()
+	})
+
+	return edit, warning
+}
+
 func newProxyPage(tui *Tui) (Page, error) {
 	page := &ProxyPage{}
 	page.setupMenu(tui, TuiPageProxy, "Proxy", NoButtons, TuiPageMenu)
@@ -57,34 +95,21 @@ func newProxyPage(tui *Tui) (Page, error) {
 	lblFrm.SetPack(clui.Vertical)
 	lblFrm.SetPaddings(1, 0)
 
+	newFieldLabel(lblFrm, "HTTP Proxy:")
 	newFieldLabel(lblFrm, "HTTPS Proxy:")
+	newFieldLabel(lblFrm, "No Proxy:")
+	newFieldLabel(lblFrm, "User:")
+	newFieldLabel(lblFrm, "Password:")
 
 	fldFrm := clui.CreateFrame(frm, 30, AutoSize, BorderNone, Fixed)
 	fldFrm.SetPack(clui.Vertical)
 
-	iframe := clui.CreateFrame(fldFrm, 5, 2, BorderNone, Fixed)
-	iframe.SetPack(clui.Vertical)
-
-	page.httpsProxyEdit = clui.CreateEditField(iframe, 1, "", Fixed)
-	page.httpsProxyEdit.OnChange(func(ev clui.Event) {
-		warning := ""
-		userProxy := page.httpsProxyEdit.Title()
-
-		if userProxy != "" {
-			_, err := url.ParseRequestURI(page.httpsProxyEdit.Title())
-			if err != nil {
-				warning = "Invalid URL for Proxy Server"
-			}
-		}
-
-		page.httpsProxyWarning.SetTitle(warning)
-		page.setConfirmButton()
-	})
-
-	page.httpsProxyWarning = clui.CreateLabel(iframe, 1, 1, "", Fixed)
-	page.httpsProxyWarning.SetMultiline(true)
-	page.httpsProxyWarning.SetBackColor(errorLabelBg)
-	page.httpsProxyWarning.SetTextColor(errorLabelFg)
+	page.httpProxyEdit, page.httpProxyWarning = page.newProxyField(fldFrm)
+	page.httpsProxyEdit, page.httpsProxyWarning = page.newProxyField(fldFrm)
+	page.noProxyEdit, _ = newEditField(fldFrm, false, nil)
+	page.userEdit, _ = newEditField(fldFrm, false, nil)
+	page.passwordEdit, _ = newEditField(fldFrm, false, nil)
+	page.passwordEdit.SetPasswordMode(true)
 
 	btnFrm := clui.CreateFrame(fldFrm, 30, 1, BorderNone, Fixed)
 	btnFrm.SetPack(clui.Horizontal)
@@ -98,28 +123,38 @@ func newProxyPage(tui *Tui) (Page, error) {
 
 	page.confirmBtn = CreateSimpleButton(btnFrm, AutoSize, AutoSize, "Confirm", Fixed)
 	page.confirmBtn.OnClick(func(ev clui.Event) {
-		proxy := page.httpsProxyEdit.Title()
-		currentProxy := page.getModel().HTTPSProxy
-		page.getModel().HTTPSProxy = proxy
+		md := page.getModel()
+		current := *md
+
+		md.HTTPProxy = page.httpProxyEdit.Title()
+		md.HTTPSProxy = page.httpsProxyEdit.Title()
+		md.NoProxy = splitList(page.noProxyEdit.Title())
+		md.ProxyUser = page.userEdit.Title()
+		md.ProxyPassword = page.passwordEdit.Title()
+
 		if dialog, err := CreateNetworkTestDialogBox(page.tui.model); err == nil {
 			dialog.OnClose(func() {
 				page.GotoPage(TuiPageMenu)
 			})
 			if dialog.RunNetworkTest() {
-				page.SetDone(proxy != "")
+				page.SetDone(md.Proxy().IsSet())
 
 				// Automatically close if it worked
 				clui.RefreshScreen()
 				time.Sleep(time.Second)
 				dialog.Close()
 			} else {
-				page.getModel().HTTPSProxy = currentProxy
+				md.HTTPProxy = current.HTTPProxy
+				md.HTTPSProxy = current.HTTPSProxy
+				md.NoProxy = current.NoProxy
+				md.ProxyUser = current.ProxyUser
+				md.ProxyPassword = current.ProxyPassword
 				page.SetDone(false)
 			}
 		}
 	})
 
-	page.activated = page.httpsProxyEdit
+	page.activated = page.httpProxyEdit
 
 	return page, nil
 }
@@ -86,6 +86,11 @@ func lookupThemeDir() (string, error) {
 
 // Run is part of the Frontend interface implementation and is the tui frontend main entry point
 func (tui *Tui) Run(md *model.SystemInstall, rootDir string, options args.Args) (bool, error) {
+	// the progress is drawn on the screen, it can't be streamed as JSON events
+	if options.ProgressJSON != "" {
+		return false, errors.Errorf("--progress-json is not supported by the TUI frontend")
+	}
+
 	clui.InitLibrary()
 	defer clui.DeinitLibrary()
 
@@ -5,6 +5,7 @@
 package tui
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/clearlinux/clr-installer/log"
@@ -25,13 +26,24 @@ type UseraddPage struct {
 	usernameEdit    *clui.EditField
 	passwordEdit    *clui.EditField
 	pwConfirmEdit   *clui.EditField
+	groupsEdit      *clui.EditField
+	uidEdit         *clui.EditField
+	gidEdit         *clui.EditField
+	shellEdit       *clui.EditField
+	homeEdit        *clui.EditField
 	adminCheck      *clui.CheckBox
+	systemCheck     *clui.CheckBox
 	deleteBtn       *SimpleButton
 	changedPwd      bool
 	changedLogin    bool
 	loginWarning    *clui.Label
 	usernameWarning *clui.Label
 	passwordWarning *clui.Label
+	groupsWarning   *clui.Label
+	uidWarning      *clui.Label
+	gidWarning      *clui.Label
+	shellWarning    *clui.Label
+	homeWarning     *clui.Label
 	confirmBtn      *SimpleButton
 }
 
@@ -51,6 +63,10 @@ func (page *UseraddPage) GetConfiguredValue() string {
 			tks = append(tks, "admin")
 		}
 
+		if curr.System {
+			tks = append(tks, "system")
+		}
+
 		res = append(res, strings.Join(tks, ":"))
 	}
 
@@ -103,6 +119,13 @@ func (page *UseraddPage) SetDone(done bool) bool {
 		page.user.Admin = false
 	}
 
+	page.user.Groups = splitList(page.groupsEdit.Title())
+	page.user.UID, _ = strconv.Atoi(page.uidEdit.Title())
+	page.user.GID, _ = strconv.Atoi(page.gidEdit.Title())
+	page.user.Shell = page.shellEdit.Title()
+	page.user.Home = page.homeEdit.Title()
+	page.user.System = page.systemCheck.State() != 0
+
 	page.GotoPage(TuiPageUserManager)
 
 	return false
@@ -112,6 +135,11 @@ func (page *UseraddPage) setConfirmButton() {
 	if page.usernameWarning.Title() == "" &&
 		page.loginWarning.Title() == "" &&
 		page.passwordWarning.Title() == "" &&
+		page.groupsWarning.Title() == "" &&
+		page.uidWarning.Title() == "" &&
+		page.gidWarning.Title() == "" &&
+		page.shellWarning.Title() == "" &&
+		page.homeWarning.Title() == "" &&
 		page.loginEdit.Title() != "" &&
 		page.passwordEdit.Title() != "" {
 		page.confirmBtn.SetEnabled(true)
@@ -173,6 +201,51 @@ func (page *UseraddPage) validatePassword() {
 	page.setConfirmButton()
 }
 
+func (page *UseraddPage) validateGroups() {
+	page.groupsWarning.SetTitle("")
+
+	for _, curr := range splitList(page.groupsEdit.Title()) {
+		if ok, msg := user.IsValidGroup(curr); !ok {
+			page.groupsWarning.SetTitle(msg)
+			break
+		}
+	}
+
+	page.setConfirmButton()
+}
+
+// validateIDField validates an optional UID or GID field
+func (page *UseraddPage) validateIDField(editField *clui.EditField, warnLabel *clui.Label) {
+	warnLabel.SetTitle("")
+
+	if editField.Title() != "" {
+		id, err := strconv.Atoi(editField.Title())
+		if err != nil || id == 0 {
+			warnLabel.SetTitle("Invalid id")
+		} else if ok, msg := user.IsValidID(id); !ok {
+			warnLabel.SetTitle(msg)
+		}
+	}
+
+	page.setConfirmButton()
+}
+
+// validatePathField validates the optional shell and home directory fields
+func (page *UseraddPage) validatePathField(editField *clui.EditField, warnLabel *clui.Label) {
+	_, msg := user.IsValidPath(editField.Title())
+	warnLabel.SetTitle(msg)
+
+	page.setConfirmButton()
+}
+
+func validateIDEdit(k term.Key, ch rune) bool {
+	if k == term.KeyBackspace || k == term.KeyBackspace2 {
+		return false
+	}
+
+	return !strings.ContainsRune("0123456789", ch)
+}
+
 func newUseraddPage(tui *Tui) (Page, error) {
 	page := &UseraddPage{}
 	page.setup(tui, TuiPageUseradd, NoButtons, TuiPageUserManager)
@@ -189,6 +262,11 @@ func newUseraddPage(tui *Tui) (Page, error) {
 	newFieldLabel(lblFrm, "Login:")
 	newFieldLabel(lblFrm, "Password:")
 	newFieldLabel(lblFrm, "Retype:")
+	newFieldLabel(lblFrm, "Groups:")
+	newFieldLabel(lblFrm, "UID:")
+	newFieldLabel(lblFrm, "GID:")
+	newFieldLabel(lblFrm, "Shell:")
+	newFieldLabel(lblFrm, "Home:")
 
 	fldFrm := clui.CreateFrame(frm, 50, AutoSize, BorderNone, Fixed)
 	fldFrm.SetPack(clui.Vertical)
@@ -295,10 +373,41 @@ func newUseraddPage(tui *Tui) (Page, error) {
 		return false
 	})
 
+	page.groupsEdit, page.groupsWarning = newEditField(fldFrm, true, nil)
+	page.groupsEdit.OnChange(func(ev clui.Event) {
+		page.validateGroups()
+	})
+	page.groupsWarning.SetVisible(true)
+
+	page.uidEdit, page.uidWarning = newEditField(fldFrm, true, validateIDEdit)
+	page.uidEdit.OnChange(func(ev clui.Event) {
+		page.validateIDField(page.uidEdit, page.uidWarning)
+	})
+	page.uidWarning.SetVisible(true)
+
+	page.gidEdit, page.gidWarning = newEditField(fldFrm, true, validateIDEdit)
+	page.gidEdit.OnChange(func(ev clui.Event) {
+		page.validateIDField(page.gidEdit, page.gidWarning)
+	})
+	page.gidWarning.SetVisible(true)
+
+	page.shellEdit, page.shellWarning = newEditField(fldFrm, true, nil)
+	page.shellEdit.OnChange(func(ev clui.Event) {
+		page.validatePathField(page.shellEdit, page.shellWarning)
+	})
+	page.shellWarning.SetVisible(true)
+
+	page.homeEdit, page.homeWarning = newEditField(fldFrm, true, nil)
+	page.homeEdit.OnChange(func(ev clui.Event) {
+		page.validatePathField(page.homeEdit, page.homeWarning)
+	})
+	page.homeWarning.SetVisible(true)
+
 	adminFrm := clui.CreateFrame(fldFrm, 5, 2, BorderNone, Fixed)
 	adminFrm.SetPack(clui.Vertical)
 
 	page.adminCheck = clui.CreateCheckBox(adminFrm, 1, "Administrative", Fixed)
+	page.systemCheck = clui.CreateCheckBox(adminFrm, 1, "System account", Fixed)
 
 	cancelBtn := CreateSimpleButton(page.cFrame, AutoSize, AutoSize, "Cancel", Fixed)
 	cancelBtn.OnClick(func(ev clui.Event) {
@@ -320,6 +429,12 @@ func newUseraddPage(tui *Tui) (Page, error) {
 		page.user.Login = ""
 		page.user.Password = ""
 		page.user.Admin = false
+		page.user.Groups = nil
+		page.user.UID = 0
+		page.user.GID = 0
+		page.user.Shell = ""
+		page.user.Home = ""
+		page.user.System = false
 		page.clearForm()
 		page.GotoPage(TuiPageUserManager)
 	})
@@ -368,6 +483,15 @@ func (page *UseraddPage) resetForm() {
 		page.adminCheck.SetState(1)
 	}
 
+	page.groupsEdit.SetTitle(strings.Join(page.user.Groups, ", "))
+	page.uidEdit.SetTitle(idTitle(page.user.UID))
+	page.gidEdit.SetTitle(idTitle(page.user.GID))
+	page.shellEdit.SetTitle(page.user.Shell)
+	page.homeEdit.SetTitle(page.user.Home)
+	if page.user.System {
+		page.systemCheck.SetState(1)
+	}
+
 	page.deleteBtn.SetEnabled(true)
 
 	clui.ActivateControl(page.tui.currPage.GetWindow(), page.usernameEdit)
@@ -389,8 +513,23 @@ func (page *UseraddPage) clearForm() {
 	page.pwConfirmEdit.SetTitle("")
 	page.passwordEdit.SetPasswordMode(true)
 	page.pwConfirmEdit.SetPasswordMode(true)
+	page.groupsEdit.SetTitle("")
+	page.uidEdit.SetTitle("")
+	page.gidEdit.SetTitle("")
+	page.shellEdit.SetTitle("")
+	page.homeEdit.SetTitle("")
 	page.adminCheck.SetState(0)
+	page.systemCheck.SetState(0)
 	page.deleteBtn.SetEnabled(false)
 	page.confirmBtn.SetEnabled(false)
 	clui.ActivateControl(page.tui.currPage.GetWindow(), page.usernameEdit)
 }
+
+// idTitle returns the edit field title of a UID or GID, zero is not defined
+func idTitle(id int) string {
+	if id == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(id)
+}
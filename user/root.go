@@ -0,0 +1,188 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package user
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/progress"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// Root is the target system's root account policy: the password is either locked
+// or set to the encrypted Password, the SSHKeys are authorized to log in as root
+// and PermitLogin is the sshd's PermitRootLogin setting
+type Root struct {
+	Lock        bool     `yaml:"lock,omitempty"`
+	Password    string   `yaml:"password,omitempty"`
+	SSHKeys     []string `yaml:"ssh-keys,omitempty,flow"`
+	PermitLogin string   `yaml:"permitLogin,omitempty"`
+}
+
+const (
+	// rootLogin is the root account's login
+	rootLogin = "root"
+
+	// sshdConfigFile is the sshd configuration file, on a stateless system it only
+	// exists if the defaults are overridden
+	sshdConfigFile = "/etc/ssh/sshd_config"
+
+	// defaultSshdConfigFile is the sshd configuration used if sshdConfigFile is missing
+	defaultSshdConfigFile = "/usr/share/defaults/ssh/sshd_config"
+)
+
+var (
+	// the sshd's PermitRootLogin values
+	permitRootLogin = []string{"yes", "no", "prohibit-password", "forced-commands-only"}
+
+	// cryptExp matches the crypt(3) encrypted passwords: $id$salt$hash, the salt
+	// being optionally preceded by the hash's parameters (i.e. rounds=5000)
+	cryptExp = regexp.MustCompile(`^\$[0-9a-z]+\$([^$:\s]+\$)?[^$:\s]+\$[./0-9A-Za-z]+$`)
+)
+
+// Validate checks the root account policy
+func (r *Root) Validate() error {
+	if r.Lock && r.Password != "" {
+		return errors.ValidationErrorf("The root password can't be both locked and set")
+	}
+
+	if r.Password != "" && !cryptExp.MatchString(r.Password) {
+		return errors.ValidationErrorf("Invalid root password, expected an encrypted password ($id$salt$hash)")
+	}
+
+	if r.PermitLogin != "" && !utils.StringSliceContains(permitRootLogin, r.PermitLogin) {
+		return errors.ValidationErrorf("Invalid root permitLogin %q, expected one of: %s",
+			r.PermitLogin, strings.Join(permitRootLogin, ", "))
+	}
+
+	return nil
+}
+
+// getLockCommand returns the usermod command locking the root password in rootDir
+func getLockCommand(rootDir string) []string {
+	return []string{
+		"usermod",
+		"--root",
+		rootDir,
+		"--lock",
+		rootLogin,
+	}
+}
+
+// ApplyRoot applies the root account policy to the target install at rootDir, a nil
+// policy leaves the root account untouched
+func ApplyRoot(rootDir string, r *Root) error {
+	if r == nil {
+		return nil
+	}
+
+	prg := progress.NewLoop("Configuring the root account")
+	log.Info("Configuring the root account")
+
+	if err := r.apply(rootDir); err != nil {
+		prg.Failure()
+		return err
+	}
+
+	prg.Success()
+	return nil
+}
+
+func (r *Root) apply(rootDir string) error {
+	if r.Password != "" {
+		if err := setTempTargetPAMConfig(rootDir); err != nil {
+			return err
+		}
+
+		pwd := fmt.Sprintf("%s:%s", rootLogin, r.Password)
+
+		if err := cmd.PipeRunAndLog(pwd, getChpasswdCommand(rootDir)...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if r.Lock {
+		if err := cmd.RunAndLog(getLockCommand(rootDir)...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if len(r.SSHKeys) > 0 {
+		if err := writeAuthorizedKeys(filepath.Join(rootDir, rootLogin, ".ssh"), r.SSHKeys); err != nil {
+			return err
+		}
+	}
+
+	if r.PermitLogin != "" {
+		if err := writePermitRootLogin(rootDir, r.PermitLogin); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writePermitRootLogin sets the PermitRootLogin of the target's sshd configuration.
+// The setting is the file's first line as sshd uses the first value it reads and a
+// Match block extends to the next Match or the end of the file. A missing
+// configuration is only an override including the system defaults, these are not
+// copied to /etc so they keep being updated with the system
+func writePermitRootLogin(rootDir string, value string) error {
+	path := filepath.Join(rootDir, sshdConfigFile)
+
+	if err := utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	lines := []string{fmt.Sprintf("PermitRootLogin %s", value)}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		content = []byte{}
+
+		_, err = os.Stat(filepath.Join(rootDir, defaultSshdConfigFile))
+		if err == nil {
+			lines = append(lines, fmt.Sprintf("Include %s", defaultSshdConfigFile))
+		}
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err)
+	}
+
+	if len(content) > 0 {
+		inMatch := false
+
+		// the global settings are replaced, the Match blocks' ones are kept
+		for _, curr := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+			fields := strings.Fields(curr)
+
+			if len(fields) > 0 && strings.EqualFold(fields[0], "Match") {
+				inMatch = true
+			}
+
+			if !inMatch && len(fields) > 0 && strings.EqualFold(fields[0], "PermitRootLogin") {
+				continue
+			}
+
+			lines = append(lines, curr)
+		}
+	}
+
+	content = []byte(strings.Join(lines, "\n") + "\n")
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
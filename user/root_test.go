@@ -0,0 +1,109 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/clearlinux/clr-installer/cmd"
+)
+
+func TestApplyRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	defaultsDir := filepath.Join(dir, filepath.Dir(defaultSshdConfigFile))
+	if err = os.MkdirAll(defaultsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "PermitRootLogin yes\nUsePAM yes\nMatch User backup\n\tPermitRootLogin no\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, defaultSshdConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := &Root{SSHKeys: []string{"ssh-rsa xxxxxxxxxxxx"}, PermitLogin: "prohibit-password"}
+
+	if err = root.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = root.apply(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ioutil.ReadFile(filepath.Join(dir, "root", ".ssh", "authorized_keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(keys) != "ssh-rsa xxxxxxxxxxxx\n" {
+		t.Fatalf("Invalid root authorized_keys: %s", keys)
+	}
+
+	sshd, err := ioutil.ReadFile(filepath.Join(dir, sshdConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "PermitRootLogin prohibit-password\nInclude " + defaultSshdConfigFile + "\n"
+	if string(sshd) != expected {
+		t.Fatalf("Invalid sshd configuration, expected:\n%s\ngot:\n%s", expected, sshd)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, sshdConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = root.apply(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if sshd, err = ioutil.ReadFile(filepath.Join(dir, sshdConfigFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "PermitRootLogin prohibit-password\nUsePAM yes\nMatch User backup\n\tPermitRootLogin no\n"
+	if string(sshd) != expected {
+		t.Fatalf("Invalid sshd configuration, expected:\n%s\ngot:\n%s", expected, sshd)
+	}
+
+	if err = (&Root{Lock: true, Password: "$6$xxxx"}).Validate(); err == nil {
+		t.Fatal("A locked root password can't be set")
+	}
+
+	for _, pwd := range []string{"clear", "$6$salt", "$6$salt$hash:", "$6$rounds=5000$salt$hash$x"} {
+		if err = (&Root{Password: pwd}).Validate(); err == nil {
+			t.Fatalf("Should fail to validate the unencrypted root password %q", pwd)
+		}
+	}
+
+	if err = (&Root{Password: "$6$GiHrwmYUsJ2Qyz0d$Jxn8P0QKAQS3mM1WPtH9XYz1D8fpN8a/xVw7Dw6Vf8Zk2hxD3YqJcK4zwkH.F0pVLiHzX0M8FFaL1e0TS4hne/"}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = (&Root{PermitLogin: "maybe"}).Validate(); err == nil {
+		t.Fatal("Should fail to validate an invalid permitLogin")
+	}
+
+	rec := cmd.NewRecorder()
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	if err = (&Root{Lock: true}).apply(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCmds := []string{"usermod --root " + dir + " --lock root"}
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expectedCmds) {
+		t.Fatalf("Invalid commands: %v, expected: %v", lines, expectedCmds)
+	}
+}
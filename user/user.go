@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/clearlinux/clr-installer/cmd"
@@ -21,17 +22,28 @@ import (
 	"github.com/clearlinux/clr-installer/utils"
 )
 
-// User abstracts a target system definition
+// User abstracts a target system definition, a zero UID or GID is allocated by useradd
 type User struct {
 	Login    string   `yaml:"login,omitempty"`
 	UserName string   `yaml:"username,omitempty,flow"`
 	Password string   `yaml:"password,omitempty,flow"`
 	Admin    bool     `yaml:"admin,omitempty,flow"`
 	SSHKeys  []string `yaml:"ssh-keys,omitempty,flow"`
+	Groups   []string `yaml:"groups,omitempty,flow"`
+	UID      int      `yaml:"uid,omitempty"`
+	GID      int      `yaml:"gid,omitempty"`
+	Shell    string   `yaml:"shell,omitempty"`
+	Home     string   `yaml:"home,omitempty"`
+	System   bool     `yaml:"system,omitempty"`
 }
 
 const (
-	defaultUsersFile = "/usr/share/defaults/etc/passwd"
+	defaultUsersFile  = "/usr/share/defaults/etc/passwd"
+	defaultGroupsFile = "/usr/share/defaults/etc/group"
+	// MaxID is the highest possible UID or GID, 65535 is reserved
+	MaxID = 65534
+	// adminGroup is the supplementary group of the administrative users
+	adminGroup = "wheel"
 	// MaxUsernameLength is the longest possible username
 	MaxUsernameLength = 64
 	// MaxLoginLength is the longest possible login
@@ -48,6 +60,7 @@ const (
 var (
 	usernameExp     = regexp.MustCompile("^([a-zA-Z]+[0-9a-zA-Z-_ ,'.]*|)$")
 	loginExp        = regexp.MustCompile("^[a-zA-Z]+[0-9a-zA-Z-_]*$")
+	groupExp        = regexp.MustCompile("^[a-zA-Z_]+[0-9a-zA-Z-_]*$")
 	sysDefaultUsers = []string{}
 )
 
@@ -125,6 +138,209 @@ func (u *User) Equals(usr *User) bool {
 	return u == usr || u.Login == usr.Login
 }
 
+// HomeDir returns the user's home directory
+func (u *User) HomeDir() string {
+	if u.Home != "" {
+		return u.Home
+	}
+
+	return filepath.Join("/home", u.Login)
+}
+
+// Validate checks the user's login, ids, groups, shell and home directory
+func (u *User) Validate() error {
+	if ok, msg := IsValidLogin(u.Login); !ok {
+		return errors.ValidationErrorf("Invalid user login %q: %s", u.Login, msg)
+	}
+
+	if ok, msg := IsValidID(u.UID); !ok {
+		return errors.ValidationErrorf("%s: invalid UID %d: %s", u.Login, u.UID, msg)
+	}
+
+	if ok, msg := IsValidID(u.GID); !ok {
+		return errors.ValidationErrorf("%s: invalid GID %d: %s", u.Login, u.GID, msg)
+	}
+
+	for _, curr := range u.Groups {
+		if ok, msg := IsValidGroup(curr); !ok {
+			return errors.ValidationErrorf("%s: invalid group %q: %s", u.Login, curr, msg)
+		}
+	}
+
+	if ok, msg := IsValidPath(u.Shell); !ok {
+		return errors.ValidationErrorf("%s: invalid shell %q: %s", u.Login, u.Shell, msg)
+	}
+
+	if ok, msg := IsValidPath(u.Home); !ok {
+		return errors.ValidationErrorf("%s: invalid home directory %q: %s", u.Login, u.Home, msg)
+	}
+
+	return nil
+}
+
+// ValidateUsers checks every user, that no two users share a login or UID and that
+// the logins and ids don't conflict with the system's default users and groups
+func ValidateUsers(users []*User) error {
+	logins := map[string]bool{}
+	uids := map[int]string{}
+
+	for _, curr := range users {
+		if err := curr.Validate(); err != nil {
+			return err
+		}
+
+		if logins[curr.Login] {
+			return errors.ValidationErrorf("Duplicated user login: %s", curr.Login)
+		}
+		logins[curr.Login] = true
+
+		if curr.UID == 0 {
+			continue
+		}
+
+		if other, ok := uids[curr.UID]; ok {
+			return errors.ValidationErrorf("Users %s and %s have the same UID: %d",
+				other, curr.Login, curr.UID)
+		}
+		uids[curr.UID] = curr.Login
+	}
+
+	return validateIDs("/", users)
+}
+
+// validateIDs checks the users against the default users and groups of the system
+// at rootDir: the installer system and the target share the same defaults, the users
+// are validated before the target is installed. A login or UID can't be reused and
+// a user's own primary group, created if its GID is not defined, must be available
+func validateIDs(rootDir string, users []*User) error {
+	passwd := loadIDs(rootDir, defaultUsersFile)
+	groups := loadIDs(rootDir, defaultGroupsFile)
+
+	for _, curr := range users {
+		if _, ok := passwd[curr.Login]; ok {
+			return errors.ValidationErrorf("%s: the login is already defined by the system", curr.Login)
+		}
+
+		for login, uid := range passwd {
+			if curr.UID != 0 && uid == curr.UID {
+				return errors.ValidationErrorf("%s: UID %d is already used by the system user %s",
+					curr.Login, curr.UID, login)
+			}
+		}
+
+		if curr.GID != 0 && hasID(groups, curr.GID) {
+			continue
+		}
+
+		if gid, ok := groups[curr.Login]; ok {
+			return errors.ValidationErrorf("%s: the group %s is already defined by the system with GID %d, "+
+				"set it as the user's GID", curr.Login, curr.Login, gid)
+		}
+
+		groups[curr.Login] = curr.GID
+	}
+
+	return nil
+}
+
+// hasID returns true if any of the ids is id
+func hasID(ids map[string]int, id int) bool {
+	for _, curr := range ids {
+		if curr == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadGroups returns the GIDs of the groups defined in rootDir, both the system's
+// default groups and the ones added to /etc/group
+func loadGroups(rootDir string) map[string]int {
+	return loadIDs(rootDir, defaultGroupsFile, "/etc/group")
+}
+
+// loadIDs returns the ids, by name, of the passwd or group formatted files in rootDir,
+// the missing files are ignored
+func loadIDs(rootDir string, files ...string) map[string]int {
+	res := map[string]int{}
+
+	for _, curr := range files {
+		content, err := ioutil.ReadFile(filepath.Join(rootDir, curr))
+		if err != nil {
+			continue
+		}
+
+		for _, line := range strings.Split(string(content), "\n") {
+			tks := strings.Split(line, ":")
+			if len(tks) < 3 {
+				continue
+			}
+
+			id, err := strconv.Atoi(tks[2])
+			if err != nil {
+				continue
+			}
+
+			res[tks[0]] = id
+		}
+	}
+
+	return res
+}
+
+// getGroupAddCommands returns the groupadd commands creating the user's groups
+// missing in rootDir: the primary group, when the GID is not defined yet, and the
+// supplementary groups
+func (u *User) getGroupAddCommands(rootDir string) [][]string {
+	res := [][]string{}
+	groups := loadGroups(rootDir)
+
+	groupAdd := func(name string, gid int) []string {
+		args := []string{"groupadd", "--root", rootDir}
+
+		if gid != 0 {
+			args = append(args, "--gid", strconv.Itoa(gid))
+		}
+
+		if u.System {
+			args = append(args, "--system")
+		}
+
+		return append(args, name)
+	}
+
+	if u.GID != 0 {
+		if !hasID(groups, u.GID) {
+			res = append(res, groupAdd(u.Login, u.GID))
+			groups[u.Login] = u.GID
+		}
+	}
+
+	for _, curr := range u.Groups {
+		if _, ok := groups[curr]; ok {
+			continue
+		}
+
+		res = append(res, groupAdd(curr, 0))
+		groups[curr] = 0
+	}
+
+	return res
+}
+
+// supplementaryGroups returns the user's supplementary groups, the administrative
+// users are also added to the wheel group
+func (u *User) supplementaryGroups() []string {
+	res := append([]string{}, u.Groups...)
+
+	if u.Admin && !utils.StringSliceContains(res, adminGroup) {
+		res = append(res, adminGroup)
+	}
+
+	return res
+}
+
 // setTempTargetPAMConfig copy the temporary chpasswd PAM config to target system
 // this is required for changing user's password into target system.
 func setTempTargetPAMConfig(rootDir string) error {
@@ -173,39 +389,70 @@ func Apply(rootDir string, users []*User) error {
 	return nil
 }
 
-// apply applies the user configuration to the target install
-func (u *User) apply(rootDir string) error {
+// getUserAddCommand returns the useradd command creating the user in rootDir
+func (u *User) getUserAddCommand(rootDir string) []string {
 	args := []string{
 		"useradd",
 		"--root",
 		rootDir,
 		"--comment",
 		u.UserName,
-		u.Login,
 	}
 
-	if u.Admin {
-		args = append(args, []string{
-			"-G",
-			"wheel",
-		}...)
+	if u.UID != 0 {
+		args = append(args, "--uid", strconv.Itoa(u.UID))
 	}
 
-	if err := cmd.RunAndLog(args...); err != nil {
-		return errors.Wrap(err)
+	if u.GID != 0 {
+		args = append(args, "--gid", strconv.Itoa(u.GID))
 	}
 
-	if u.Password != "" {
-		args = []string{
-			"chpasswd",
-			"--root",
-			rootDir,
-			"-e",
+	if groups := u.supplementaryGroups(); len(groups) > 0 {
+		args = append(args, "-G", strings.Join(groups, ","))
+	}
+
+	if u.Shell != "" {
+		args = append(args, "--shell", u.Shell)
+	}
+
+	if u.Home != "" {
+		args = append(args, "--home-dir", u.Home)
+	}
+
+	if u.System {
+		args = append(args, "--system")
+	}
+
+	return append(args, u.Login)
+}
+
+// getChpasswdCommand returns the chpasswd command setting the user's password in
+// rootDir, the encrypted password is read from stdin
+func getChpasswdCommand(rootDir string) []string {
+	return []string{
+		"chpasswd",
+		"--root",
+		rootDir,
+		"-e",
+	}
+}
+
+// apply applies the user configuration to the target install
+func (u *User) apply(rootDir string) error {
+	for _, curr := range u.getGroupAddCommands(rootDir) {
+		if err := cmd.RunAndLog(curr...); err != nil {
+			return errors.Wrap(err)
 		}
+	}
 
+	if err := cmd.RunAndLog(u.getUserAddCommand(rootDir)...); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if u.Password != "" {
 		pwd := fmt.Sprintf("%s:%s", u.Login, u.Password)
 
-		if err := cmd.PipeRunAndLog(pwd, args...); err != nil {
+		if err := cmd.PipeRunAndLog(pwd, getChpasswdCommand(rootDir)...); err != nil {
 			return errors.Wrap(err)
 		}
 	}
@@ -220,8 +467,30 @@ func (u *User) apply(rootDir string) error {
 }
 
 func writeSSHKey(rootDir string, u *User) error {
-	home := filepath.Join("home", u.Login, ".ssh")
-	dpath := filepath.Join(rootDir, home)
+	home := filepath.Join(u.HomeDir(), ".ssh")
+
+	if err := writeAuthorizedKeys(filepath.Join(rootDir, home), u.SSHKeys); err != nil {
+		return err
+	}
+
+	args := []string{
+		"chroot",
+		rootDir,
+		"/usr/bin/chown",
+		"-R",
+		fmt.Sprintf("%s:", u.Login),
+		home,
+	}
+
+	if err := cmd.RunAndLog(args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeAuthorizedKeys writes keys to the authorized_keys file of the dpath ssh directory
+func writeAuthorizedKeys(dpath string, keys []string) error {
 	fpath := filepath.Join(dpath, "authorized_keys")
 
 	if err := utils.MkdirAll(dpath, 0700); err != nil {
@@ -237,7 +506,7 @@ func writeSSHKey(rootDir string, u *User) error {
 		_ = f.Close()
 	}()
 
-	cnt := fmt.Sprintf("%s\n", strings.Join(u.SSHKeys, "\n"))
+	cnt := fmt.Sprintf("%s\n", strings.Join(keys, "\n"))
 	bt := []byte(cnt)
 	n, err := f.Write(bt)
 	if err != nil {
@@ -248,19 +517,6 @@ func writeSSHKey(rootDir string, u *User) error {
 		return errors.Errorf("Failed to write ssh key, wrote %d of %d bytes", n, len(bt))
 	}
 
-	args := []string{
-		"chroot",
-		rootDir,
-		"/usr/bin/chown",
-		"-R",
-		fmt.Sprintf("%s:%s", u.Login, u.Login),
-		home,
-	}
-
-	if err := cmd.RunAndLog(args...); err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -294,6 +550,46 @@ func IsValidLogin(login string) (bool, string) {
 	return true, ""
 }
 
+// IsValidID checks an explicit UID or GID, zero lets useradd allocate the id
+func IsValidID(id int) (bool, string) {
+	if id < 0 || id > MaxID {
+		return false, fmt.Sprintf("The id must be between 1 and %d", MaxID)
+	}
+
+	return true, ""
+}
+
+// IsValidGroup checks the group name restrictions
+func IsValidGroup(group string) (bool, string) {
+	if len(group) > MaxLoginLength {
+		return false, fmt.Sprintf("Group maximum length is %d", MaxLoginLength)
+	}
+
+	if !groupExp.MatchString(group) {
+		return false, "Group must contain only numbers, letters, - or _"
+	}
+
+	return true, ""
+}
+
+// IsValidPath checks the shell and home directory paths, an empty path uses the
+// useradd default
+func IsValidPath(path string) (bool, string) {
+	if path == "" {
+		return true, ""
+	}
+
+	if !filepath.IsAbs(path) {
+		return false, "Path must be absolute"
+	}
+
+	if strings.ContainsAny(path, ":\r\n") {
+		return false, "Path must not contain colons or line breaks"
+	}
+
+	return true, ""
+}
+
 // IsValidPassword checks the minimum password requirements
 func IsValidPassword(pwd string) (bool, string) {
 	if pwd == "" {
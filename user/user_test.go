@@ -0,0 +1,165 @@
+// Copyright © 2018 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/clearlinux/clr-installer/cmd"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		user  *User
+		valid bool
+	}{
+		{&User{Login: "jdoe"}, true},
+		{&User{Login: "jdoe", UID: 1500, GID: 1500, Groups: []string{"docker", "kvm"},
+			Shell: "/bin/zsh", Home: "/srv/jdoe"}, true},
+		{&User{Login: "backup", System: true, UID: 950}, true},
+		{&User{Login: ""}, false},
+		{&User{Login: "jdoe", UID: -1}, false},
+		{&User{Login: "jdoe", GID: MaxID + 1}, false},
+		{&User{Login: "jdoe", Groups: []string{"bad group"}}, false},
+		{&User{Login: "jdoe", Shell: "zsh"}, false},
+		{&User{Login: "jdoe", Home: "/home/jdoe:x"}, false},
+	}
+
+	for _, curr := range tests {
+		err := curr.user.Validate()
+
+		if curr.valid && err != nil {
+			t.Fatalf("%+v should be valid: %v", curr.user, err)
+		}
+
+		if !curr.valid && err == nil {
+			t.Fatalf("%+v should be invalid", curr.user)
+		}
+	}
+
+	users := []*User{{Login: "jdoe", UID: 1500}, {Login: "alice", UID: 1500}}
+	if err := ValidateUsers(users); err == nil {
+		t.Fatal("Users sharing a UID should be invalid")
+	}
+
+	users = []*User{{Login: "jdoe"}, {Login: "jdoe"}}
+	if err := ValidateUsers(users); err == nil {
+		t.Fatal("Users sharing a login should be invalid")
+	}
+}
+
+func TestValidateIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err = os.MkdirAll(filepath.Join(dir, filepath.Dir(defaultUsersFile)), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	passwd := "root:x:0:0:root:/root:/bin/bash\nmail:x:8:12:mail:/var/mail:/bin/false\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, defaultUsersFile), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	group := "root:x:0:\nmail:x:12:\ndocker:x:990:\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, defaultGroupsFile), []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		users []*User
+		valid bool
+	}{
+		{[]*User{{Login: "jdoe", UID: 1500, GID: 1500}, {Login: "alice", GID: 1500}}, true},
+		{[]*User{{Login: "docker", GID: 990}}, true},
+		{[]*User{{Login: "mail"}}, false},
+		{[]*User{{Login: "jdoe", UID: 8}}, false},
+		{[]*User{{Login: "docker"}}, false},
+		{[]*User{{Login: "docker", GID: 1500}}, false},
+	}
+
+	for _, curr := range tests {
+		err = validateIDs(dir, curr.users)
+
+		if curr.valid && err != nil {
+			t.Fatalf("%+v should be valid: %v", curr.users[0], err)
+		}
+
+		if !curr.valid && err == nil {
+			t.Fatalf("%+v should be invalid", curr.users[0])
+		}
+	}
+}
+
+func TestRecordedApply(t *testing.T) {
+	rec := cmd.NewRecorder()
+	defer cmd.SetExecutor(cmd.SetExecutor(rec))
+
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	groupsDir := filepath.Join(dir, filepath.Dir(defaultGroupsFile))
+	if err = os.MkdirAll(groupsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "root:x:0:\nwheel:x:10:\nkvm:x:34:\nusers:x:100:\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, defaultGroupsFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	usr := &User{
+		Login:    "jdoe",
+		UserName: "John Doe",
+		Admin:    true,
+		Groups:   []string{"kvm", "docker"},
+		UID:      1500,
+		GID:      1500,
+		Shell:    "/bin/zsh",
+		Home:     "/srv/jdoe",
+	}
+
+	expected := []string{
+		"groupadd --root " + dir + " --gid 1500 jdoe",
+		"groupadd --root " + dir + " docker",
+		"useradd --root " + dir + " --comment John Doe --uid 1500 --gid 1500 -G kvm,docker,wheel " +
+			"--shell /bin/zsh --home-dir /srv/jdoe jdoe",
+	}
+
+	if err = usr.apply(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Invalid commands: %v, expected: %v", lines, expected)
+	}
+
+	rec.Reset()
+
+	// the users group already has the GID 100
+	usr = &User{Login: "backup", UserName: "Backup", GID: 100, System: true}
+
+	expected = []string{
+		"useradd --root " + dir + " --comment Backup --gid 100 --system backup",
+	}
+
+	if err = usr.apply(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := rec.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Invalid commands: %v, expected: %v", lines, expected)
+	}
+}